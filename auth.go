@@ -0,0 +1,45 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"os"
+)
+
+// password returns the configured password, preferring the environment.
+func (a *Auth) password() string {
+	if a.PasswordEnv != "" {
+		return os.Getenv(a.PasswordEnv)
+	}
+	return a.Password
+}
+
+// tlsConfig builds the client TLS configuration of a context.
+func tlsConfig(t TLS) (*tls.Config, error) {
+	config := &tls.Config{InsecureSkipVerify: t.Insecure}
+
+	if t.CA != "" {
+		pem, err := ioutil.ReadFile(t.CA)
+		if err != nil {
+			return nil, err
+		}
+
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("%s: no certificates", t.CA)
+		}
+		config.RootCAs = pool
+	}
+
+	if t.Cert != "" || t.Key != "" {
+		cert, err := tls.LoadX509KeyPair(t.Cert, t.Key)
+		if err != nil {
+			return nil, err
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+
+	return config, nil
+}
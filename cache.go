@@ -0,0 +1,151 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/dbulkow/registry_cmd/registry"
+	humanize "github.com/dustin/go-humanize"
+	"github.com/spf13/cobra"
+)
+
+var nocache bool
+
+// cache is the response cache, nil when disabled.
+var cache registry.Cache
+
+var cachettl time.Duration
+
+// mutating annotates commands that write to the registry. They resolve
+// tags with the registry, revalidating cached ones, so as not to act on an
+// image a tag no longer points to.
+var mutating = map[string]string{mutatingKey: "true"}
+
+const mutatingKey = "regcmd/mutating"
+
+var pruneage time.Duration
+var pruneall bool
+
+func init() {
+	cacheCmd := &cobra.Command{
+		Use:   "cache",
+		Short: "Manage the local manifest and blob metadata cache",
+		Long: `Manage the local manifest and blob metadata cache.
+
+Manifests, image configs and blob sizes are stored by digest, and since
+content cannot change under a digest they never expire. Tags are
+remembered for --cache-ttl, after which they are revalidated with the
+registry using If-None-Match. Commands that change the registry, such as
+delete, sign and mutate, revalidate every tag they read.
+
+The cache lives in $XDG_CACHE_HOME/regcmd, usually ~/.cache/regcmd.
+`,
+		PersistentPreRun: func(cmd *cobra.Command, args []string) {},
+	}
+
+	statsCmd := &cobra.Command{
+		Use:   "stats",
+		Short: "Show cache usage",
+		RunE:  cachestats,
+	}
+
+	pruneCmd := &cobra.Command{
+		Use:   "prune",
+		Short: "Remove cache entries not used recently",
+		RunE:  cacheprune,
+	}
+
+	pruneCmd.Flags().DurationVar(&pruneage, "older-than", 30*24*time.Hour, "Remove entries unused for this long")
+	pruneCmd.Flags().BoolVarP(&pruneall, "all", "a", false, "Remove every entry")
+
+	cacheCmd.AddCommand(statsCmd)
+	cacheCmd.AddCommand(pruneCmd)
+
+	RootCmd.AddCommand(cacheCmd)
+}
+
+func cacheDir() (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "regcmd"), nil
+}
+
+// openCache returns the disk cache, or nil when there is no cache
+// directory.
+func openCache(ttl time.Duration) registry.Cache {
+	dir, err := cacheDir()
+	if err != nil {
+		return nil
+	}
+	return registry.NewDiskCache(dir, ttl)
+}
+
+func dirUsage(dir string) (int, int64) {
+	var files int
+	var bytes int64
+
+	filepath.Walk(dir, func(path string, fi os.FileInfo, err error) error {
+		if err == nil && fi.Mode().IsRegular() {
+			files++
+			bytes += fi.Size()
+		}
+		return nil
+	})
+
+	return files, bytes
+}
+
+func cachestats(cmd *cobra.Command, args []string) error {
+	dir, err := cacheDir()
+	if err != nil {
+		return err
+	}
+
+	data, databytes := dirUsage(filepath.Join(dir, "data"))
+	meta, metabytes := dirUsage(filepath.Join(dir, "meta"))
+	tags, tagbytes := dirUsage(filepath.Join(dir, "tags"))
+
+	fmt.Printf("location %s\n", dir)
+	fmt.Printf("content  %6d entries %10s\n", data, humanize.Bytes(uint64(databytes)))
+	fmt.Printf("metadata %6d entries %10s\n", meta, humanize.Bytes(uint64(metabytes)))
+	fmt.Printf("tags     %6d entries %10s\n", tags, humanize.Bytes(uint64(tagbytes)))
+
+	return nil
+}
+
+func cacheprune(cmd *cobra.Command, args []string) error {
+	dir, err := cacheDir()
+	if err != nil {
+		return err
+	}
+
+	if pruneall {
+		if err := os.RemoveAll(dir); err != nil {
+			return err
+		}
+		fmt.Printf("removed %s\n", dir)
+		return nil
+	}
+
+	var removed int
+	var freed int64
+
+	filepath.Walk(dir, func(path string, fi os.FileInfo, err error) error {
+		if err != nil || !fi.Mode().IsRegular() || time.Since(fi.ModTime()) < pruneage {
+			return nil
+		}
+		if os.Remove(path) == nil {
+			removed++
+			freed += fi.Size()
+		}
+		return nil
+	})
+
+	fmt.Printf("removed %d entries, %s\n", removed, humanize.Bytes(uint64(freed)))
+
+	return nil
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/dbulkow/registry_cmd/registry"
+	"github.com/dbulkow/registry_cmd/registry/registrytest"
+)
+
+func TestCache(t *testing.T) {
+	e := seeded(t)
+
+	contains(t, e.ok("cache", "stats"), "content       0 entries")
+
+	e.ok("ls-files", "app/web:1.0")
+	gets := e.reg.Count("GET", "/manifests/")
+
+	// manifests come from the cache the second time
+	e.ok("ls-files", "app/web:1.0")
+	if n := e.reg.Count("GET", "/manifests/"); n != gets {
+		t.Errorf("%d manifest GETs after a cached run, want %d", n, gets)
+	}
+
+	out := e.ok("cache", "stats")
+	lacks(t, out, "content       0 entries")
+
+	e.ok("--no-cache", "ls-files", "app/web:1.0")
+	if n := e.reg.Count("GET", "/manifests/"); n == gets {
+		t.Error("--no-cache run used the cache")
+	}
+
+	contains(t, e.ok("cache", "prune"), "removed 0 entries")
+	contains(t, e.ok("cache", "prune", "--older-than", "0s"), "removed")
+	contains(t, e.ok("cache", "prune", "--all"), "removed ")
+	contains(t, e.ok("cache", "stats"), "content       0 entries")
+}
+
+func TestCacheMutating(t *testing.T) {
+	e := seeded(t)
+
+	// cache the tags, then move them behind the cache's back
+	e.ok("ls-files", "app/web:1.0")
+	e.ok("ls-files", "app/web:2.0")
+
+	_, old, _ := e.reg.Manifest("app/web", "2.0")
+	for _, tag := range []string{"1.0", "2.0"} {
+		e.reg.PushImage("app/web", tag, registrytest.Image{
+			Created: "2024-09-01T00:00:00Z",
+			Config:  registry.ContainerConfig{Env: []string{"V=" + tag}},
+			Layers:  [][]byte{baseLayer, apiLayer},
+		})
+	}
+	_, repushed, _ := e.reg.Manifest("app/web", "2.0")
+	_, resigned, _ := e.reg.Manifest("app/web", "1.0")
+
+	// read-only commands trust the tags for the ttl
+	lacks(t, e.ok("ls-files", "app/web:2.0"), "/app/api")
+
+	// deleting and signing act on what the tags point to now
+	e.ok("rm", "app/web:2.0")
+	if _, _, ok := e.reg.Manifest("app/web", string(registry.FromBytes(repushed))); ok {
+		t.Error("the re-pushed image was not deleted")
+	}
+	if _, _, ok := e.reg.Manifest("app/web", string(registry.FromBytes(old))); !ok {
+		t.Error("the image the cache remembered was deleted")
+	}
+
+	key, _ := writeKey(t)
+	e.ok("sign", "--key", key, "app/web:1.0")
+	if _, _, ok := e.reg.Manifest("app/web", registry.FromBytes(resigned).Tag(".sig")); !ok {
+		t.Error("the re-pushed image was not the one signed")
+	}
+}
@@ -0,0 +1,358 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/spf13/cobra"
+	yaml "gopkg.in/yaml.v3"
+)
+
+// Context is a named registry with the settings used to reach it.
+type Context struct {
+	Registry    string `yaml:"registry"`
+	Auth        Auth   `yaml:"auth,omitempty"`
+	TLS         TLS    `yaml:"tls,omitempty"`
+	Concurrency int    `yaml:"concurrency,omitempty"`
+	Output      string `yaml:"output,omitempty"`
+}
+
+type Auth struct {
+	// Method is none, basic or token. Token uses the registry token
+	// service named in the WWW-Authenticate challenge.
+	Method      string `yaml:"method,omitempty"`
+	Username    string `yaml:"username,omitempty"`
+	Password    string `yaml:"password,omitempty"`
+	PasswordEnv string `yaml:"password-env,omitempty"`
+}
+
+type TLS struct {
+	CA       string `yaml:"ca,omitempty"`
+	Cert     string `yaml:"cert,omitempty"`
+	Key      string `yaml:"key,omitempty"`
+	Insecure bool   `yaml:"insecure,omitempty"`
+}
+
+type ConfigFile struct {
+	CurrentContext string              `yaml:"current-context,omitempty"`
+	Contexts       map[string]*Context `yaml:"contexts,omitempty"`
+}
+
+var contextname string
+
+// current is the context selected for this run, nil when none is.
+var current *Context
+
+var ctxadd Context
+
+func init() {
+	contextCmd := &cobra.Command{
+		Use:   "context",
+		Short: "Manage named registry contexts",
+		Long: `Manage named registry contexts.
+
+Contexts are kept in $XDG_CONFIG_HOME/regcmd/config.yaml, usually
+~/.config/regcmd/config.yaml:
+
+  current-context: dev
+  contexts:
+    dev:
+      registry: https://registry.dev.example.com
+      auth:
+        method: token
+        username: ci
+        password-env: REGISTRY_PASSWORD
+      tls:
+        ca: /etc/regcmd/dev-ca.pem
+      concurrency: 8
+      output: cyclonedx
+
+The context is chosen by --context, then REGCMD_CONTEXT, then
+current-context. --registry and REGISTRY override its registry; its
+credentials and certificates are then only used if the override is the
+same host, or else those of a context for the new host.
+`,
+		PersistentPreRun: func(cmd *cobra.Command, args []string) {},
+	}
+
+	listCmd := &cobra.Command{
+		Use:     "list",
+		Aliases: []string{"ls"},
+		Short:   "List contexts",
+		RunE:    contextlist,
+	}
+
+	useCmd := &cobra.Command{
+		Use:   "use <name>",
+		Short: "Make a context the current context",
+		RunE:  contextuse,
+	}
+
+	addCmd := &cobra.Command{
+		Use:   "add <name>",
+		Short: "Add or replace a context",
+		RunE:  contextadd,
+	}
+
+	flags := addCmd.Flags()
+	flags.StringVar(&ctxadd.Registry, "url", "", "Base URL for registry")
+	flags.StringVar(&ctxadd.Auth.Method, "auth", "", "Authentication method (none, basic, token)")
+	flags.StringVar(&ctxadd.Auth.Username, "username", "", "Username")
+	flags.StringVar(&ctxadd.Auth.PasswordEnv, "password-env", "", "Environment variable holding the password")
+	flags.StringVar(&ctxadd.TLS.CA, "tlscacert", "", "CA certificate file")
+	flags.StringVar(&ctxadd.TLS.Cert, "tlscert", "", "Client certificate file")
+	flags.StringVar(&ctxadd.TLS.Key, "tlskey", "", "Client key file")
+	flags.BoolVar(&ctxadd.TLS.Insecure, "insecure", false, "Skip TLS verification")
+	flags.IntVar(&ctxadd.Concurrency, "concurrency", 0, "Default number of concurrent requests")
+	flags.StringVar(&ctxadd.Output, "output", "", "Default output format")
+
+	removeCmd := &cobra.Command{
+		Use:     "remove <name>",
+		Aliases: []string{"rm"},
+		Short:   "Remove a context",
+		RunE:    contextremove,
+	}
+
+	contextCmd.AddCommand(listCmd)
+	contextCmd.AddCommand(useCmd)
+	contextCmd.AddCommand(addCmd)
+	contextCmd.AddCommand(removeCmd)
+
+	RootCmd.AddCommand(contextCmd)
+}
+
+func configPath() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "regcmd", "config.yaml"), nil
+}
+
+func loadConfig() (*ConfigFile, error) {
+	path, err := configPath()
+	if err != nil {
+		return nil, err
+	}
+
+	c := &ConfigFile{Contexts: make(map[string]*Context)}
+
+	data, err := ioutil.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return c, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := yaml.Unmarshal(data, c); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+
+	if c.Contexts == nil {
+		c.Contexts = make(map[string]*Context)
+	}
+
+	return c, nil
+}
+
+func (c *ConfigFile) save() error {
+	path, err := configPath()
+	if err != nil {
+		return err
+	}
+
+	data, err := yaml.Marshal(c)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(path, data, 0600)
+}
+
+// selectContext returns the context named by --context, REGCMD_CONTEXT or
+// the config file, or nil when none is configured.
+func selectContext() (*Context, error) {
+	c, err := loadConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	name := contextname
+	if name == "" {
+		name = os.Getenv("REGCMD_CONTEXT")
+	}
+	if name == "" {
+		name = c.CurrentContext
+	}
+	if name == "" {
+		return nil, nil
+	}
+
+	ctx, ok := c.Contexts[name]
+	if !ok {
+		return nil, fmt.Errorf("context %q not found", name)
+	}
+
+	return ctx, nil
+}
+
+// contextFor returns the context whose registry is on domain, preferring
+// the selected one, or nil when there is none.
+func contextFor(domain string) (*Context, error) {
+	if current != nil && urlDomain(current.Registry) == domain {
+		return current, nil
+	}
+
+	c, err := loadConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0, len(c.Contexts))
+	for name := range c.Contexts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if urlDomain(c.Contexts[name].Registry) == domain {
+			return c.Contexts[name], nil
+		}
+	}
+
+	return nil, nil
+}
+
+func contextlist(cmd *cobra.Command, args []string) error {
+	c, err := loadConfig()
+	if err != nil {
+		return err
+	}
+
+	names := make([]string, 0, len(c.Contexts))
+	for name := range c.Contexts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	n := len("NAME")
+	for _, name := range names {
+		if n < len(name) {
+			n = len(name)
+		}
+	}
+
+	fmt.Printf("  %-*s %-8s %s\n", n, "NAME", "AUTH", "REGISTRY")
+	for _, name := range names {
+		mark := " "
+		if name == c.CurrentContext {
+			mark = "*"
+		}
+
+		ctx := c.Contexts[name]
+
+		auth := ctx.Auth.Method
+		if auth == "" {
+			auth = "none"
+		}
+
+		fmt.Printf("%s %-*s %-8s %s\n", mark, n, name, auth, ctx.Registry)
+	}
+
+	return nil
+}
+
+func contextuse(cmd *cobra.Command, args []string) error {
+	if len(args) != 1 {
+		return usageError(cmd)
+	}
+
+	c, err := loadConfig()
+	if err != nil {
+		return err
+	}
+
+	if _, ok := c.Contexts[args[0]]; !ok {
+		return fmt.Errorf("context %q not found", args[0])
+	}
+
+	c.CurrentContext = args[0]
+
+	if err := c.save(); err != nil {
+		return err
+	}
+
+	fmt.Printf("using context %s\n", args[0])
+
+	return nil
+}
+
+func contextadd(cmd *cobra.Command, args []string) error {
+	if len(args) != 1 || ctxadd.Registry == "" {
+		return usageError(cmd)
+	}
+
+	switch ctxadd.Auth.Method {
+	case "", "none", "basic", "token":
+	default:
+		return fmt.Errorf("unknown auth method %q", ctxadd.Auth.Method)
+	}
+
+	c, err := loadConfig()
+	if err != nil {
+		return err
+	}
+
+	ctx := ctxadd
+	c.Contexts[args[0]] = &ctx
+
+	if c.CurrentContext == "" {
+		c.CurrentContext = args[0]
+	}
+
+	if err := c.save(); err != nil {
+		return err
+	}
+
+	fmt.Printf("added context %s\n", args[0])
+
+	return nil
+}
+
+func contextremove(cmd *cobra.Command, args []string) error {
+	if len(args) != 1 {
+		return usageError(cmd)
+	}
+
+	c, err := loadConfig()
+	if err != nil {
+		return err
+	}
+
+	if _, ok := c.Contexts[args[0]]; !ok {
+		return fmt.Errorf("context %q not found", args[0])
+	}
+
+	delete(c.Contexts, args[0])
+
+	if c.CurrentContext == args[0] {
+		c.CurrentContext = ""
+	}
+
+	if err := c.save(); err != nil {
+		return err
+	}
+
+	fmt.Printf("removed context %s\n", args[0])
+
+	return nil
+}
@@ -0,0 +1,27 @@
+package main
+
+import "testing"
+
+func TestContext(t *testing.T) {
+	e := seeded(t)
+	e.env = append(e.env, "REGISTRY=")
+
+	contains(t, e.ok("context", "add", "one", "--url", e.reg.URL), "added context one")
+	contains(t, e.ok("context", "add", "two", "--url", "http://127.0.0.1:1", "--auth", "basic"), "added context two")
+
+	// the first context added becomes current
+	out := e.ok("context", "list")
+	contains(t, out, "* one", "none", "  two", "basic", "http://127.0.0.1:1")
+	contains(t, e.ok("list", "app/*"), "app/web:1.0")
+
+	contains(t, e.ok("context", "use", "two"), "using context two")
+	e.fails(ExitNetwork, "--retries", "0", "list")
+	contains(t, e.ok("--context", "one", "list", "app/*"), "app/api:latest")
+
+	contains(t, e.ok("context", "rm", "two"), "removed context two")
+	lacks(t, e.ok("context", "list"), "two")
+
+	e.fails(ExitFailure, "context", "use", "two")
+	e.fails(ExitFailure, "context", "add", "three", "--url", e.reg.URL, "--auth", "kerberos")
+	e.fails(ExitUsage, "context", "add", "three")
+}
@@ -1,115 +1,68 @@
 package main
 
 import (
-	"encoding/json"
 	"errors"
 	"fmt"
-	"io/ioutil"
-	"net/http"
-	"strings"
 
+	"github.com/dbulkow/registry_cmd/registry"
 	"github.com/spf13/cobra"
 )
 
-func connect(cmd *cobra.Command) (*http.Client, error) {
+// connect returns a client for the selected registry, configured from the
+// context and flags, once the registry has answered.
+func connect(cmd *cobra.Command) (*registry.Client, error) {
 	url := cmd.Flag("registry").Value.String()
-
-	// XXX construct client using TLS
-
-	client := &http.Client{Transport: &http.Transport{}}
-
-	resp, err := client.Get(url + "/v2/")
-	if err != nil {
-		return nil, err
+	if url == "" {
+		return nil, errors.New("please set registry location")
 	}
-	defer resp.Body.Close()
 
-	switch resp.StatusCode {
-	case http.StatusUnauthorized:
-		// error text in resp.Body
-		return nil, errors.New("take action based on WWW-Authenticate")
-	case http.StatusNotFound:
-		return nil, errors.New("registry does not support v2 API")
-	case http.StatusOK:
-		break
-	default:
-		return nil, fmt.Errorf("bad status (%s/v2/) %d", url, resp.StatusCode)
+	opts := []registry.Option{
+		registry.WithTimeout(reqtimeout),
+		registry.WithRetries(retries),
+		registry.WithRateLimit(ratelimit),
 	}
 
-	// consume body so connection can be reused
-	ioutil.ReadAll(resp.Body)
-
-	ver := resp.Header.Get("Docker-Distribution-API-Version")
-	if ver != "registry/2.0" {
-		return nil, errors.New("registry does not support v2 API")
+	if cache != nil {
+		opts = append(opts, registry.WithCache(cache))
 	}
 
-	return client, nil
-}
-
-type Decoder interface {
-	Method() string
-	SetHeaders(*http.Header)
-	UnmarshalJSON([]byte) error
-	ExtractHeaders(*http.Header)
-}
+	if current != nil && urlDomain(current.Registry) == urlDomain(url) {
+		config, err := tlsConfig(current.TLS)
+		if err != nil {
+			return nil, fmt.Errorf("tls: %v", err)
+		}
 
-func get(conn *http.Client, url string, dec Decoder) error {
-	req, err := http.NewRequest(dec.Method(), url, nil)
-	if err != nil {
-		return fmt.Errorf("new request: %v", err)
+		opts = append(opts,
+			registry.WithTLSConfig(config),
+			registry.WithAuth(registry.Auth{
+				Method:   current.Auth.Method,
+				Username: current.Auth.Username,
+				Password: current.Auth.password(),
+			}))
 	}
 
-	req.Header.Add("Accept", "application/vnd.docker.distribution.manifest.v2+json,application/vnd.docker.distribution.manifest.list.v2+json")
-
-	resp, err := conn.Do(req)
+	client, err := registry.New(url, opts...)
 	if err != nil {
-		return fmt.Errorf("get: %v", err)
+		return nil, err
 	}
-	defer resp.Body.Close()
 
-	switch resp.StatusCode {
-	case http.StatusOK:
-	case http.StatusAccepted:
-	case http.StatusNotFound:
-		//fmt.Println("Content-Type: ", resp.Header.Get("Content-Type"))
-		//fmt.Println("Content-Length: ", resp.Header.Get("Content-Length"))
-		if strings.HasPrefix(resp.Header.Get("Content-Type"), "application/json") {
-			if resp.Header.Get("Contenet-Length") == "None" {
-				return fmt.Errorf("bad status: %s", http.StatusText(resp.StatusCode))
-			}
-
-			body, err := ioutil.ReadAll(resp.Body)
-			if err != nil {
-				return fmt.Errorf("readall: %v", err)
-			}
-
-			var rpy struct {
-				Errors []struct {
-					Code    string `json:"code"`
-					Message string `json:"message"`
-				} `json:"errors"`
-			}
+	if err := client.Ping(runctx); err != nil {
+		return nil, err
+	}
 
-			if err := json.Unmarshal(body, &rpy); err != nil {
-				return fmt.Errorf("unmarshal not found: %v", err)
-			}
+	return client, nil
+}
 
-			if len(rpy.Errors) == 1 {
-				return fmt.Errorf("not found: code \"%s\" message \"%s\"", rpy.Errors[0].Code, rpy.Errors[0].Message)
-			}
-		}
-		fallthrough
-	default:
-		return fmt.Errorf("bad status: %s", http.StatusText(resp.StatusCode))
+// platform parses a --platform flag, nil when it is empty.
+func platform(s string) (*registry.Platform, error) {
+	if s == "" {
+		return nil, nil
 	}
 
-	body, err := ioutil.ReadAll(resp.Body)
+	p, err := registry.ParsePlatform(s)
 	if err != nil {
-		return fmt.Errorf("readall: %v", err)
+		return nil, &exitError{code: ExitUsage, err: err}
 	}
 
-	dec.ExtractHeaders(&resp.Header)
-
-	return dec.UnmarshalJSON(body)
+	return p, nil
 }
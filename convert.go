@@ -0,0 +1,511 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/dbulkow/registry_cmd/registry"
+	"github.com/spf13/cobra"
+)
+
+var convertto string
+
+func init() {
+	convertCmd := &cobra.Command{
+		Use:   "convert <image:tag> [<image:tag>]",
+		Short: "Convert an image to Docker schema 2 or OCI",
+		Long: `Rewrite an image as a Docker schema 2 or OCI image and push it, replacing
+the tag unless a destination is given. The new digest is reported.
+
+Manifest and config media types are rewritten, and manifest lists become
+OCI indexes and back, with every image they list converted. Layers are
+reused as they are, mounted from the source repository when the
+destination is another one. Docker manifests have no annotations, subject
+or zstd layers: converting to docker drops the first two and fails on the
+last. Attestations and other artifacts in an index are copied through
+unchanged, or dropped on the way to docker.
+
+Legacy schema 1 images convert too. Signed schema 1 manifests are only
+converted when every signature verifies. The image config is rebuilt from
+the v1Compatibility history, which means downloading each layer once to
+compute its uncompressed digest.
+`,
+		Annotations: mutating,
+		RunE:        convert,
+	}
+
+	convertCmd.Flags().StringVar(&convertto, "to", "docker", "Format to convert to (docker, oci)")
+
+	RootCmd.AddCommand(convertCmd)
+}
+
+// v1Compatibility is the part of a schema 1 history entry that carries
+// over to a schema 2 config history entry.
+type v1Compatibility struct {
+	Created         string `json:"created"`
+	Author          string `json:"author"`
+	Comment         string `json:"comment"`
+	ThrowAway       bool   `json:"throwaway"`
+	ContainerConfig struct {
+		Cmd []string `json:"Cmd"`
+	} `json:"container_config"`
+}
+
+// schema1Image rebuilds the config and manifest of a schema 1 image in
+// image, copying its layers to dst.
+func schema1Image(client *registry.Client, image, dst string, m *registry.Schema1) (*registry.Manifest, error) {
+	rootfs := registry.RootFS{Type: "layers", DiffIDs: []registry.Digest{}}
+	history := []registry.History{}
+
+	man := &registry.Manifest{
+		SchemaVersion: 2,
+		MediaType:     registry.MediaTypeManifest,
+		Layers:        []registry.Descriptor{},
+	}
+
+	type layer struct {
+		diffID registry.Digest
+		size   int64
+	}
+	seen := make(map[registry.Digest]layer)
+
+	// schema 1 lists the top layer first
+	for i := len(m.History) - 1; i >= 0; i-- {
+		if err := interrupted(); err != nil {
+			return nil, err
+		}
+
+		var v1 v1Compatibility
+		if err := json.Unmarshal([]byte(m.History[i].V1Compatibility), &v1); err != nil {
+			return nil, fmt.Errorf("history %d: %v", i, err)
+		}
+
+		history = append(history, registry.History{
+			Created:    v1.Created,
+			CreatedBy:  strings.Join(v1.ContainerConfig.Cmd, " "),
+			Author:     v1.Author,
+			Comment:    v1.Comment,
+			EmptyLayer: v1.ThrowAway,
+		})
+
+		if v1.ThrowAway {
+			continue
+		}
+
+		blob := m.FSLayers[i].BlobSum
+
+		l, ok := seen[blob]
+		if !ok {
+			diffID, size, err := layerDiffID(runctx, client, image, blob)
+			if err != nil {
+				return nil, err
+			}
+
+			if err := client.CopyBlob(runctx, dst, image, blob); err != nil {
+				return nil, fmt.Errorf("copy layer %s: %w", blob, err)
+			}
+
+			l = layer{diffID: diffID, size: size}
+			seen[blob] = l
+		}
+
+		rootfs.DiffIDs = append(rootfs.DiffIDs, l.diffID)
+		man.Layers = append(man.Layers, registry.Descriptor{
+			MediaType: registry.MediaTypeDockerLayer,
+			Size:      l.size,
+			Digest:    blob,
+		})
+	}
+
+	// the config is the top history entry, less the fields only the v1
+	// image format has, as docker builds it when pulling schema 1 images
+	config := make(map[string]json.RawMessage)
+	if err := json.Unmarshal([]byte(m.History[0].V1Compatibility), &config); err != nil {
+		return nil, fmt.Errorf("history 0: %v", err)
+	}
+
+	for _, k := range []string{"id", "parent", "Size", "parent_id", "layer_id", "throwaway"} {
+		delete(config, k)
+	}
+
+	set := map[string]interface{}{"rootfs": rootfs, "history": history}
+	if _, ok := config["architecture"]; !ok && m.Architecture != "" {
+		set["architecture"] = m.Architecture
+	}
+	if _, ok := config["os"]; !ok {
+		set["os"] = "linux"
+	}
+
+	for k, v := range set {
+		data, err := json.Marshal(v)
+		if err != nil {
+			return nil, err
+		}
+		config[k] = data
+	}
+
+	cdata, err := json.Marshal(config)
+	if err != nil {
+		return nil, err
+	}
+
+	cdigest, err := client.PushBlob(runctx, dst, cdata)
+	if err != nil {
+		return nil, fmt.Errorf("push config: %w", err)
+	}
+
+	man.Config = registry.Descriptor{
+		MediaType: registry.MediaTypeDockerConfig,
+		Size:      int64(len(cdata)),
+		Digest:    cdigest,
+	}
+
+	return man, nil
+}
+
+// layerTypes pairs the Docker and OCI media types of layers.
+var layerTypes = [][2]string{
+	{registry.MediaTypeDockerLayer, registry.MediaTypeOCILayer},
+	{"application/vnd.docker.image.rootfs.diff.tar", registry.MediaTypeOCILayerTar},
+	{registry.MediaTypeDockerForeignLayer, registry.MediaTypeOCIForeignLayer},
+}
+
+// layerType returns the media type of a layer in the other format.
+func layerType(mediaType string, oci bool) (string, error) {
+	for _, t := range layerTypes {
+		if mediaType == t[0] || mediaType == t[1] {
+			if oci {
+				return t[1], nil
+			}
+			return t[0], nil
+		}
+	}
+
+	if oci && mediaType == registry.MediaTypeOCILayerZstd {
+		return mediaType, nil
+	}
+
+	return "", fmt.Errorf("layer media type %s has no %s equivalent", mediaType, formatName(oci))
+}
+
+func formatName(oci bool) string {
+	if oci {
+		return "oci"
+	}
+	return "docker"
+}
+
+// converter rewrites manifests of repository from in one format, pushing
+// them and copying what they reference to repository to. With keep set,
+// manifests stay in the format they are in.
+type converter struct {
+	client   *registry.Client
+	from, to string
+	oci      bool
+	keep     bool
+
+	// layer, when set, rewrites a layer for a manifest in the target
+	// format and pushes the result, instead of the layer being copied.
+	layer func(l registry.Descriptor, oci bool) (registry.Descriptor, error)
+}
+
+// convert returns the media type and content of raw in the target format.
+func (c *converter) convert(raw *registry.RawManifest) (string, []byte, error) {
+	probe := struct {
+		SchemaVersion int                   `json:"schemaVersion"`
+		Manifests     []registry.Descriptor `json:"manifests"`
+	}{}
+
+	if err := json.Unmarshal(raw.Data, &probe); err != nil {
+		return "", nil, fmt.Errorf("manifest %s: %v", raw.Digest, err)
+	}
+
+	var m *registry.Manifest
+	var idx *registry.Index
+
+	switch {
+	case probe.SchemaVersion == 1:
+		// only what the signatures cover is converted
+		payload, err := registry.VerifySchema1(raw.Data)
+		if err != nil {
+			return "", nil, fmt.Errorf("manifest %s: %w", raw.Digest, err)
+		}
+
+		s1, err := registry.ParseSchema1(payload)
+		if err != nil {
+			return "", nil, err
+		}
+
+		if m, err = schema1Image(c.client, c.from, c.to, s1); err != nil {
+			return "", nil, err
+		}
+
+	case probe.Manifests != nil:
+		idx = &registry.Index{}
+		if err := json.Unmarshal(raw.Data, idx); err != nil {
+			return "", nil, fmt.Errorf("index %s: %v", raw.Digest, err)
+		}
+
+	default:
+		m = &registry.Manifest{}
+		if err := json.Unmarshal(raw.Data, m); err != nil {
+			return "", nil, fmt.Errorf("manifest %s: %v", raw.Digest, err)
+		}
+	}
+
+	if idx != nil {
+		out, err := c.index(idx)
+		if err != nil {
+			return "", nil, err
+		}
+
+		data, err := json.Marshal(out)
+		return out.MediaType, data, err
+	}
+
+	out, err := c.image(m)
+	if err != nil {
+		return "", nil, err
+	}
+
+	data, err := json.Marshal(out)
+	return out.MediaType, data, err
+}
+
+// image converts an image manifest and copies its blobs.
+func (c *converter) image(m *registry.Manifest) (*registry.Manifest, error) {
+	switch m.Config.MediaType {
+	case registry.MediaTypeDockerConfig, registry.MediaTypeOCIConfig:
+	default:
+		return nil, fmt.Errorf("config media type %s is not an image config", m.Config.MediaType)
+	}
+
+	oci := c.oci
+	if c.keep {
+		oci = m.MediaType == registry.MediaTypeOCIManifest || (m.MediaType == "" && m.Config.MediaType == registry.MediaTypeOCIConfig)
+	}
+
+	out := *m
+	out.Layers = make([]registry.Descriptor, 0, len(m.Layers))
+
+	if oci {
+		out.MediaType = registry.MediaTypeOCIManifest
+		out.Config.MediaType = registry.MediaTypeOCIConfig
+	} else {
+		out.MediaType = registry.MediaTypeManifest
+		out.Config.MediaType = registry.MediaTypeDockerConfig
+		out.Config.Annotations = nil
+		out.ArtifactType, out.Subject, out.Annotations = "", nil, nil
+	}
+
+	if err := c.client.CopyBlob(runctx, c.to, c.from, m.Config.Digest); err != nil {
+		return nil, fmt.Errorf("copy config %s: %w", m.Config.Digest, err)
+	}
+
+	for _, l := range m.Layers {
+		if c.layer != nil && len(l.URLs) == 0 {
+			nl, err := c.layer(l, oci)
+			if err != nil {
+				return nil, err
+			}
+
+			out.Layers = append(out.Layers, nl)
+			continue
+		}
+
+		mediaType, err := layerType(l.MediaType, oci)
+		if err != nil {
+			return nil, err
+		}
+
+		l.MediaType = mediaType
+		if !oci {
+			l.Annotations = nil
+		}
+		out.Layers = append(out.Layers, l)
+
+		// foreign layers live at their URLs
+		if len(l.URLs) > 0 {
+			continue
+		}
+
+		if err := c.client.CopyBlob(runctx, c.to, c.from, l.Digest); err != nil {
+			return nil, fmt.Errorf("copy layer %s: %w", l.Digest, err)
+		}
+	}
+
+	return &out, nil
+}
+
+// index converts every manifest an index lists, pushing each by digest.
+func (c *converter) index(idx *registry.Index) (*registry.Index, error) {
+	oci := c.oci
+	if c.keep {
+		oci = idx.MediaType == registry.MediaTypeOCIIndex
+	}
+
+	out := &registry.Index{
+		SchemaVersion: 2,
+		MediaType:     registry.MediaTypeManifestList,
+		Manifests:     []registry.Descriptor{},
+	}
+	if oci {
+		out.MediaType = registry.MediaTypeOCIIndex
+		out.Annotations = idx.Annotations
+	}
+
+	for _, d := range idx.Manifests {
+		if err := interrupted(); err != nil {
+			return nil, err
+		}
+
+		raw, err := c.client.RawManifest(runctx, c.from, string(d.Digest))
+		if err != nil {
+			return nil, fmt.Errorf("manifest %s: %w", d.Digest, err)
+		}
+
+		// attestations and other artifacts are not images to convert, an
+		// OCI index carries them unchanged unless the layers are rewritten
+		if kind, m := artifact(d, raw); kind != "" {
+			if !oci || c.layer != nil {
+				fmt.Fprintf(os.Stderr, "dropping %s %s\n", kind, d.Digest)
+				continue
+			}
+
+			if err := c.artifact(d, m, raw.Data); err != nil {
+				return nil, err
+			}
+
+			out.Manifests = append(out.Manifests, d)
+			continue
+		}
+
+		mediaType, data, err := c.convert(raw)
+		if err != nil {
+			return nil, err
+		}
+
+		digest, err := c.client.PushManifest(runctx, c.to, string(registry.FromBytes(data)), mediaType, data)
+		if err != nil {
+			return nil, fmt.Errorf("push manifest: %w", err)
+		}
+
+		nd := registry.Descriptor{
+			MediaType: mediaType,
+			Size:      int64(len(data)),
+			Digest:    digest,
+			Platform:  d.Platform,
+		}
+		if oci {
+			nd.Annotations, nd.ArtifactType = d.Annotations, d.ArtifactType
+		}
+
+		out.Manifests = append(out.Manifests, nd)
+	}
+
+	return out, nil
+}
+
+// artifact reports what kind of artifact an index entry is, or "" for an
+// image, along with its manifest.
+func artifact(d registry.Descriptor, raw *registry.RawManifest) (string, *registry.Manifest) {
+	switch raw.MediaType {
+	case registry.MediaTypeManifest, registry.MediaTypeOCIManifest, "":
+	default:
+		return "", nil
+	}
+
+	m := &registry.Manifest{}
+	if err := json.Unmarshal(raw.Data, m); err != nil || m.SchemaVersion != 2 || m.Config.Digest == "" {
+		return "", nil
+	}
+
+	switch {
+	case d.Annotations["vnd.docker.reference.type"] == "attestation-manifest":
+		return "attestation", m
+	case d.ArtifactType != "" || m.ArtifactType != "":
+		return "artifact", m
+	}
+
+	switch m.Config.MediaType {
+	case registry.MediaTypeDockerConfig, registry.MediaTypeOCIConfig:
+		return "", nil
+	}
+	return "artifact", m
+}
+
+// artifact copies an artifact manifest and its blobs unchanged.
+func (c *converter) artifact(d registry.Descriptor, m *registry.Manifest, data []byte) error {
+	blobs := append([]registry.Descriptor{m.Config}, m.Layers...)
+	for _, b := range blobs {
+		if len(b.URLs) > 0 {
+			continue
+		}
+		if err := c.client.CopyBlob(runctx, c.to, c.from, b.Digest); err != nil {
+			return fmt.Errorf("copy blob %s: %w", b.Digest, err)
+		}
+	}
+
+	if _, err := c.client.PushManifest(runctx, c.to, string(d.Digest), d.MediaType, data); err != nil {
+		return fmt.Errorf("push manifest: %w", err)
+	}
+	return nil
+}
+
+func convert(cmd *cobra.Command, args []string) error {
+	if len(args) < 1 || len(args) > 2 {
+		return usageError(cmd)
+	}
+
+	var oci bool
+	switch convertto {
+	case "docker":
+	case "oci":
+		oci = true
+	default:
+		return usageError(cmd)
+	}
+
+	refs := make([]*Reference, 0, 2)
+	for _, arg := range args {
+		r, err := useReference(cmd, arg)
+		if err != nil {
+			return err
+		}
+		refs = append(refs, r)
+	}
+
+	src, dst := refs[0], refs[len(refs)-1]
+	if dst.Tag == "" {
+		return fmt.Errorf("%s: destination needs a tag", dst)
+	}
+
+	client, err := connect(cmd)
+	if err != nil {
+		return err
+	}
+
+	// fetching verifies schema 1 signatures
+	raw, err := client.RawManifest(runctx, src.Name, src.Ref())
+	if err != nil {
+		return fmt.Errorf("manifest: %w", err)
+	}
+
+	c := &converter{client: client, from: src.Name, to: dst.Name, oci: oci}
+
+	mediaType, data, err := c.convert(raw)
+	if err != nil {
+		return err
+	}
+
+	digest, err := client.PushManifest(runctx, dst.Name, dst.Tag, mediaType, data)
+	if err != nil {
+		return fmt.Errorf("push manifest: %w", err)
+	}
+
+	fmt.Printf("converted %s to %s:%s@%s\n", src, dst.Name, dst.Tag, digest)
+
+	return nil
+}
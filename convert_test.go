@@ -0,0 +1,320 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/dbulkow/registry_cmd/registry"
+	"github.com/dbulkow/registry_cmd/registry/registrytest"
+)
+
+func TestConvertSchema1(t *testing.T) {
+	e := newEnv(t)
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	e.reg.PushSchema1("legacy/app", "1", registrytest.Image{
+		Config: registry.ContainerConfig{Env: []string{"V=1"}, Cmd: []string{"/srv/run"}},
+		Layers: [][]byte{baseLayer, web1Layer},
+		History: []registry.History{
+			{Created: "2016-01-01T00:00:00Z", CreatedBy: "ADD rootfs /"},
+			{Created: "2016-01-02T00:00:00Z", CreatedBy: "ENV V=1", EmptyLayer: true},
+			{Created: "2016-01-03T00:00:00Z", CreatedBy: "COPY srv /srv"},
+		},
+	}, key)
+
+	out := e.ok("convert", "legacy/app:1")
+	contains(t, out, "converted legacy/app:1 to legacy/app:1@sha256:")
+
+	mediaType, data, _ := e.reg.Manifest("legacy/app", "1")
+	if mediaType != registry.MediaTypeManifest {
+		t.Fatalf("media type %s after convert", mediaType)
+	}
+
+	var m registry.Manifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		t.Fatal(err)
+	}
+	if len(m.Layers) != 2 || m.Layers[0].Digest != registry.FromBytes(baseLayer) || m.Layers[1].Size != int64(len(web1Layer)) {
+		t.Errorf("layers = %+v", m.Layers)
+	}
+
+	cdata, _ := e.reg.Blob("legacy/app", m.Config.Digest)
+
+	var config registry.ImageConfig
+	if err := json.Unmarshal(cdata, &config); err != nil {
+		t.Fatal(err)
+	}
+	if config.OS != "linux" || config.Architecture != "amd64" || config.Config.Env[0] != "V=1" {
+		t.Errorf("config = %+v", config)
+	}
+	if len(config.History) != 3 || !config.History[1].EmptyLayer || config.History[2].CreatedBy != "/bin/sh -c COPY srv /srv" {
+		t.Errorf("history = %+v", config.History)
+	}
+	if len(config.RootFS.DiffIDs) != 2 {
+		t.Errorf("diff ids = %v", config.RootFS.DiffIDs)
+	}
+	if bytes.Contains(cdata, []byte(`"parent"`)) || bytes.Contains(cdata, []byte(`"throwaway"`)) {
+		t.Errorf("config keeps v1 fields: %s", cdata)
+	}
+
+	// the converted image reads like any other
+	contains(t, e.ok("cat", "legacy/app:1", "/srv/index.html"), "v1")
+}
+
+func TestConvertSchema1Copy(t *testing.T) {
+	e := newEnv(t, registrytest.WithoutMount())
+
+	e.reg.PushSchema1("legacy/app", "1", registrytest.Image{Layers: [][]byte{baseLayer}}, nil)
+
+	e.ok("convert", "legacy/app:1", "modern/app:1")
+
+	if mediaType, _, _ := e.reg.Manifest("legacy/app", "1"); mediaType != registry.MediaTypeSchema1 {
+		t.Errorf("source rewritten as %s", mediaType)
+	}
+	contains(t, e.ok("ls-files", "modern/app:1"), "/etc/os-release")
+
+	e.ok("convert", "--to", "oci", "legacy/app:1", "modern/app:oci")
+	if mediaType, _, _ := e.reg.Manifest("modern/app", "oci"); mediaType != registry.MediaTypeOCIManifest {
+		t.Errorf("schema 1 converted to %s", mediaType)
+	}
+}
+
+func TestConvertSchema1BadSignature(t *testing.T) {
+	e := newEnv(t)
+
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	desc := e.reg.PushSchema1("legacy/app", "1", registrytest.Image{Layers: [][]byte{baseLayer}}, key)
+
+	_, data, _ := e.reg.Manifest("legacy/app", "1")
+	s1, _ := registry.ParseSchema1(data)
+	sig := s1.Signatures[0].Signature
+	e.reg.Tamper("legacy/app", "1", bytes.Replace(data, []byte(sig), []byte(sig[len(sig)/2:]+sig[:len(sig)/2]), 1))
+
+	r := e.fails(ExitFailure, "convert", "legacy/app:1")
+	contains(t, r.stderr, "invalid manifest signature")
+
+	if mediaType, _, _ := e.reg.Manifest("legacy/app", "1"); mediaType != registry.MediaTypeSignedSchema1 {
+		t.Errorf("tag rewritten despite the bad signature")
+	}
+
+	// commands that do not use the content still list and delete it
+	contains(t, e.ok("list", "-d", "legacy/app"), "legacy/app:1 "+string(desc.Digest))
+	e.ok("rm", "legacy/app:1")
+
+	e.fails(ExitUsage, "convert")
+}
+
+func TestConvertSchema1UnsignedFields(t *testing.T) {
+	e := newEnv(t)
+
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	e.reg.PushSchema1("legacy/app", "1", registrytest.Image{Layers: [][]byte{baseLayer}}, key)
+	evil := e.reg.PushBlob("legacy/app", web1Layer)
+
+	// a second fsLayers past the signed payload replaces the first when
+	// the whole manifest is parsed
+	_, data, _ := e.reg.Manifest("legacy/app", "1")
+	end := bytes.LastIndex(data, []byte("}"))
+	extra := fmt.Sprintf(`, "fsLayers": [{"blobSum": %q}], "history": [{"v1Compatibility": "{\"id\": \"x\"}"}]`, evil)
+	e.reg.Tamper("legacy/app", "1", append(append(append([]byte{}, data[:end]...), extra...), data[end:]...))
+
+	r := e.fails(ExitFailure, "convert", "legacy/app:1", "legacy/app:2")
+	contains(t, r.stderr, "invalid manifest signature")
+
+	if _, _, ok := e.reg.Manifest("legacy/app", "2"); ok {
+		t.Error("converted the unsigned fields")
+	}
+}
+
+func TestConvertOCI(t *testing.T) {
+	e := seeded(t)
+
+	_, orig, _ := e.reg.Manifest("app/web", "1.0")
+
+	contains(t, e.ok("convert", "--to", "oci", "app/web:1.0", "oci/web:1"), "converted app/web:1.0 to oci/web:1@sha256:")
+
+	mediaType, data, _ := e.reg.Manifest("oci/web", "1")
+	if mediaType != registry.MediaTypeOCIManifest {
+		t.Errorf("media type %s", mediaType)
+	}
+
+	var m registry.Manifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		t.Fatal(err)
+	}
+	if m.Config.MediaType != registry.MediaTypeOCIConfig {
+		t.Errorf("config media type %s", m.Config.MediaType)
+	}
+	for _, l := range m.Layers {
+		if l.MediaType != registry.MediaTypeOCILayer {
+			t.Errorf("layer media type %s", l.MediaType)
+		}
+	}
+	if m.Layers[1].Digest != registry.FromBytes(web1Layer) {
+		t.Errorf("layers not reused: %v", m.Layers)
+	}
+
+	contains(t, e.ok("cat", "oci/web:1", "/srv/index.html"), "v1")
+
+	// and back, to the manifest it started as
+	e.ok("convert", "oci/web:1", "app/web:back")
+	if _, back, _ := e.reg.Manifest("app/web", "back"); !bytes.Equal(back, orig) {
+		t.Errorf("round trip changed the manifest:\n%s\n%s", orig, back)
+	}
+
+	e.fails(ExitUsage, "convert", "--to", "zip", "app/web:1.0")
+}
+
+func TestConvertIndex(t *testing.T) {
+	e := seeded(t)
+
+	e.ok("convert", "--to", "oci", "multi/arch:1")
+
+	mediaType, data, _ := e.reg.Manifest("multi/arch", "1")
+	if mediaType != registry.MediaTypeOCIIndex {
+		t.Fatalf("media type %s", mediaType)
+	}
+
+	var idx registry.Index
+	if err := json.Unmarshal(data, &idx); err != nil {
+		t.Fatal(err)
+	}
+	for _, d := range idx.Manifests {
+		if d.MediaType != registry.MediaTypeOCIManifest || d.Platform == nil {
+			t.Errorf("index entry %+v", d)
+		}
+	}
+
+	contains(t, e.ok("cat", "--platform", "linux/arm64", "multi/arch:1", "/arch"), "arm64")
+
+	// attestations have no place in a manifest list
+	att := e.reg.PushImage("multi/arch", "", registrytest.Image{MediaType: registry.MediaTypeOCIManifest})
+	att.Platform = &registry.Platform{OS: "unknown", Architecture: "unknown"}
+	att.Annotations = map[string]string{"vnd.docker.reference.type": "attestation-manifest"}
+	idx.Manifests = append(idx.Manifests, att)
+
+	data, _ = json.Marshal(idx)
+	e.reg.PushManifest("multi/arch", "att", registry.MediaTypeOCIIndex, data)
+
+	r := e.run("convert", "multi/arch:att")
+	if r.code != ExitOK {
+		t.Fatalf("exit %d: %s", r.code, r.stderr)
+	}
+	contains(t, r.stderr, "dropping attestation "+string(att.Digest))
+
+	_, data, _ = e.reg.Manifest("multi/arch", "att")
+	if err := json.Unmarshal(data, &idx); err != nil {
+		t.Fatal(err)
+	}
+	if len(idx.Manifests) != 2 || idx.MediaType != registry.MediaTypeManifestList {
+		t.Errorf("converted list %s", data)
+	}
+}
+
+func TestConvertArtifacts(t *testing.T) {
+	e := seeded(t)
+
+	_, data, _ := e.reg.Manifest("multi/arch", "1")
+
+	var idx registry.Index
+	if err := json.Unmarshal(data, &idx); err != nil {
+		t.Fatal(err)
+	}
+
+	// one artifact says what it is, the other only has a config that is not
+	// an image config
+	sbom := []byte(`{"spdxVersion":"SPDX-2.3"}`)
+	artifacts := []registry.Manifest{{
+		SchemaVersion: 2,
+		MediaType:     registry.MediaTypeOCIManifest,
+		ArtifactType:  "application/spdx+json",
+		Config:        registry.Descriptor{MediaType: registry.MediaTypeOCIEmpty, Size: 2, Digest: e.reg.PushBlob("multi/arch", []byte("{}"))},
+		Layers:        []registry.Descriptor{{MediaType: "application/spdx+json", Size: int64(len(sbom)), Digest: e.reg.PushBlob("multi/arch", sbom)}},
+	}, {
+		SchemaVersion: 2,
+		MediaType:     registry.MediaTypeOCIManifest,
+		Config:        registry.Descriptor{MediaType: "application/vnd.example.config.v1+json", Size: 2, Digest: registry.FromBytes([]byte("{}"))},
+		Layers:        []registry.Descriptor{},
+	}}
+
+	var digests []registry.Digest
+	for _, m := range artifacts {
+		data, _ := json.Marshal(m)
+		digest := e.reg.PushManifest("multi/arch", "", registry.MediaTypeOCIManifest, data)
+		digests = append(digests, digest)
+
+		idx.Manifests = append(idx.Manifests, registry.Descriptor{
+			MediaType: registry.MediaTypeOCIManifest,
+			Size:      int64(len(data)),
+			Digest:    digest,
+		})
+	}
+
+	idx.MediaType = registry.MediaTypeOCIIndex
+	data, _ = json.Marshal(idx)
+	e.reg.PushManifest("multi/arch", "art", registry.MediaTypeOCIIndex, data)
+
+	// an OCI index carries artifacts through unchanged
+	e.ok("convert", "--to", "oci", "multi/arch:art", "other/arch:1")
+
+	var out registry.Index
+	_, data, _ = e.reg.Manifest("other/arch", "1")
+	if err := json.Unmarshal(data, &out); err != nil {
+		t.Fatal(err)
+	}
+	if len(out.Manifests) != 4 || out.Manifests[2].Digest != digests[0] || out.Manifests[3].Digest != digests[1] {
+		t.Fatalf("converted index %s", data)
+	}
+	for _, d := range digests {
+		if _, _, ok := e.reg.Manifest("other/arch", string(d)); !ok {
+			t.Errorf("artifact %s not copied", d)
+		}
+	}
+	if _, ok := e.reg.Blob("other/arch", registry.FromBytes(sbom)); !ok {
+		t.Error("artifact layer not copied")
+	}
+
+	// a manifest list has no place for them
+	r := e.run("convert", "--to", "docker", "multi/arch:art")
+	if r.code != ExitOK {
+		t.Fatalf("exit %d: %s", r.code, r.stderr)
+	}
+	for _, d := range digests {
+		contains(t, r.stderr, "dropping artifact "+string(d))
+	}
+
+	_, data, _ = e.reg.Manifest("multi/arch", "art")
+	if err := json.Unmarshal(data, &out); err != nil {
+		t.Fatal(err)
+	}
+	if len(out.Manifests) != 2 || out.MediaType != registry.MediaTypeManifestList {
+		t.Errorf("converted list %s", data)
+	}
+}
+
+func TestConvertZstdToDocker(t *testing.T) {
+	e := newEnv(t)
+
+	desc := e.reg.PushImage("app/z", "", registrytest.Image{MediaType: registry.MediaTypeOCIManifest, Layers: [][]byte{baseLayer}})
+	_, data, _ := e.reg.Manifest("app/z", string(desc.Digest))
+
+	var m registry.Manifest
+	json.Unmarshal(data, &m)
+	m.Layers[0].MediaType = registry.MediaTypeOCILayerZstd
+	data, _ = json.Marshal(m)
+	e.reg.PushManifest("app/z", "1", registry.MediaTypeOCIManifest, data)
+
+	r := e.fails(ExitFailure, "convert", "--to", "docker", "app/z:1")
+	contains(t, r.stderr, "no docker equivalent")
+
+	e.ok("convert", "--to", "oci", "app/z:1", "app/z:2")
+}
@@ -1,96 +1,145 @@
 package main
 
 import (
+	"errors"
 	"fmt"
-	"net/http"
 	"os"
-	"strings"
 
+	"github.com/dbulkow/registry_cmd/registry"
+	humanize "github.com/dustin/go-humanize"
 	"github.com/spf13/cobra"
 )
 
+var delestimate, deldryrun bool
+
 func init() {
 	deleteCmd := &cobra.Command{
-		Use:     "delete <image:tag>",
+		Use:     "delete <image:tag>...",
 		Aliases: []string{"rm", "remove"},
-		Short:   "Delete an image",
-		Run:     delete,
+		Short:   "Delete images",
+		Long: `Delete images.
+
+Deleting removes the manifest, and with it every tag of the same digest.
+Layers are only freed by the registry's garbage collection; --estimate
+reports how much space that would reclaim.
+
+An image that cannot be resolved or deleted is reported and the others
+are still deleted; the exit status is then a partial failure. When
+interrupted, the delete in progress completes and the images not yet
+deleted are listed.
+`,
+		Annotations: mutating,
+		RunE:        rm,
 	}
 
-	RootCmd.AddCommand(deleteCmd)
-}
-
-type Delete struct {
-}
-
-func (d *Delete) Method() string { return http.MethodDelete }
-
-func (d *Delete) SetHeaders(hdr *http.Header) {}
+	deleteCmd.Flags().BoolVarP(&delestimate, "estimate", "e", false, "Report space reclaimed by garbage collection")
+	deleteCmd.Flags().BoolVarP(&deldryrun, "dry-run", "n", false, "Report what would be deleted and reclaimed, delete nothing")
 
-func (d *Delete) UnmarshalJSON(b []byte) error {
-	return nil
+	RootCmd.AddCommand(deleteCmd)
 }
 
-func (d *Delete) ExtractHeaders(hdr *http.Header) {}
+func rm(cmd *cobra.Command, args []string) error {
+	if len(args) < 1 {
+		return usageError(cmd)
+	}
 
-func deleteImage(conn *http.Client, url, image, digest string) error {
-	d := &Delete{}
+	refs := make([]*Reference, 0, len(args))
+	for _, arg := range args {
+		r, err := useReference(cmd, arg)
+		if err != nil {
+			return err
+		}
+		refs = append(refs, r)
+	}
 
-	err := get(conn, url+"/v2/"+image+"/manifests/"+digest, d)
+	client, err := connect(cmd)
 	if err != nil {
 		return err
 	}
 
-	return nil
-}
-
-func delete(cmd *cobra.Command, args []string) {
-	url := cmd.Flag("registry").Value.String()
-
-	if len(args) != 1 {
-		cmd.UsageFunc()(cmd)
-		return
+	type Target struct {
+		name   string
+		ref    string
+		digest registry.Digest
 	}
 
-	delimg := args[0]
+	targets := make([]*Target, 0, len(refs))
 
-	conn, err := connect(cmd)
-	if err != nil {
-		fmt.Fprintln(os.Stderr, err)
-		return
-	}
+	var failures tally
 
-	images, err := catalog(conn, url)
-	if err != nil {
-		fmt.Fprintln(os.Stderr, err)
-		return
-	}
+	for _, r := range refs {
+		if err := interrupted(); err != nil {
+			return err
+		}
 
-	for _, name := range images {
-		tags, err := tags(conn, url, name)
+		digest, _, err := client.Blobs(runctx, r.Name, r.Ref())
+		if errors.Is(err, registry.ErrNotFound) {
+			fmt.Fprintf(os.Stderr, "%s: image not found\n", r)
+			failures.add(err)
+			continue
+		}
 		if err != nil {
-			fmt.Fprintln(os.Stderr, err)
+			fmt.Fprintf(os.Stderr, "%s: manifest: %v\n", r, err)
+			failures.add(err)
 			continue
 		}
 
-		for _, tag := range tags {
-			if strings.Compare(delimg, name+":"+tag) == 0 {
-				digest, _, err := manifest(conn, url, name, tag)
-				if err != nil {
-					fmt.Fprintf(os.Stderr, "manifest: %v\n", err)
-					return
-				}
+		ref := r.Name + ":" + r.Tag
+		if r.Digest != "" {
+			ref = r.Name + "@" + r.Digest
+		}
+
+		targets = append(targets, &Target{name: r.Name, ref: ref, digest: digest})
+	}
+
+	if delestimate || deldryrun {
+		remove := make(map[string]bool)
+		for _, t := range targets {
+			remove[t.name+"@"+string(t.digest)] = true
+		}
 
-				err = deleteImage(conn, url, name, digest)
-				if err != nil {
-					fmt.Fprintf(os.Stderr, "delete: %v\n", err)
-					return
-				}
+		bytes, count, err := reclaimable(runctx, client, remove)
+		if err != nil {
+			return fmt.Errorf("estimate: %w", err)
+		}
 
-				fmt.Printf("deleted %s:%s\n", name, tag)
+		fmt.Printf("garbage collection would reclaim %s in %d blobs\n", humanize.Bytes(bytes), count)
+	}
 
-				return
+	for i, t := range targets {
+		if err := interrupted(); err != nil {
+			fmt.Fprintf(os.Stderr, "interrupted, %d of %d images not deleted:\n", len(targets)-i, len(targets))
+			for _, t := range targets[i:] {
+				fmt.Fprintf(os.Stderr, "  %s\n", t.ref)
 			}
+			return err
 		}
+
+		if deldryrun {
+			fmt.Printf("would delete %s\n", t.ref)
+			failures.add(nil)
+			continue
+		}
+
+		// once started, a delete completes even if interrupted
+		err = client.DeleteManifest(workctx, t.name, t.digest)
+		failures.add(err)
+		switch {
+		case registry.HasCode(err, registry.ErrUnsupported):
+			return fmt.Errorf("delete: deletion disabled on registry: %w", err)
+		case registry.HasCode(err, registry.ErrDenied), registry.HasCode(err, registry.ErrUnauthorized):
+			fmt.Fprintf(os.Stderr, "delete %s: permission denied\n", t.ref)
+			continue
+		case errors.Is(err, registry.ErrNotFound):
+			fmt.Fprintf(os.Stderr, "delete %s: image not found\n", t.ref)
+			continue
+		case err != nil:
+			fmt.Fprintf(os.Stderr, "delete %s: %v\n", t.ref, err)
+			continue
+		}
+
+		fmt.Printf("deleted %s\n", t.ref)
 	}
+
+	return failures.err("images")
 }
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/dbulkow/registry_cmd/registry/registrytest"
+)
+
+func TestDelete(t *testing.T) {
+	e := seeded(t)
+
+	out := e.ok("delete", "app/web:1.0")
+	contains(t, out, "deleted app/web:1.0")
+
+	if tags := e.reg.Tags("app/web"); len(tags) != 1 || tags[0] != "2.0" {
+		t.Errorf("tags after delete = %v, want [2.0]", tags)
+	}
+}
+
+func TestDeleteByDigest(t *testing.T) {
+	e := seeded(t)
+
+	desc := e.reg.PushImage("app/web", "3.0", registrytest.Image{Layers: [][]byte{baseLayer}})
+
+	contains(t, e.ok("rm", "app/web@"+string(desc.Digest)), "deleted app/web@"+string(desc.Digest))
+
+	if _, _, ok := e.reg.Manifest("app/web", "3.0"); ok {
+		t.Error("app/web:3.0 still present")
+	}
+}
+
+func TestDeleteDryRun(t *testing.T) {
+	e := seeded(t)
+
+	out := e.ok("delete", "-n", "app/web:1.0", "app/web:2.0")
+	contains(t, out, "would delete app/web:1.0", "would delete app/web:2.0",
+		"garbage collection would reclaim")
+
+	if n := e.reg.Count("DELETE", ""); n != 0 {
+		t.Errorf("%d deletes on a dry run", n)
+	}
+	if tags := e.reg.Tags("app/web"); len(tags) != 2 {
+		t.Errorf("tags after dry run = %v", tags)
+	}
+}
+
+func TestDeleteEstimate(t *testing.T) {
+	e := seeded(t)
+
+	// web1Layer and the config are only held by app/web:1.0, the base
+	// layer is shared
+	out := e.ok("delete", "-n", "-e", "app/web:1.0")
+	contains(t, out, "in 2 blobs")
+}
+
+func TestDeleteNotFound(t *testing.T) {
+	e := seeded(t)
+
+	r := e.fails(ExitPartial, "delete", "app/web:1.0", "app/web:9.9")
+	contains(t, r.stdout, "deleted app/web:1.0")
+	contains(t, r.stderr, "app/web:9.9: image not found")
+
+	e.fails(ExitNotFound, "delete", "app/web:9.9")
+}
+
+func TestDeleteFailure(t *testing.T) {
+	e := seeded(t)
+
+	// the second of three deletes fails, the third still happens
+	e.reg.FailAfter("DELETE", "/manifests/", http.StatusInternalServerError, 1, 1)
+
+	r := e.fails(ExitPartial, "rm", "app/web:1.0", "app/web:2.0", "app/api:latest")
+	contains(t, r.stdout, "deleted app/web:1.0", "deleted app/api:latest")
+	lacks(t, r.stdout, "deleted app/web:2.0")
+	contains(t, r.stderr, "delete app/web:2.0:", "1 of 3 images failed")
+
+	if tags := e.reg.Tags("app/web"); len(tags) != 1 || tags[0] != "2.0" {
+		t.Errorf("tags after delete = %v, want [2.0]", tags)
+	}
+
+	// so does a failure resolving a tag
+	e = seeded(t)
+	e.reg.Fail("GET", "/manifests/1.0$", http.StatusInternalServerError, 0)
+
+	r = e.fails(ExitPartial, "rm", "app/web:1.0", "app/web:2.0")
+	contains(t, r.stdout, "deleted app/web:2.0")
+	contains(t, r.stderr, "app/web:1.0: manifest:", "1 of 2 images failed")
+}
+
+func TestDeleteDisabled(t *testing.T) {
+	e := seeded(t, registrytest.WithoutDelete())
+
+	r := e.fails(ExitFailure, "delete", "app/web:1.0")
+	contains(t, r.stderr, "deletion disabled on registry")
+}
@@ -0,0 +1,256 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/dbulkow/registry_cmd/registry"
+	"github.com/spf13/cobra"
+)
+
+var deppartial bool
+var depplatform string
+
+func init() {
+	dependentsCmd := &cobra.Command{
+		Use:   "dependents <base-image:tag> [glob]",
+		Short: "Find images built on a base image",
+		Long: `Find images built on a base image.
+
+An image depends on the base when its layers start with the exact layer
+chain of the base. Layers are compared by the diff IDs of the image
+configs, so an image pushed with the base layers compressed differently
+still matches; schema 1 images, which have none, are compared by layer
+digest. The depth shown is the number of matched layers out of the layers
+of the image. With --partial, images sharing only the start of the chain,
+such as those built on an older build of the base, are listed too.
+
+Manifest lists are followed to the images for the platform of the base.
+`,
+		RunE: dependents,
+	}
+
+	dependentsCmd.Flags().BoolVarP(&deppartial, "partial", "p", false, "Also list images sharing part of the base layer chain")
+	dependentsCmd.Flags().StringVar(&depplatform, "platform", "", "Platform to select when the base is a manifest list (os/arch[/variant])")
+
+	RootCmd.AddCommand(dependentsCmd)
+}
+
+// commonPrefix returns the number of leading layers a and b share.
+func commonPrefix(a, b []registry.Digest) int {
+	n := 0
+	for n < len(a) && n < len(b) && a[n] == b[n] {
+		n++
+	}
+	return n
+}
+
+// depImage is an image to compare with the base: layers are its diff
+// IDs, or for a schema 1 image, which has none, its layer digests.
+type depImage struct {
+	name    string
+	digest  registry.Digest
+	layers  []registry.Digest
+	schema1 bool
+}
+
+// depImages returns the image at ref in repository image, or the images
+// of the manifest list at ref for platform plat. name is the image in
+// output; diffids caches the diff IDs of configs.
+func depImages(client *registry.Client, image, ref, name string, plat *registry.Platform, diffids map[registry.Digest][]registry.Digest) ([]*depImage, error) {
+	raw, err := client.RawManifest(runctx, image, ref)
+	if err != nil {
+		return nil, err
+	}
+
+	probe := struct {
+		SchemaVersion int                   `json:"schemaVersion"`
+		Config        registry.Descriptor   `json:"config"`
+		Manifests     []registry.Descriptor `json:"manifests"`
+	}{}
+	if err := json.Unmarshal(raw.Data, &probe); err != nil {
+		return nil, fmt.Errorf("manifest %s: %v", raw.Digest, err)
+	}
+
+	switch {
+	case probe.SchemaVersion == 1:
+		payload, err := registry.VerifySchema1(raw.Data)
+		if err != nil {
+			return nil, fmt.Errorf("manifest %s: %w", raw.Digest, err)
+		}
+
+		m, err := registry.ParseSchema1(payload)
+		if err != nil {
+			return nil, err
+		}
+
+		// bottom layer first, without the empty layers of throwaway entries
+		layers := make([]registry.Digest, 0, len(m.FSLayers))
+		for i := len(m.FSLayers) - 1; i >= 0; i-- {
+			var v1 v1Compatibility
+			if err := json.Unmarshal([]byte(m.History[i].V1Compatibility), &v1); err != nil {
+				return nil, fmt.Errorf("history %d: %v", i, err)
+			}
+			if !v1.ThrowAway {
+				layers = append(layers, m.FSLayers[i].BlobSum)
+			}
+		}
+
+		return []*depImage{{name: name, digest: raw.Digest, layers: layers, schema1: true}}, nil
+
+	case probe.Manifests != nil:
+		images := make([]*depImage, 0)
+		for _, d := range probe.Manifests {
+			if d.Platform == nil || !d.Platform.Matches(plat) {
+				continue
+			}
+
+			children, err := depImages(client, image, string(d.Digest), name+" ("+d.Platform.String()+")", plat, diffids)
+			if err != nil {
+				return nil, err
+			}
+			images = append(images, children...)
+		}
+		return images, nil
+	}
+
+	layers, ok := diffids[probe.Config.Digest]
+	if !ok {
+		config, err := client.ImageConfig(runctx, image, probe.Config.Digest)
+		if err != nil {
+			return nil, fmt.Errorf("config: %w", err)
+		}
+		layers = config.RootFS.DiffIDs
+		diffids[probe.Config.Digest] = layers
+	}
+
+	return []*depImage{{name: name, digest: raw.Digest, layers: layers}}, nil
+}
+
+func dependents(cmd *cobra.Command, args []string) error {
+	if len(args) < 1 || len(args) > 2 {
+		return usageError(cmd)
+	}
+
+	r, err := useReference(cmd, args[0])
+	if err != nil {
+		return err
+	}
+
+	var filter string
+	if len(args) > 1 {
+		if filter, err = useRepository(cmd, args[1]); err != nil {
+			return err
+		}
+	}
+	basename, basetag := r.Name, r.Ref()
+
+	plat, err := platform(depplatform)
+	if err != nil {
+		return err
+	}
+
+	client, err := connect(cmd)
+	if err != nil {
+		return err
+	}
+
+	bm, basedigest, err := client.ImageManifest(runctx, basename, basetag, plat)
+	if err != nil {
+		return fmt.Errorf("manifest: %w", err)
+	}
+
+	bc, err := client.ImageConfig(runctx, basename, bm.Config.Digest)
+	if err != nil {
+		return fmt.Errorf("config: %w", err)
+	}
+
+	// layer digests are what schema 1 images can be compared by
+	base, baseblobs := bc.RootFS.DiffIDs, make([]registry.Digest, 0, len(bm.Layers))
+	for _, l := range bm.Layers {
+		baseblobs = append(baseblobs, l.Digest)
+	}
+
+	if len(base) == 0 {
+		return fmt.Errorf("%s has no layers", args[0])
+	}
+	if len(base) != len(baseblobs) {
+		return fmt.Errorf("%s: %d layers but %d diff ids", args[0], len(baseblobs), len(base))
+	}
+
+	baseplat := &registry.Platform{OS: bc.OS, Architecture: bc.Architecture, Variant: bc.Variant}
+	diffids := make(map[registry.Digest][]registry.Digest)
+
+	images, err := client.Catalog(runctx)
+	if err != nil {
+		return err
+	}
+
+	n := 0
+	type Match struct {
+		name  string
+		depth int
+		total int
+	}
+	matches := make([]Match, 0)
+
+	var failures tally
+
+	for _, name := range images {
+		if filter != "" && !Glob(filter, name) {
+			continue
+		}
+
+		tags, err := client.Tags(runctx, name)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			failures.add(err)
+			continue
+		}
+
+		for _, tag := range tags {
+			if err := interrupted(); err != nil {
+				return err
+			}
+
+			imgs, err := depImages(client, name, tag, name+":"+tag, baseplat, diffids)
+			failures.add(err)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "manifest %s:%s: %v\n", name, tag, err)
+				continue
+			}
+
+			for _, img := range imgs {
+				if img.digest == basedigest {
+					continue
+				}
+
+				chain := base
+				if img.schema1 {
+					chain = baseblobs
+				}
+
+				depth := commonPrefix(chain, img.layers)
+				if depth == 0 || (depth < len(chain) && !deppartial) {
+					continue
+				}
+
+				matches = append(matches, Match{name: img.name, depth: depth, total: len(img.layers)})
+				if n < len(img.name) {
+					n = len(img.name)
+				}
+			}
+		}
+	}
+
+	for _, m := range matches {
+		note := ""
+		if m.depth < len(base) {
+			note = fmt.Sprintf(" (partial, base has %d)", len(base))
+		}
+		fmt.Printf("%-*s depth %d/%d%s\n", n, m.name, m.depth, m.total, note)
+	}
+
+	return failures.err("images")
+}
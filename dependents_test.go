@@ -0,0 +1,110 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"io/ioutil"
+	"testing"
+
+	"github.com/dbulkow/registry_cmd/registry"
+	"github.com/dbulkow/registry_cmd/registry/registrytest"
+)
+
+func TestDependents(t *testing.T) {
+	e := seeded(t)
+
+	e.reg.PushImage("base/alpine", "3.19", registrytest.Image{Layers: [][]byte{baseLayer}})
+	e.reg.PushImage("base/alpine", "3.19-dev", registrytest.Image{Layers: [][]byte{baseLayer, apiLayer}})
+
+	out := e.ok("dependents", "base/alpine:3.19")
+	contains(t, out, "app/web:1.0", "app/web:2.0", "app/api:latest", "tools/mix:1", "depth 1/2")
+	lacks(t, out, "multi/arch", "base/alpine:3.19 ")
+
+	out = e.ok("dependents", "base/alpine:3.19", "app/*")
+	lacks(t, out, "tools/mix")
+
+	// app/api shares both layers, the web images only the first
+	out = e.ok("dependents", "base/alpine:3.19-dev")
+	contains(t, out, "app/api:latest")
+	lacks(t, out, "app/web")
+
+	out = e.ok("dependents", "--partial", "base/alpine:3.19-dev")
+	contains(t, out, "app/web:1.0", "partial")
+
+	e.fails(ExitNotFound, "dependents", "base/alpine:9")
+}
+
+// regzip returns layer compressed again, with a different blob digest
+// and the same diff ID.
+func regzip(t *testing.T, layer []byte) []byte {
+	t.Helper()
+
+	zr, err := gzip.NewReader(bytes.NewReader(layer))
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadAll(zr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	zw, _ := gzip.NewWriterLevel(&buf, gzip.BestSpeed)
+	zw.Name = "layer.tar"
+	zw.Write(data)
+	zw.Close()
+
+	return buf.Bytes()
+}
+
+func TestDependentsDiffIDs(t *testing.T) {
+	e := seeded(t)
+
+	e.reg.PushImage("base/alpine", "3.19", registrytest.Image{Layers: [][]byte{baseLayer}})
+	e.reg.PushImage("app/repushed", "1", registrytest.Image{Layers: [][]byte{regzip(t, baseLayer), web1Layer}})
+
+	contains(t, e.ok("dependents", "base/alpine:3.19"), "app/repushed:1 ", "app/web:1.0")
+}
+
+func TestDependentsList(t *testing.T) {
+	e := seeded(t)
+
+	e.reg.PushImage("base/alpine", "3.19", registrytest.Image{Layers: [][]byte{baseLayer}})
+
+	amd := e.reg.PushImage("app/multi", "", registrytest.Image{Layers: [][]byte{baseLayer, web1Layer}})
+	arm := e.reg.PushImage("app/multi", "", registrytest.Image{
+		Platform: registry.Platform{OS: "linux", Architecture: "arm64"},
+		Layers:   [][]byte{baseLayer, web2Layer},
+	})
+	e.reg.PushIndex("app/multi", "1", registry.MediaTypeManifestList, amd, arm)
+
+	// only the entry for the platform of the base
+	out := e.ok("dependents", "base/alpine:3.19", "app/multi")
+	contains(t, out, "app/multi:1 (linux/amd64) depth 1/2")
+	lacks(t, out, "arm64")
+}
+
+func TestDependentsSchema1(t *testing.T) {
+	e := seeded(t)
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	e.reg.PushImage("base/alpine", "3.19", registrytest.Image{Layers: [][]byte{baseLayer}})
+	e.reg.PushSchema1("legacy/web", "1", registrytest.Image{
+		Layers: [][]byte{baseLayer, web1Layer},
+		History: []registry.History{
+			{CreatedBy: "ADD base"},
+			{CreatedBy: "ENV V=1", EmptyLayer: true},
+			{CreatedBy: "COPY srv"},
+		},
+	}, key)
+
+	// top layer first in the manifest, without the throwaway entry
+	contains(t, e.ok("dependents", "base/alpine:3.19", "legacy/*"), "legacy/web:1 depth 1/2")
+}
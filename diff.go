@@ -0,0 +1,292 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/dbulkow/registry_cmd/registry"
+	humanize "github.com/dustin/go-humanize"
+	"github.com/spf13/cobra"
+)
+
+var difffiles bool
+var diffplatform string
+
+func init() {
+	diffCmd := &cobra.Command{
+		Use:   "diff <image:tag> <image:tag>",
+		Short: "Compare two images",
+		Long: `Compare the manifests and configs of two images.
+
+With --files, the layers of both images are downloaded and the files the
+second image adds (A), modifies (M) or deletes (D) are listed, comparing
+the filesystems each image's layers leave behind. A file a shared layer
+provides and a later layer of one image deletes or replaces is listed too.
+`,
+		RunE: diff,
+	}
+
+	diffCmd.Flags().BoolVarP(&difffiles, "files", "f", false, "List file differences between the image filesystems")
+	diffCmd.Flags().StringVar(&diffplatform, "platform", "", "Platform to select from manifest lists (os/arch[/variant])")
+
+	RootCmd.AddCommand(diffCmd)
+}
+
+type diffimage struct {
+	name     string
+	ref      string
+	digest   registry.Digest
+	manifest *registry.Manifest
+	config   *registry.ImageConfig
+}
+
+func loadDiffImage(ctx context.Context, client *registry.Client, r *Reference) (*diffimage, error) {
+	plat, err := platform(diffplatform)
+	if err != nil {
+		return nil, err
+	}
+
+	m, digest, err := client.ImageManifest(ctx, r.Name, r.Ref(), plat)
+	if err != nil {
+		return nil, fmt.Errorf("%s: manifest: %w", r, err)
+	}
+
+	config, err := client.ImageConfig(ctx, r.Name, m.Config.Digest)
+	if err != nil {
+		return nil, fmt.Errorf("%s: config: %w", r, err)
+	}
+
+	return &diffimage{name: r.Name, ref: r.Ref(), digest: digest, manifest: m, config: config}, nil
+}
+
+func (d *diffimage) size() int64 {
+	var total int64
+	for _, l := range d.manifest.Layers {
+		total += l.Size
+	}
+	return total
+}
+
+// base returns the base image reference recorded in the OCI annotations
+// of the manifest, or failing that the labels of the config.
+func (d *diffimage) base() string {
+	for _, m := range []map[string]string{d.manifest.Annotations, d.config.Config.Labels} {
+		name := m["org.opencontainers.image.base.name"]
+		digest := m["org.opencontainers.image.base.digest"]
+		if name != "" || digest != "" {
+			return strings.TrimSpace(name + " " + digest)
+		}
+	}
+
+	return ""
+}
+
+func signedSize(sz int64) string {
+	if sz < 0 {
+		return "-" + humanize.Bytes(uint64(-sz))
+	}
+	return "+" + humanize.Bytes(uint64(sz))
+}
+
+// diffsets prints the members only one of the sets has.
+func diffsets(title string, a, b map[string]string) {
+	keys := make([]string, 0)
+	for k := range a {
+		keys = append(keys, k)
+	}
+	for k := range b {
+		if _, ok := a[k]; !ok {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	lines := make([]string, 0)
+	for _, k := range keys {
+		av, ina := a[k]
+		bv, inb := b[k]
+
+		switch {
+		case ina && !inb:
+			lines = append(lines, "  - "+av)
+		case !ina && inb:
+			lines = append(lines, "  + "+bv)
+		case av != bv:
+			lines = append(lines, "  - "+av, "  + "+bv)
+		}
+	}
+
+	if len(lines) == 0 {
+		return
+	}
+
+	fmt.Printf("%s:\n", title)
+	for _, l := range lines {
+		fmt.Println(l)
+	}
+}
+
+func envmap(env []string) map[string]string {
+	m := make(map[string]string)
+	for _, e := range env {
+		k := strings.SplitN(e, "=", 2)[0]
+		m[k] = e
+	}
+	return m
+}
+
+func labelmap(labels map[string]string) map[string]string {
+	m := make(map[string]string)
+	for k, v := range labels {
+		m[k] = k + "=" + v
+	}
+	return m
+}
+
+func portmap(ports map[string]struct{}) map[string]string {
+	m := make(map[string]string)
+	for p := range ports {
+		m[p] = p
+	}
+	return m
+}
+
+func quoteList(l []string) string {
+	if l == nil {
+		return "(none)"
+	}
+	return fmt.Sprintf("%q", l)
+}
+
+func diff(cmd *cobra.Command, args []string) error {
+	if len(args) != 2 {
+		return usageError(cmd)
+	}
+
+	refs := make([]*Reference, 0, len(args))
+	for _, arg := range args {
+		r, err := useReference(cmd, arg)
+		if err != nil {
+			return err
+		}
+		refs = append(refs, r)
+	}
+
+	client, err := connect(cmd)
+	if err != nil {
+		return err
+	}
+
+	a, err := loadDiffImage(runctx, client, refs[0])
+	if err != nil {
+		return err
+	}
+
+	b, err := loadDiffImage(runctx, client, refs[1])
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("--- %s %s\n", args[0], a.digest)
+	fmt.Printf("+++ %s %s\n", args[1], b.digest)
+
+	inb := make(map[registry.Digest]bool)
+	for _, l := range b.manifest.Layers {
+		inb[l.Digest] = true
+	}
+	ina := make(map[registry.Digest]bool)
+	for _, l := range a.manifest.Layers {
+		ina[l.Digest] = true
+	}
+
+	fmt.Println("layers:")
+	for _, l := range a.manifest.Layers {
+		if inb[l.Digest] {
+			fmt.Printf("  = %s %s\n", l.Digest, humanize.Bytes(uint64(l.Size)))
+		} else {
+			fmt.Printf("  - %s %s\n", l.Digest, humanize.Bytes(uint64(l.Size)))
+		}
+	}
+	for _, l := range b.manifest.Layers {
+		if !ina[l.Digest] {
+			fmt.Printf("  + %s %s\n", l.Digest, humanize.Bytes(uint64(l.Size)))
+		}
+	}
+
+	fmt.Printf("size: %s -> %s (%s)\n", humanize.Bytes(uint64(a.size())), humanize.Bytes(uint64(b.size())), signedSize(b.size()-a.size()))
+
+	ac, bc := a.config.Config, b.config.Config
+
+	diffsets("env", envmap(ac.Env), envmap(bc.Env))
+	diffsets("labels", labelmap(ac.Labels), labelmap(bc.Labels))
+
+	if quoteList(ac.Entrypoint) != quoteList(bc.Entrypoint) {
+		fmt.Printf("entrypoint:\n  - %s\n  + %s\n", quoteList(ac.Entrypoint), quoteList(bc.Entrypoint))
+	}
+	if quoteList(ac.Cmd) != quoteList(bc.Cmd) {
+		fmt.Printf("cmd:\n  - %s\n  + %s\n", quoteList(ac.Cmd), quoteList(bc.Cmd))
+	}
+
+	diffsets("exposed ports", portmap(ac.ExposedPorts), portmap(bc.ExposedPorts))
+
+	if a.base() != b.base() {
+		fmt.Printf("base:\n  - %s\n  + %s\n", a.base(), b.base())
+	}
+
+	if !difffiles {
+		return nil
+	}
+
+	aview, err := layerView(runctx, client, a.name, a.manifest.Layers, true)
+	if err != nil {
+		return err
+	}
+
+	bview, err := layerView(runctx, client, b.name, b.manifest.Layers, true)
+	if err != nil {
+		return err
+	}
+
+	// whiteouts left in a view remove nothing either image has
+	for _, view := range []map[string]*fileinfo{aview, bview} {
+		for p, fi := range view {
+			if fi.whiteout {
+				delete(view, p)
+			}
+		}
+	}
+
+	paths := make([]string, 0)
+	for p := range aview {
+		paths = append(paths, p)
+	}
+	for p := range bview {
+		if _, ok := aview[p]; !ok {
+			paths = append(paths, p)
+		}
+	}
+	sort.Strings(paths)
+
+	fmt.Println("files:")
+	for _, p := range paths {
+		af, bf := aview[p], bview[p]
+
+		var change string
+		switch {
+		case af == nil:
+			change = "A"
+		case bf == nil:
+			change = "D"
+		case af.typ != bf.typ || af.mode != bf.mode || af.size != bf.size || af.sum != bf.sum || af.link != bf.link:
+			change = "M"
+		}
+
+		if change != "" {
+			fmt.Printf("  %s %s\n", change, p)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/dbulkow/registry_cmd/registry"
+)
+
+func TestDiff(t *testing.T) {
+	e := seeded(t)
+
+	out := e.ok("diff", "app/web:1.0", "app/web:2.0")
+	contains(t, out, "--- app/web:1.0", "+++ app/web:2.0", "layers:", "- V=1", "+ V=2")
+
+	out = e.ok("diff", "--files", "app/web:1.0", "app/web:2.0")
+	contains(t, out, "files:", "  A /srv/app.js\n", "  M /srv/index.html\n", "  D /etc/motd\n")
+
+	// unchanged files are not listed
+	lacks(t, out, "/etc/os-release", "/srv/\n")
+
+	// the other way round, additions become deletions and the motd the
+	// shared base layer provides comes back
+	out = e.ok("diff", "--files", "app/web:2.0", "app/web:1.0")
+	contains(t, out, "  D /srv/app.js\n", "  M /srv/index.html\n", "  A /etc/motd\n")
+	lacks(t, out, "A /srv/app.js", "D /etc/motd", "/etc/os-release")
+
+	// an image has no differences from itself
+	out = e.ok("diff", "--files", "app/web:1.0", "app/web:1.0")
+	lacks(t, out, "  A ", "  M ", "  D ")
+}
+
+func TestDiffPlatform(t *testing.T) {
+	e := seeded(t)
+
+	_, data, _ := e.reg.Manifest("multi/arch", "1")
+
+	var index registry.Index
+	if err := json.Unmarshal(data, &index); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, m := range index.Manifests {
+		out := e.ok("diff", "--platform", m.Platform.String(), "multi/arch:1", "app/web:1.0")
+		contains(t, out, "--- multi/arch:1 "+string(m.Digest))
+	}
+}
+
+func TestDiffNotFound(t *testing.T) {
+	e := seeded(t)
+
+	e.fails(ExitNotFound, "diff", "app/web:1.0", "app/web:9.9")
+}
@@ -0,0 +1,271 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+
+	"github.com/dbulkow/registry_cmd/registry"
+	humanize "github.com/dustin/go-humanize"
+	"github.com/spf13/cobra"
+)
+
+var dubytes bool
+var dutop int
+
+func init() {
+	duCmd := &cobra.Command{
+		Use:   "du [glob]",
+		Short: "Report registry disk usage with shared layers counted once",
+		Long: `Report registry disk usage with shared layers counted once.
+
+Repositories are ranked by the bytes of distinct blobs they reference:
+layers and configs, of every image of a manifest list, as rm --estimate
+counts them. Tags are ranked by exclusive bytes, the blobs no other image
+references. Shared bytes are blobs also referenced by another image, in any
+repository.
+
+A glob selects the repositories shown. Every repository is still read, so
+that blobs shared with repositories outside the glob count as shared, and
+the total covers the whole registry.
+`,
+		RunE: du,
+	}
+
+	duCmd.Flags().BoolVarP(&dubytes, "bytes", "b", false, "Display sizes in bytes")
+	duCmd.Flags().IntVarP(&dutop, "top", "n", 0, "Only show the N biggest consumers")
+
+	RootCmd.AddCommand(duCmd)
+}
+
+// blobcache remembers blob sizes so each blob is only looked up once.
+type blobcache map[registry.Digest]uint64
+
+func (c blobcache) size(ctx context.Context, client *registry.Client, image string, digest registry.Digest) (uint64, error) {
+	if sz, ok := c[digest]; ok {
+		return sz, nil
+	}
+
+	sz, err := client.BlobSize(ctx, image, digest)
+	if err != nil {
+		return 0, err
+	}
+
+	c[digest] = sz
+
+	return sz, nil
+}
+
+type usage struct {
+	name      string
+	tags      int
+	size      uint64
+	shared    uint64
+	exclusive uint64
+}
+
+func fmtsize(sz uint64, bytes bool) string {
+	if bytes {
+		return fmt.Sprintf("%d", sz)
+	}
+
+	return humanize.Bytes(sz)
+}
+
+func du(cmd *cobra.Command, args []string) error {
+	var filter string
+	if len(args) > 0 {
+		var err error
+		if filter, err = useRepository(cmd, args[0]); err != nil {
+			return err
+		}
+	}
+
+	client, err := connect(cmd)
+	if err != nil {
+		return err
+	}
+
+	images, err := client.Catalog(runctx)
+	if err != nil {
+		return err
+	}
+
+	type Image struct {
+		repo   string
+		shown  bool
+		tags   []string
+		digest registry.Digest
+		blobs  []registry.Digest
+	}
+
+	cache := make(blobcache)
+	imgs := make(map[string]*Image) // keyed by repo@digest
+	order := make([]string, 0)
+
+	// blob digest -> images referencing it
+	refs := make(map[registry.Digest]map[string]bool)
+
+	var failures tally
+
+	for _, name := range images {
+		shown := filter == "" || Glob(filter, name)
+
+		tags, err := client.Tags(runctx, name)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			failures.add(err)
+			continue
+		}
+
+		for _, tag := range tags {
+			if err := interrupted(); err != nil {
+				return err
+			}
+
+			digest, blobs, err := client.Referenced(runctx, name, tag)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "manifest %s:%s: %v\n", name, tag, err)
+				failures.add(err)
+				continue
+			}
+
+			key := name + "@" + string(digest)
+			if img, ok := imgs[key]; ok {
+				img.tags = append(img.tags, tag)
+				failures.add(nil)
+				continue
+			}
+
+			var sizeerr error
+			for _, b := range blobs {
+				if _, err := cache.size(runctx, client, name, b); err != nil {
+					fmt.Fprintf(os.Stderr, "blobsize %s %s: %v\n", name, b, err)
+					sizeerr = err
+					continue
+				}
+
+				if refs[b] == nil {
+					refs[b] = make(map[string]bool)
+				}
+				refs[b][key] = true
+			}
+
+			imgs[key] = &Image{repo: name, shown: shown, tags: []string{tag}, digest: digest, blobs: blobs}
+			failures.add(sizeerr)
+			order = append(order, key)
+		}
+	}
+
+	repos := make(map[string]*usage)
+	repoblobs := make(map[string]map[registry.Digest]bool)
+	tagusage := make([]*usage, 0, len(order))
+	selected := make(map[registry.Digest]bool)
+
+	for _, key := range order {
+		img := imgs[key]
+		if !img.shown {
+			continue
+		}
+
+		r, ok := repos[img.repo]
+		if !ok {
+			r = &usage{name: img.repo}
+			repos[img.repo] = r
+			repoblobs[img.repo] = make(map[registry.Digest]bool)
+		}
+		r.tags += len(img.tags)
+
+		u := &usage{name: img.repo + ":" + img.tags[0], tags: len(img.tags)}
+
+		seen := make(map[registry.Digest]bool)
+		for _, b := range img.blobs {
+			sz, ok := cache[b]
+			if !ok || seen[b] {
+				continue
+			}
+			seen[b] = true
+			selected[b] = true
+
+			u.size += sz
+			if len(refs[b]) > 1 {
+				u.shared += sz
+			} else {
+				u.exclusive += sz
+			}
+
+			if !repoblobs[img.repo][b] {
+				repoblobs[img.repo][b] = true
+				r.size += sz
+			}
+		}
+
+		tagusage = append(tagusage, u)
+	}
+
+	repousage := make([]*usage, 0, len(repos))
+	for _, r := range repos {
+		repousage = append(repousage, r)
+	}
+
+	sort.Slice(repousage, func(i, j int) bool {
+		if repousage[i].size != repousage[j].size {
+			return repousage[i].size > repousage[j].size
+		}
+		return repousage[i].name < repousage[j].name
+	})
+
+	sort.Slice(tagusage, func(i, j int) bool {
+		if tagusage[i].exclusive != tagusage[j].exclusive {
+			return tagusage[i].exclusive > tagusage[j].exclusive
+		}
+		return tagusage[i].name < tagusage[j].name
+	})
+
+	if dutop > 0 {
+		if len(repousage) > dutop {
+			repousage = repousage[:dutop]
+		}
+		if len(tagusage) > dutop {
+			tagusage = tagusage[:dutop]
+		}
+	}
+
+	n := len("REPOSITORY")
+	for _, u := range tagusage {
+		if n < len(u.name) {
+			n = len(u.name)
+		}
+	}
+
+	fmt.Printf("%-*s %5s %12s\n", n, "REPOSITORY", "TAGS", "UNIQUE")
+	for _, r := range repousage {
+		fmt.Printf("%-*s %5d %12s\n", n, r.name, r.tags, fmtsize(r.size, dubytes))
+	}
+
+	fmt.Printf("\n%-*s %5s %12s %12s %12s\n", n, "IMAGE", "TAGS", "SIZE", "SHARED", "EXCLUSIVE")
+	for _, u := range tagusage {
+		fmt.Printf("%-*s %5d %12s %12s %12s\n", n, u.name, u.tags,
+			fmtsize(u.size, dubytes), fmtsize(u.shared, dubytes), fmtsize(u.exclusive, dubytes))
+	}
+
+	fmt.Println()
+
+	if filter != "" {
+		var sum uint64
+		for b := range selected {
+			sum += cache[b]
+		}
+		fmt.Printf("%d distinct blobs in %s, %s\n", len(selected), filter, fmtsize(sum, dubytes))
+	}
+
+	var total uint64
+	for b := range refs {
+		total += cache[b]
+	}
+
+	fmt.Printf("%d distinct blobs, %s registry total\n", len(refs), fmtsize(total, dubytes))
+
+	return failures.err("images")
+}
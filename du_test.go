@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestDu(t *testing.T) {
+	e := seeded(t)
+
+	out := e.ok("du", "-b")
+	contains(t, out, "REPOSITORY", "IMAGE", "app/web", "tools/mix:1", "distinct blobs")
+
+	out = e.ok("du", "-b", "app/web")
+	contains(t, out, "app/web:1.0", "app/web:2.0")
+	lacks(t, out, "tools/mix")
+
+	// the two web layers, the shared base layer and two configs
+	contains(t, out, "5 distinct blobs in app/web")
+
+	// the total is the whole registry's whatever the glob
+	all := strings.Split(strings.TrimSpace(e.ok("du", "-b")), "\n")
+	contains(t, out, all[len(all)-1])
+}
+
+func TestDuSharedOutsideGlob(t *testing.T) {
+	e := seeded(t)
+
+	// the base layer of app/api is shared with app/web and tools/mix
+	var row []string
+	for _, line := range strings.Split(e.ok("du", "-b", "app/api"), "\n") {
+		if strings.HasPrefix(line, "app/api:latest ") {
+			row = strings.Fields(line)
+		}
+	}
+	if len(row) != 5 {
+		t.Fatalf("no app/api:latest row")
+	}
+
+	if shared := row[3]; shared != fmt.Sprint(len(baseLayer)) {
+		t.Errorf("shared = %s, want the base layer, %d", shared, len(baseLayer))
+	}
+}
+
+func TestDuList(t *testing.T) {
+	e := seeded(t)
+
+	// the layer and config of both images of the list
+	out := e.ok("du", "multi/arch")
+	contains(t, out, "4 distinct blobs in multi/arch")
+
+	var row []string
+	for _, line := range strings.Split(out, "\n") {
+		if strings.HasPrefix(line, "multi/arch:1 ") {
+			row = strings.Fields(line)
+		}
+	}
+	if len(row) < 5 {
+		t.Fatalf("no multi/arch:1 row:\n%s", out)
+	}
+
+	// nothing else references them, so all of it is reclaimed by rm
+	exclusive := strings.Join(row[len(row)-2:], " ")
+	contains(t, e.ok("rm", "--dry-run", "--estimate", "multi/arch:1"),
+		"garbage collection would reclaim "+exclusive+" in 4 blobs")
+}
+
+func TestDuTop(t *testing.T) {
+	e := seeded(t)
+
+	// sections: repositories, images and the total
+	sections := strings.Split(strings.TrimSpace(e.ok("du", "-n", "1")), "\n\n")
+	if len(sections) != 3 {
+		t.Fatalf("%d sections, want 3:\n%s", len(sections), strings.Join(sections, "\n\n"))
+	}
+
+	for _, s := range sections[:2] {
+		if n := strings.Count(s, "\n"); n != 1 {
+			t.Errorf("%d rows with --top 1, want 1:\n%s", n, s)
+		}
+	}
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+
+	"github.com/dbulkow/registry_cmd/registry"
+	"github.com/spf13/cobra"
+)
+
+// Exit codes, documented in the root command help.
+const (
+	ExitOK       = 0
+	ExitFailure  = 1 // any other error
+	ExitUsage    = 2 // bad arguments or flags
+	ExitNotFound = 3 // image, repository or blob not found
+	ExitAuth     = 4 // authentication failed or access denied
+	ExitNetwork  = 5 // registry unreachable, timed out or unavailable
+	ExitPartial  = 6 // some items of a bulk command failed
+	ExitPolicy   = 7 // a policy check such as scan --fail-on failed
+
+	ExitInterrupted = 130 // interrupted by SIGINT or SIGTERM
+)
+
+// exitError sets the exit code for an error. An exitError without err
+// exits quietly, as the command has already reported the problem.
+type exitError struct {
+	code int
+	err  error
+}
+
+func (e *exitError) Error() string {
+	if e.err == nil {
+		return ""
+	}
+	return e.err.Error()
+}
+
+func (e *exitError) Unwrap() error { return e.err }
+
+// usageError prints the usage of cmd and exits with ExitUsage.
+func usageError(cmd *cobra.Command) error {
+	cmd.UsageFunc()(cmd)
+	return &exitError{code: ExitUsage}
+}
+
+// tally counts the items a bulk command processed and those that failed.
+type tally struct {
+	total  int
+	failed int
+	last   error
+}
+
+// add records the outcome of one item.
+func (t *tally) add(err error) {
+	t.total++
+	if err != nil {
+		t.failed++
+		t.last = err
+	}
+}
+
+// err summarizes the failures. Some failing is a partial failure; all
+// failing exits as the last failure would. Failures after an interrupt
+// are not counted, the command was interrupted.
+func (t *tally) err(what string) error {
+	if err := interrupted(); err != nil {
+		return err
+	}
+
+	if t.failed == 0 {
+		return nil
+	}
+
+	code := ExitPartial
+	if t.failed == t.total {
+		code = exitCode(t.last)
+	}
+
+	return &exitError{code: code, err: fmt.Errorf("%d of %d %s failed", t.failed, t.total, what)}
+}
+
+// policyError fails a policy check.
+func policyError(format string, a ...interface{}) error {
+	return &exitError{code: ExitPolicy, err: fmt.Errorf(format, a...)}
+}
+
+// exitCode maps an error to the exit code of the process.
+func exitCode(err error) int {
+	if err == nil {
+		return ExitOK
+	}
+
+	var eerr *exitError
+	if errors.As(err, &eerr) {
+		return eerr.code
+	}
+
+	var rerr *registry.RegistryError
+	if errors.As(err, &rerr) {
+		switch {
+		case rerr.StatusCode == http.StatusNotFound:
+			return ExitNotFound
+		case rerr.Has(registry.ErrUnauthorized), rerr.Has(registry.ErrDenied):
+			return ExitAuth
+		case rerr.Has(registry.ErrTooManyRequests), rerr.StatusCode >= 500:
+			return ExitNetwork
+		}
+		return ExitFailure
+	}
+
+	if errors.Is(err, registry.ErrNotFound) {
+		return ExitNotFound
+	}
+
+	if errors.Is(err, registry.ErrAuth) {
+		return ExitAuth
+	}
+
+	if errors.Is(err, context.Canceled) {
+		return ExitInterrupted
+	}
+
+	var neterr net.Error
+	if errors.As(err, &neterr) || errors.Is(err, context.DeadlineExceeded) {
+		return ExitNetwork
+	}
+
+	return ExitFailure
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"archive/tar"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+
+	"github.com/dbulkow/registry_cmd/registry"
+	"github.com/spf13/cobra"
+)
+
+var filesplatform string
+
+func init() {
+	lsfilesCmd := &cobra.Command{
+		Use:   "ls-files <image:tag> [path-glob]",
+		Short: "List the files of an image",
+		Long: `List the files of an image, as the container would see them.
+
+Layers are streamed and whiteouts applied, nothing is stored locally.
+Each path is shown with its mode, size and the layer that provides it.
+`,
+		RunE: lsfiles,
+	}
+
+	lsfilesCmd.Flags().StringVar(&filesplatform, "platform", "", "Platform to select from manifest lists (os/arch[/variant])")
+
+	catCmd := &cobra.Command{
+		Use:   "cat <image:tag> <path>",
+		Short: "Print a file from an image",
+		RunE:  cat,
+	}
+
+	catCmd.Flags().StringVar(&filesplatform, "platform", "", "Platform to select from manifest lists (os/arch[/variant])")
+
+	RootCmd.AddCommand(lsfilesCmd)
+	RootCmd.AddCommand(catCmd)
+}
+
+func shortDigest(digest registry.Digest) string {
+	if hex := digest.Hex(); len(hex) > 12 {
+		return digest.Algorithm() + ":" + hex[:12]
+	}
+	return string(digest)
+}
+
+func lsfiles(cmd *cobra.Command, args []string) error {
+	if len(args) < 1 || len(args) > 2 {
+		return usageError(cmd)
+	}
+
+	var filter string
+	if len(args) > 1 {
+		filter = args[1]
+	}
+
+	r, err := useReference(cmd, args[0])
+	if err != nil {
+		return err
+	}
+	name, ref := r.Name, r.Ref()
+
+	plat, err := platform(filesplatform)
+	if err != nil {
+		return err
+	}
+
+	client, err := connect(cmd)
+	if err != nil {
+		return err
+	}
+
+	m, _, err := client.ImageManifest(runctx, name, ref, plat)
+	if err != nil {
+		return fmt.Errorf("manifest: %w", err)
+	}
+
+	view, err := layerView(runctx, client, name, m.Layers, false)
+	if err != nil {
+		return err
+	}
+
+	paths := make([]string, 0, len(view))
+	for p, fi := range view {
+		if fi.whiteout || p == "/" {
+			continue
+		}
+		if filter != "" && !Glob(filter, p) {
+			continue
+		}
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+
+	for _, p := range paths {
+		fi := view[p]
+
+		name := p
+		switch fi.typ {
+		case tar.TypeSymlink:
+			name += " -> " + fi.link
+		case tar.TypeLink:
+			name += " => " + cleanPath(fi.link)
+		}
+
+		fmt.Printf("%s %10d %s %s\n", fi.mode, fi.size, shortDigest(fi.layer), name)
+	}
+
+	return nil
+}
+
+func cat(cmd *cobra.Command, args []string) error {
+	if len(args) != 2 {
+		return usageError(cmd)
+	}
+
+	r, err := useReference(cmd, args[0])
+	if err != nil {
+		return err
+	}
+	name, ref := r.Name, r.Ref()
+
+	plat, err := platform(filesplatform)
+	if err != nil {
+		return err
+	}
+
+	client, err := connect(cmd)
+	if err != nil {
+		return err
+	}
+
+	m, _, err := client.ImageManifest(runctx, name, ref, plat)
+	if err != nil {
+		return fmt.Errorf("manifest: %w", err)
+	}
+
+	view, err := layerView(runctx, client, name, m.Layers, false)
+	if err != nil {
+		return err
+	}
+
+	p, err := resolvePath(view, args[1])
+	if err != nil {
+		return err
+	}
+
+	fi, ok := view[p]
+	if ok && fi.typ == tar.TypeLink {
+		p = cleanPath(fi.link)
+		fi, ok = view[p]
+	}
+
+	if !ok || fi.whiteout {
+		return &exitError{code: ExitNotFound, err: fmt.Errorf("%s: no such file", args[1])}
+	}
+
+	if fi.typ != tar.TypeReg {
+		return fmt.Errorf("%s: not a regular file", args[1])
+	}
+
+	errFound := errors.New("found")
+
+	err = walkLayer(runctx, client, name, fi.layer, func(hdr *tar.Header, r io.Reader) error {
+		if cleanPath(hdr.Name) != p {
+			return nil
+		}
+
+		if _, err := io.Copy(os.Stdout, r); err != nil {
+			return err
+		}
+
+		return errFound
+	})
+	switch err {
+	case errFound:
+		return nil
+	case nil:
+		return &exitError{code: ExitNotFound, err: fmt.Errorf("%s: not in layer %s", args[1], fi.layer)}
+	}
+
+	return err
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/dbulkow/registry_cmd/registry"
+	"github.com/dbulkow/registry_cmd/registry/registrytest"
+)
+
+// pushOpaque pushes app/opaque:1, whose top layer makes /etc opaque after
+// the entries it adds to it.
+func pushOpaque(reg *registrytest.Registry) {
+	reg.PushImage("app/opaque", "1", registrytest.Image{
+		Layers: [][]byte{baseLayer, registrytest.Layer(
+			registrytest.File{Name: "etc/", Type: '5'},
+			registrytest.File{Name: "etc/os-release", Body: "ID=alpine\nVERSION_ID=3.20.0\n"},
+			registrytest.File{Name: "etc/hostname", Body: "opaque\n"},
+			registrytest.File{Name: "etc/.wh..wh..opq"},
+		)},
+	})
+}
+
+func TestLsFiles(t *testing.T) {
+	e := seeded(t)
+
+	out := e.ok("ls-files", "app/web:2.0")
+	contains(t, out, "/etc/os-release", "/srv/app.js", "/srv/index.html")
+
+	// removed by a whiteout in the top layer
+	lacks(t, out, "/etc/motd", ".wh.")
+
+	out = e.ok("ls-files", "app/web:2.0", "/srv/*")
+	contains(t, out, "/srv/app.js")
+	lacks(t, out, "/etc/os-release")
+
+	out = e.ok("ls-files", "app/api:latest")
+	contains(t, out, "-rwxr-xr-x", "/app/current -> api")
+}
+
+func TestLsFilesPlatform(t *testing.T) {
+	e := seeded(t)
+
+	contains(t, e.ok("ls-files", "multi/arch:1"), "/arch")
+	contains(t, e.ok("cat", "--platform", "linux/arm64", "multi/arch:1", "/arch"), "arm64")
+	contains(t, e.ok("cat", "multi/arch:1", "/arch"), "amd64")
+
+	e.fails(ExitFailure, "ls-files", "--platform", "windows/amd64", "multi/arch:1")
+}
+
+func TestCat(t *testing.T) {
+	e := seeded(t)
+
+	if out := e.ok("cat", "app/web:1.0", "/srv/index.html"); out != "v1\n" {
+		t.Errorf("cat app/web:1.0 = %q, want v1", out)
+	}
+	if out := e.ok("cat", "app/web:2.0", "srv/index.html"); out != "v2\n" {
+		t.Errorf("cat app/web:2.0 = %q, want v2", out)
+	}
+
+	// symlinks are followed
+	if out := e.ok("cat", "app/api:latest", "/app/current"); out != "#!/bin/sh\n" {
+		t.Errorf("cat through symlink = %q", out)
+	}
+
+	// whited out, missing and not a file
+	e.fails(ExitNotFound, "cat", "app/web:2.0", "/etc/motd")
+	e.fails(ExitNotFound, "cat", "app/web:2.0", "/no/such/file")
+	e.fails(ExitFailure, "cat", "app/web:2.0", "/srv")
+}
+
+func TestCatLayerError(t *testing.T) {
+	e := seeded(t)
+
+	// the layer is read once to find the file, then again to copy it
+	e.reg.FailAfter("GET", "/blobs/"+string(registry.FromBytes(web2Layer)), 500, 0, 1)
+
+	r := e.fails(ExitNetwork, "--retries", "0", "cat", "app/web:2.0", "/srv/index.html")
+	if r.stdout != "" {
+		t.Errorf("output on error: %q", r.stdout)
+	}
+}
+
+func TestLsFilesOpaque(t *testing.T) {
+	e := seeded(t)
+	pushOpaque(e.reg)
+
+	// the marker hides the lower layer's /etc, not the entries before it
+	out := e.ok("ls-files", "app/opaque:1")
+	contains(t, out, "/etc/os-release", "/etc/hostname", "/lib/apk/db/installed")
+	lacks(t, out, "/etc/motd", ".wh.")
+
+	contains(t, e.ok("cat", "app/opaque:1", "/etc/os-release"), "3.20.0")
+}
@@ -1,13 +1,17 @@
 module github.com/dbulkow/registry_cmd
 
-go 1.17
+go 1.22
 
 require (
 	github.com/dustin/go-humanize v1.0.0
+	github.com/klauspost/compress v1.18.0
 	github.com/spf13/cobra v1.2.1
+	golang.org/x/crypto v0.31.0
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (
 	github.com/inconshreveable/mousetrap v1.0.0 // indirect
 	github.com/spf13/pflag v1.0.5 // indirect
+	golang.org/x/sys v0.28.0 // indirect
 )
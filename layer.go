@@ -0,0 +1,264 @@
+package main
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/dbulkow/registry_cmd/registry"
+	"github.com/klauspost/compress/zstd"
+)
+
+const (
+	whiteoutPrefix = ".wh."
+	whiteoutOpaque = ".wh..wh..opq"
+)
+
+var (
+	gzipMagic = []byte{0x1f, 0x8b}
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+)
+
+// decompress returns the tar stream of a layer, detecting the compression
+// from the content rather than trusting the media type. The caller must
+// close it.
+func decompress(r io.Reader) (io.ReadCloser, error) {
+	br := bufio.NewReader(r)
+
+	magic, err := br.Peek(4)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+
+	switch {
+	case bytes.HasPrefix(magic, gzipMagic):
+		return gzip.NewReader(br)
+	case bytes.HasPrefix(magic, zstdMagic):
+		zr, err := zstd.NewReader(br, zstd.WithDecoderConcurrency(1))
+		if err != nil {
+			return nil, err
+		}
+		return zr.IOReadCloser(), nil
+	}
+
+	return ioutil.NopCloser(br), nil
+}
+
+// walkLayer streams a layer blob and calls fn for every tar entry.
+func walkLayer(ctx context.Context, client *registry.Client, image string, digest registry.Digest, fn func(*tar.Header, io.Reader) error) error {
+	blob, err := client.OpenBlob(ctx, image, digest)
+	if err != nil {
+		return err
+	}
+	defer blob.Close()
+
+	r, err := decompress(blob)
+	if err != nil {
+		return fmt.Errorf("layer %s: %v", digest, err)
+	}
+	defer r.Close()
+
+	tr := tar.NewReader(r)
+
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("layer %s: %v", digest, err)
+		}
+
+		if err := fn(hdr, tr); err != nil {
+			return err
+		}
+	}
+}
+
+// countReader counts the bytes read through it.
+type countReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// layerDiffID streams a layer blob and returns the digest of its
+// uncompressed content, the diff ID image configs list, and the size of
+// the blob.
+func layerDiffID(ctx context.Context, client *registry.Client, image string, digest registry.Digest) (registry.Digest, int64, error) {
+	blob, err := client.OpenBlob(ctx, image, digest)
+	if err != nil {
+		return "", 0, err
+	}
+	defer blob.Close()
+
+	cr := &countReader{r: blob}
+
+	r, err := decompress(cr)
+	if err != nil {
+		return "", 0, fmt.Errorf("layer %s: %v", digest, err)
+	}
+	defer r.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, r); err != nil {
+		return "", 0, fmt.Errorf("layer %s: %v", digest, err)
+	}
+
+	// anything after the end of the compressed stream
+	if _, err := io.Copy(ioutil.Discard, cr); err != nil {
+		return "", 0, fmt.Errorf("layer %s: %v", digest, err)
+	}
+
+	return registry.Digest(fmt.Sprintf("sha256:%x", h.Sum(nil))), cr.n, nil
+}
+
+// cleanPath returns the absolute, cleaned form of a tar entry name.
+func cleanPath(name string) string {
+	return path.Clean("/" + name)
+}
+
+// whiteout reports the path hidden by a whiteout entry, and whether the
+// entry is an opaque directory marker hiding everything below that path.
+func whiteout(name string) (string, bool, bool) {
+	dir, base := path.Split(cleanPath(name))
+
+	switch {
+	case base == whiteoutOpaque:
+		return path.Clean(dir), true, true
+	case strings.HasPrefix(base, whiteoutPrefix):
+		return path.Join(dir, strings.TrimPrefix(base, whiteoutPrefix)), false, true
+	}
+
+	return "", false, false
+}
+
+type fileinfo struct {
+	path     string
+	typ      byte
+	mode     os.FileMode
+	size     int64
+	link     string
+	sum      string
+	layer    registry.Digest
+	whiteout bool
+}
+
+// removeTree deletes p and everything below it from view.
+func removeTree(view map[string]*fileinfo, p string, self bool) {
+	prefix := strings.TrimSuffix(p, "/") + "/"
+	for k := range view {
+		if strings.HasPrefix(k, prefix) || (self && k == p) {
+			delete(view, k)
+		}
+	}
+}
+
+// layerView applies layers in order and returns the filesystem they leave
+// behind. Whiteouts remove entries of lower layers and are kept in the
+// view, marked, so callers can tell removal from absence. With hash set,
+// regular file contents are summed.
+func layerView(ctx context.Context, client *registry.Client, image string, layers []registry.Descriptor, hash bool) (map[string]*fileinfo, error) {
+	view := make(map[string]*fileinfo)
+
+	for _, l := range layers {
+		// entries of this layer are kept apart until it is read, as its
+		// whiteouts only hide lower layers, wherever they are in the tar
+		upper := make(map[string]*fileinfo)
+
+		err := walkLayer(ctx, client, image, l.Digest, func(hdr *tar.Header, r io.Reader) error {
+			if p, opaque, ok := whiteout(hdr.Name); ok {
+				removeTree(view, p, !opaque)
+				if !opaque {
+					upper[p] = &fileinfo{path: p, layer: l.Digest, whiteout: true}
+				}
+				return nil
+			}
+
+			p := cleanPath(hdr.Name)
+
+			if old, ok := view[p]; ok && old.typ == tar.TypeDir && hdr.Typeflag != tar.TypeDir {
+				removeTree(view, p, false)
+			}
+
+			fi := &fileinfo{
+				path:  p,
+				typ:   hdr.Typeflag,
+				mode:  hdr.FileInfo().Mode(),
+				size:  hdr.Size,
+				link:  hdr.Linkname,
+				layer: l.Digest,
+			}
+
+			if hash && hdr.Typeflag == tar.TypeReg {
+				h := sha256.New()
+				if _, err := io.Copy(h, r); err != nil {
+					return err
+				}
+				fi.sum = fmt.Sprintf("%x", h.Sum(nil))
+			}
+
+			upper[p] = fi
+
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		for p, fi := range upper {
+			view[p] = fi
+		}
+	}
+
+	return view, nil
+}
+
+// resolvePath follows symbolic links in p, including links in its parent
+// directories, using the entries of view. Links never leave the root.
+func resolvePath(view map[string]*fileinfo, p string) (string, error) {
+	p = cleanPath(p)
+
+	for hops := 0; hops < 40; hops++ {
+		parts := strings.Split(strings.TrimPrefix(p, "/"), "/")
+		resolved := true
+
+		for i := range parts {
+			cur := "/" + strings.Join(parts[:i+1], "/")
+
+			fi, ok := view[cur]
+			if !ok || fi.whiteout || fi.typ != tar.TypeSymlink {
+				continue
+			}
+
+			target := fi.link
+			if !path.IsAbs(target) {
+				target = path.Join(path.Dir(cur), target)
+			}
+
+			p = cleanPath(path.Join(append([]string{target}, parts[i+1:]...)...))
+			resolved = false
+			break
+		}
+
+		if resolved {
+			return p, nil
+		}
+	}
+
+	return "", fmt.Errorf("%s: too many levels of symbolic links", p)
+}
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"os"
 
+	"github.com/dbulkow/registry_cmd/registry"
 	humanize "github.com/dustin/go-humanize"
 	"github.com/spf13/cobra"
 )
@@ -15,7 +16,7 @@ func init() {
 		Use:     "list [glob]",
 		Aliases: []string{"ls"},
 		Short:   "List images in the registry",
-		Run:     list,
+		RunE:    list,
 	}
 
 	listCmd.Flags().BoolVarP(&getsize, "size", "s", false, "List image size")
@@ -25,18 +26,18 @@ func init() {
 	RootCmd.AddCommand(listCmd)
 }
 
-func list(cmd *cobra.Command, args []string) {
-	url := cmd.Flag("registry").Value.String()
-
+func list(cmd *cobra.Command, args []string) error {
 	var filter string
 	if len(args) > 0 {
-		filter = args[0]
+		var err error
+		if filter, err = useRepository(cmd, args[0]); err != nil {
+			return err
+		}
 	}
 
-	conn, err := connect(cmd)
+	client, err := connect(cmd)
 	if err != nil {
-		fmt.Fprintln(os.Stderr, err)
-		return
+		return err
 	}
 
 	type Image struct {
@@ -46,21 +47,27 @@ func list(cmd *cobra.Command, args []string) {
 
 	imageset := make([]*Image, 0)
 
-	images, err := catalog(conn, url)
+	images, err := client.Catalog(runctx)
 	if err != nil {
-		fmt.Fprintln(os.Stderr, err)
-		return
+		return err
 	}
 
+	var failures tally
+
 	n := 0
 	for _, name := range images {
+		if err := interrupted(); err != nil {
+			return err
+		}
+
 		if filter != "" && !Glob(filter, name) {
 			continue
 		}
 
-		tags, err := tags(conn, url, name)
+		tags, err := client.Tags(runctx, name)
 		if err != nil {
 			fmt.Fprintln(os.Stderr, err)
+			failures.add(err)
 			continue
 		}
 
@@ -78,34 +85,66 @@ func list(cmd *cobra.Command, args []string) {
 		imageset = append(imageset, img)
 	}
 
+	type Detail struct {
+		name      string
+		tag       string
+		size      uint64
+		sizeerr   error
+		digest    registry.Digest
+		digesterr error
+	}
+
+	details := make([]*Detail, 0)
 	for _, i := range imageset {
 		for _, t := range i.tags {
-			fmt.Printf("%-*s", n+1, i.name+":"+t)
+			details = append(details, &Detail{name: i.name, tag: t})
+		}
+	}
 
+	if getsize || getdigest {
+		parallel(workers(), len(details), func(k int) {
+			d := details[k]
 			if getsize {
-				sz, err := imagesize(conn, url, i.name, t)
-				if err != nil {
-					fmt.Fprintln(os.Stderr, "imagesize", err)
-					continue
-				}
-
-				if usebytes {
-					fmt.Printf(" %12d", sz)
-				} else {
-					fmt.Printf(" %12s", humanize.Bytes(sz))
-				}
+				d.size, d.sizeerr = client.ImageSize(runctx, d.name, d.tag)
 			}
-
 			if getdigest {
-				digest, _, err := manifest(conn, url, i.name, t)
-				if err != nil {
-					fmt.Fprintln(os.Stderr, "manifest", err)
-					continue
-				}
-				fmt.Printf(" %s", digest)
+				d.digest, _, d.digesterr = client.Blobs(runctx, d.name, d.tag)
+			}
+		})
+	}
+
+	for _, d := range details {
+		err := d.sizeerr
+		if err == nil {
+			err = d.digesterr
+		}
+		failures.add(err)
+
+		fmt.Printf("%-*s", n+1, d.name+":"+d.tag)
+
+		if getsize {
+			if d.sizeerr != nil {
+				fmt.Fprintln(os.Stderr, "imagesize", d.sizeerr)
+				continue
 			}
 
-			fmt.Printf("\n")
+			if usebytes {
+				fmt.Printf(" %12d", d.size)
+			} else {
+				fmt.Printf(" %12s", humanize.Bytes(d.size))
+			}
 		}
+
+		if getdigest {
+			if d.digesterr != nil {
+				fmt.Fprintln(os.Stderr, "manifest", d.digesterr)
+				continue
+			}
+			fmt.Printf(" %s", d.digest)
+		}
+
+		fmt.Printf("\n")
 	}
+
+	return failures.err("images")
 }
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/dbulkow/registry_cmd/registry"
+	"github.com/dbulkow/registry_cmd/registry/registrytest"
+)
+
+func TestList(t *testing.T) {
+	e := seeded(t)
+
+	out := e.ok("list")
+	contains(t, out, "app/api:latest", "app/web:1.0", "app/web:2.0", "multi/arch:1", "tools/mix:1")
+
+	out = e.ok("ls", "app/*")
+	contains(t, out, "app/web:1.0", "app/api:latest")
+	lacks(t, out, "tools/mix")
+}
+
+func TestListSizeDigest(t *testing.T) {
+	e := seeded(t)
+
+	_, data, _ := e.reg.Manifest("app/web", "1.0")
+	size := len(baseLayer) + len(web1Layer)
+
+	out := e.ok("list", "-s", "-b", "-d", "-j", "4", "app/web")
+	for _, line := range strings.Split(strings.TrimSpace(out), "\n") {
+		if strings.HasPrefix(line, "app/web:1.0") {
+			contains(t, line, fmt.Sprint(size), string(registry.FromBytes(data)))
+			return
+		}
+	}
+	t.Errorf("no app/web:1.0 line:\n%s", out)
+}
+
+func TestListDigestVerified(t *testing.T) {
+	e := seeded(t, registrytest.WithoutDigestHeader())
+
+	_, data, _ := e.reg.Manifest("app/web", "1.0")
+	contains(t, e.ok("list", "-d", "app/web"), "app/web:1.0 "+string(registry.FromBytes(data)))
+
+	e = seeded(t)
+	e.reg.Tamper("app/web", "1.0", append(data, '\n'))
+
+	r := e.fails(ExitPartial, "list", "-d", "app/web")
+	contains(t, r.stdout, "app/web:2.0 sha256:")
+	contains(t, r.stderr, "digest mismatch")
+}
+
+func TestListPagination(t *testing.T) {
+	e := newEnv(t, registrytest.WithPageSize(2))
+	seed(e.reg)
+
+	for i := 0; i < 5; i++ {
+		e.reg.PushImage("app/web", fmt.Sprintf("extra%d", i), registrytest.Image{})
+	}
+
+	out := e.ok("list")
+	contains(t, out, "app/web:extra4", "tools/mix:1")
+
+	if n := strings.Count(out, "\n"); n != 10 {
+		t.Errorf("%d images listed, want 10:\n%s", n, out)
+	}
+}
+
+func TestListPartialFailure(t *testing.T) {
+	e := seeded(t)
+
+	e.reg.Fail("GET", "^/v2/tools/mix/tags/list$", 404, 0)
+
+	r := e.fails(ExitPartial, "list")
+	contains(t, r.stdout, "app/web:1.0")
+	contains(t, r.stderr, "1 of 5 images failed")
+}
@@ -0,0 +1,292 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/dbulkow/registry_cmd/registry"
+	"github.com/dbulkow/registry_cmd/registry/registrytest"
+)
+
+// The tests run regcmd end-to-end: the test binary starts itself as the
+// command, with its own home, against an in-process fake registry.
+func TestMain(m *testing.M) {
+	if os.Getenv("REGCMD_TEST_MAIN") == "1" {
+		main()
+		os.Exit(ExitOK)
+	}
+
+	os.Exit(m.Run())
+}
+
+type testenv struct {
+	t    *testing.T
+	reg  *registrytest.Registry
+	home string
+	env  []string
+
+	// stdin is the input of the next runs
+	stdin string
+}
+
+// newEnv starts a fake registry and gives regcmd an empty home.
+func newEnv(t *testing.T, opts ...registrytest.Option) *testenv {
+	t.Helper()
+
+	reg := registrytest.New(opts...)
+	t.Cleanup(reg.Close)
+
+	return &testenv{t: t, reg: reg, home: t.TempDir()}
+}
+
+type result struct {
+	stdout string
+	stderr string
+	code   int
+}
+
+// run runs regcmd with args against the registry of e.
+func (e *testenv) run(args ...string) *result {
+	e.t.Helper()
+
+	cmd := exec.Command(os.Args[0], args...)
+	cmd.Env = append([]string{
+		"REGCMD_TEST_MAIN=1",
+		"PATH=" + os.Getenv("PATH"),
+		"HOME=" + e.home,
+		"XDG_CONFIG_HOME=" + filepath.Join(e.home, "config"),
+		"XDG_CACHE_HOME=" + filepath.Join(e.home, "cache"),
+		"REGISTRY=" + e.reg.URL,
+	}, e.env...)
+
+	var stdout, stderr bytes.Buffer
+	cmd.Stdin = strings.NewReader(e.stdin)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	err := cmd.Run()
+
+	var exiterr *exec.ExitError
+	if err != nil && !errors.As(err, &exiterr) {
+		e.t.Fatal(err)
+	}
+
+	return &result{stdout: stdout.String(), stderr: stderr.String(), code: cmd.ProcessState.ExitCode()}
+}
+
+// ok runs regcmd and fails the test unless it succeeds.
+func (e *testenv) ok(args ...string) string {
+	e.t.Helper()
+
+	r := e.run(args...)
+	if r.code != ExitOK {
+		e.t.Fatalf("regcmd %s: exit %d\n%s%s", strings.Join(args, " "), r.code, r.stdout, r.stderr)
+	}
+
+	return r.stdout
+}
+
+// fails runs regcmd and fails the test unless it exits with code.
+func (e *testenv) fails(code int, args ...string) *result {
+	e.t.Helper()
+
+	r := e.run(args...)
+	if r.code != code {
+		e.t.Fatalf("regcmd %s: exit %d, want %d\n%s%s", strings.Join(args, " "), r.code, code, r.stdout, r.stderr)
+	}
+
+	return r
+}
+
+func contains(t *testing.T, out string, want ...string) {
+	t.Helper()
+
+	for _, w := range want {
+		if !strings.Contains(out, w) {
+			t.Errorf("output lacks %q:\n%s", w, out)
+		}
+	}
+}
+
+func lacks(t *testing.T, out string, unwanted ...string) {
+	t.Helper()
+
+	for _, u := range unwanted {
+		if strings.Contains(out, u) {
+			t.Errorf("output has %q:\n%s", u, out)
+		}
+	}
+}
+
+var (
+	baseLayer = registrytest.Layer(
+		registrytest.File{Name: "etc/", Type: '5'},
+		registrytest.File{Name: "etc/os-release", Body: "ID=alpine\nVERSION_ID=3.19.1\n"},
+		registrytest.File{Name: "etc/motd", Body: "welcome\n"},
+		registrytest.File{Name: "lib/apk/db/installed", Body: "P:musl\nV:1.2.4-r2\nA:x86_64\nL:MIT\n\n"},
+	)
+	web1Layer = registrytest.Layer(
+		registrytest.File{Name: "srv/", Type: '5'},
+		registrytest.File{Name: "srv/index.html", Body: "v1\n"},
+	)
+	web2Layer = registrytest.Layer(
+		registrytest.File{Name: "srv/", Type: '5'},
+		registrytest.File{Name: "srv/index.html", Body: "v2\n"},
+		registrytest.File{Name: "srv/app.js", Body: "main()\n"},
+		registrytest.File{Name: "etc/.wh.motd"},
+	)
+	apiLayer = registrytest.Layer(
+		registrytest.File{Name: "app/", Type: '5'},
+		registrytest.File{Name: "app/api", Body: "#!/bin/sh\n", Mode: 0755},
+		registrytest.File{Name: "app/current", Type: '2', Linkname: "api"},
+	)
+	mixLayer = registrytest.Layer(
+		registrytest.File{Name: "node_modules/lodash/package.json", Body: `{"name":"lodash","version":"4.17.20","license":"MIT"}`},
+		registrytest.File{Name: "usr/lib/python3/site-packages/requests-2.31.0.dist-info/METADATA",
+			Body: "Metadata-Version: 2.1\nName: requests\nVersion: 2.31.0\nLicense: Apache-2.0\n"},
+	)
+)
+
+// seed pushes the images most tests use:
+//
+//	app/web:1.0, app/web:2.0  base layer plus a web layer each
+//	app/api:latest            base layer plus an api layer, OCI manifest
+//	multi/arch:1              manifest list of linux/amd64 and linux/arm64
+//	tools/mix:1               base layer plus npm and python packages
+func seed(reg *registrytest.Registry) {
+	reg.PushImage("app/web", "1.0", registrytest.Image{
+		Created: "2024-01-01T00:00:00Z",
+		Config:  registry.ContainerConfig{Env: []string{"V=1"}, Labels: map[string]string{"team": "web"}},
+		Layers:  [][]byte{baseLayer, web1Layer},
+	})
+	reg.PushImage("app/web", "2.0", registrytest.Image{
+		Created: "2024-06-01T00:00:00Z",
+		Config:  registry.ContainerConfig{Env: []string{"V=2"}, Labels: map[string]string{"team": "web"}},
+		Layers:  [][]byte{baseLayer, web2Layer},
+	})
+	reg.PushImage("app/api", "latest", registrytest.Image{
+		MediaType: registry.MediaTypeOCIManifest,
+		Created:   "2024-03-01T00:00:00Z",
+		Config:    registry.ContainerConfig{Entrypoint: []string{"/app/api"}, Labels: map[string]string{"team": "api"}},
+		Layers:    [][]byte{baseLayer, apiLayer},
+	})
+
+	amd := reg.PushImage("multi/arch", "", registrytest.Image{
+		Layers: [][]byte{registrytest.Layer(registrytest.File{Name: "arch", Body: "amd64\n"})},
+	})
+	arm := reg.PushImage("multi/arch", "", registrytest.Image{
+		Platform: registry.Platform{OS: "linux", Architecture: "arm64"},
+		Layers:   [][]byte{registrytest.Layer(registrytest.File{Name: "arch", Body: "arm64\n"})},
+	})
+	reg.PushIndex("multi/arch", "1", registry.MediaTypeManifestList, amd, arm)
+
+	reg.PushImage("tools/mix", "1", registrytest.Image{
+		Created: "2024-02-01T00:00:00Z",
+		Layers:  [][]byte{baseLayer, mixLayer},
+	})
+}
+
+// seeded returns an environment whose registry holds the seed images.
+func seeded(t *testing.T, opts ...registrytest.Option) *testenv {
+	t.Helper()
+
+	e := newEnv(t, opts...)
+	seed(e.reg)
+
+	return e
+}
+
+func TestVersion(t *testing.T) {
+	e := newEnv(t)
+	contains(t, e.ok("version"), "registry V2.4.1")
+}
+
+func TestUsageErrors(t *testing.T) {
+	e := newEnv(t)
+
+	e.fails(ExitUsage, "list", "--no-such-flag")
+	e.fails(ExitUsage, "diff", "app/web:1.0")
+	e.fails(ExitUsage, "ls-files", "--platform", "bogus", "app/web:1.0")
+}
+
+func TestRegistryUnreachable(t *testing.T) {
+	e := newEnv(t)
+	e.reg.Close()
+
+	e.fails(ExitNetwork, "--retries", "0", "list")
+}
+
+func TestRegistryErrors(t *testing.T) {
+	e := seeded(t)
+
+	e.reg.Fail("GET", "/tags/list", 503, 0)
+	r := e.fails(ExitNetwork, "--retries", "0", "list")
+	contains(t, r.stderr, "4 of 4 images failed")
+}
+
+func TestRetry(t *testing.T) {
+	e := seeded(t)
+
+	e.reg.Fail("GET", "^/v2/_catalog$", 429, 2)
+	contains(t, e.ok("list"), "app/web:1.0")
+
+	if n := e.reg.Count("GET", "^/v2/_catalog$"); n != 3 {
+		t.Errorf("%d catalog requests, want 3", n)
+	}
+}
+
+func TestTokenAuth(t *testing.T) {
+	e := newEnv(t, registrytest.WithTokenAuth("ci", "secret"))
+	seed(e.reg)
+
+	e.fails(ExitAuth, "list")
+
+	e.env = append(e.env, "CI_PASSWORD=secret")
+	e.ok("context", "add", "fake", "--url", e.reg.URL, "--auth", "token", "--username", "ci", "--password-env", "CI_PASSWORD")
+	e.ok("context", "use", "fake")
+
+	// REGISTRY overrides the registry of the context, clear it
+	e.env = append(e.env, "REGISTRY=")
+	contains(t, e.ok("list"), "app/web:1.0", "tools/mix:1")
+
+	e.env = append(e.env, "CI_PASSWORD=wrong")
+	e.fails(ExitAuth, "list")
+}
+
+func TestBasicAuth(t *testing.T) {
+	e := newEnv(t, registrytest.WithBasicAuth("ci", "secret"))
+	seed(e.reg)
+
+	e.env = append(e.env, "CI_PASSWORD=secret", "REGISTRY=")
+	e.ok("context", "add", "fake", "--url", e.reg.URL, "--auth", "basic", "--username", "ci", "--password-env", "CI_PASSWORD")
+
+	contains(t, e.ok("--context", "fake", "list", "app/*"), "app/api:latest")
+}
+
+func TestAuthOtherRegistry(t *testing.T) {
+	e := newEnv(t, registrytest.WithBasicAuth("ci", "secret"))
+
+	// another registry accepting the same credentials shows whether they
+	// are sent to it
+	other := registrytest.New(registrytest.WithBasicAuth("ci", "secret"))
+	t.Cleanup(other.Close)
+	seed(other)
+
+	e.env = append(e.env, "CI_PASSWORD=secret")
+	e.ok("context", "add", "fake", "--url", e.reg.URL, "--auth", "basic", "--username", "ci", "--password-env", "CI_PASSWORD")
+	e.ok("context", "use", "fake")
+
+	e.env = append(e.env, "REGISTRY="+other.URL)
+	e.fails(ExitAuth, "list")
+	e.fails(ExitAuth, "--registry", other.URL, "list")
+
+	// unless a context is for that registry
+	e.ok("context", "add", "other", "--url", other.URL, "--auth", "basic", "--username", "ci", "--password-env", "CI_PASSWORD")
+	e.ok("context", "use", "fake")
+	contains(t, e.ok("list"), "app/api:latest")
+}
@@ -0,0 +1,397 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/dbulkow/registry_cmd/registry"
+	"github.com/spf13/cobra"
+)
+
+var mutateplatform string
+var mutatelabels, mutateunlabels, mutateenvs, mutateunenvs, mutateappend []string
+var mutateentrypoint, mutatecmd, mutateuser, mutateworkdir string
+
+func init() {
+	mutateCmd := &cobra.Command{
+		Use:   "mutate <image:tag> <image:tag>",
+		Short: "Edit the config of an image or append layers to it",
+		Long: `Edit the config of an image or append layers to it, and push the result
+to the second tag, which may be the first to replace it:
+
+  regcmd mutate --label git.sha=$SHA --env BUILD=42 team/app:1 team/app:1-ci
+  regcmd mutate --entrypoint '["/app", "--serve"]' --append extra.tar team/app:1 team/app:2
+
+Entrypoint and cmd are a JSON array or words separated by spaces. Empty
+values for entrypoint, cmd, user and workdir clear them.
+
+Appended tarballs may be plain or compressed; they are stored as gzip
+layers on top of the image. Fields of the config regcmd does not know
+about are kept. From a manifest list, the image for --platform is
+mutated and pushed on its own.
+`,
+		Annotations: mutating,
+		RunE:        mutate,
+	}
+
+	flags := mutateCmd.Flags()
+	flags.StringArrayVar(&mutatelabels, "label", nil, "Set label key=value, repeatable")
+	flags.StringArrayVar(&mutateunlabels, "unset-label", nil, "Remove label, repeatable")
+	flags.StringArrayVar(&mutateenvs, "env", nil, "Set environment variable KEY=value, repeatable")
+	flags.StringArrayVar(&mutateunenvs, "unset-env", nil, "Remove environment variable, repeatable")
+	flags.StringVar(&mutateentrypoint, "entrypoint", "", "Set entrypoint")
+	flags.StringVar(&mutatecmd, "cmd", "", "Set cmd")
+	flags.StringVar(&mutateuser, "user", "", "Set user")
+	flags.StringVar(&mutateworkdir, "workdir", "", "Set working directory")
+	flags.StringArrayVar(&mutateappend, "append", nil, "Append tarball as a layer, repeatable")
+	flags.StringVar(&mutateplatform, "platform", "", "Platform to select from manifest lists (os/arch[/variant])")
+
+	RootCmd.AddCommand(mutateCmd)
+}
+
+// imageConfig fetches the config blob of an image as its fields, so that
+// edits keep the fields registry.ImageConfig leaves out.
+func imageConfig(ctx context.Context, client *registry.Client, image string, digest registry.Digest) (map[string]json.RawMessage, error) {
+	blob, err := client.OpenBlob(ctx, image, digest)
+	if err != nil {
+		return nil, fmt.Errorf("config: %w", err)
+	}
+	defer blob.Close()
+
+	data, err := ioutil.ReadAll(blob)
+	if err != nil {
+		return nil, fmt.Errorf("config: %v", err)
+	}
+
+	if err := digest.Verify(data); err != nil {
+		return nil, fmt.Errorf("config: %w", err)
+	}
+
+	config := make(map[string]json.RawMessage)
+	if err := json.Unmarshal(data, &config); err != nil {
+		return nil, fmt.Errorf("config: %v", err)
+	}
+
+	return config, nil
+}
+
+// getField decodes field k of m into v, leaving v alone when it is absent.
+func getField(m map[string]json.RawMessage, k string, v interface{}) error {
+	data, ok := m[k]
+	if !ok || string(data) == "null" {
+		return nil
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("%s: %v", k, err)
+	}
+	return nil
+}
+
+// setField stores v as field k of m, or removes the field when empty is set.
+func setField(m map[string]json.RawMessage, k string, v interface{}, empty bool) error {
+	if empty {
+		delete(m, k)
+		return nil
+	}
+
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	m[k] = data
+
+	return nil
+}
+
+// words parses an entrypoint or cmd, as a JSON array or space separated.
+func words(s string) ([]string, error) {
+	if strings.HasPrefix(strings.TrimSpace(s), "[") {
+		var w []string
+		if err := json.Unmarshal([]byte(s), &w); err != nil {
+			return nil, fmt.Errorf("%q: %v", s, err)
+		}
+		return w, nil
+	}
+	return strings.Fields(s), nil
+}
+
+// splitAssign splits key=value, as given to --label and --env.
+func splitAssign(s string) (string, string, error) {
+	i := strings.IndexByte(s, '=')
+	if i <= 0 {
+		return "", "", fmt.Errorf("%q: want key=value", s)
+	}
+	return s[:i], s[i+1:], nil
+}
+
+// editConfig applies the config flags to the container config of an
+// image config.
+func editConfig(cmd *cobra.Command, config map[string]json.RawMessage) error {
+	cc := make(map[string]json.RawMessage)
+	if err := getField(config, "config", &cc); err != nil {
+		return err
+	}
+
+	labels := make(map[string]string)
+	if err := getField(cc, "Labels", &labels); err != nil {
+		return err
+	}
+	for _, l := range mutatelabels {
+		k, v, err := splitAssign(l)
+		if err != nil {
+			return err
+		}
+		labels[k] = v
+	}
+	for _, k := range mutateunlabels {
+		delete(labels, k)
+	}
+	if err := setField(cc, "Labels", labels, len(labels) == 0); err != nil {
+		return err
+	}
+
+	var env []string
+	if err := getField(cc, "Env", &env); err != nil {
+		return err
+	}
+	for _, e := range mutateenvs {
+		k, _, err := splitAssign(e)
+		if err != nil {
+			return err
+		}
+		env = append(unsetEnv(env, k), e)
+	}
+	for _, k := range mutateunenvs {
+		env = unsetEnv(env, k)
+	}
+	if err := setField(cc, "Env", env, len(env) == 0); err != nil {
+		return err
+	}
+
+	for flag, field := range map[string]string{"entrypoint": "Entrypoint", "cmd": "Cmd"} {
+		if !cmd.Flag(flag).Changed {
+			continue
+		}
+
+		w, err := words(cmd.Flag(flag).Value.String())
+		if err != nil {
+			return fmt.Errorf("--%s %v", flag, err)
+		}
+		if err := setField(cc, field, w, len(w) == 0); err != nil {
+			return err
+		}
+	}
+
+	for flag, field := range map[string]string{"user": "User", "workdir": "WorkingDir"} {
+		if !cmd.Flag(flag).Changed {
+			continue
+		}
+
+		v := cmd.Flag(flag).Value.String()
+		if err := setField(cc, field, v, v == ""); err != nil {
+			return err
+		}
+	}
+
+	return setField(config, "config", cc, false)
+}
+
+// unsetEnv returns env without the variable named k.
+func unsetEnv(env []string, k string) []string {
+	out := env[:0:0]
+	for _, e := range env {
+		if e != k && !strings.HasPrefix(e, k+"=") {
+			out = append(out, e)
+		}
+	}
+	return out
+}
+
+// tarLayer reads a plain or compressed tarball and returns it as a gzip
+// layer with the diff ID of its content.
+func tarLayer(path string) ([]byte, registry.Digest, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, "", err
+	}
+	defer f.Close()
+
+	r, err := decompress(f)
+	if err != nil {
+		return nil, "", fmt.Errorf("%s: %v", path, err)
+	}
+	defer r.Close()
+
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	h := sha256.New()
+
+	tee := io.TeeReader(r, io.MultiWriter(zw, h))
+
+	// reading the entries checks it is a tarball
+	tr := tar.NewReader(tee)
+	for {
+		_, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, "", fmt.Errorf("%s: %v", path, err)
+		}
+	}
+
+	// the padding after the end of the archive
+	if _, err := io.Copy(ioutil.Discard, tee); err != nil {
+		return nil, "", fmt.Errorf("%s: %v", path, err)
+	}
+
+	if err := zw.Close(); err != nil {
+		return nil, "", err
+	}
+
+	return buf.Bytes(), registry.Digest(fmt.Sprintf("sha256:%x", h.Sum(nil))), nil
+}
+
+func mutate(cmd *cobra.Command, args []string) error {
+	if len(args) != 2 {
+		return usageError(cmd)
+	}
+
+	changed := false
+	for _, f := range []string{"label", "unset-label", "env", "unset-env", "entrypoint", "cmd", "user", "workdir", "append"} {
+		changed = changed || cmd.Flag(f).Changed
+	}
+	if !changed {
+		return usageError(cmd)
+	}
+
+	src, err := useReference(cmd, args[0])
+	if err != nil {
+		return err
+	}
+
+	dst, err := useReference(cmd, args[1])
+	if err != nil {
+		return err
+	}
+	if dst.Tag == "" {
+		return fmt.Errorf("%s: destination needs a tag", dst)
+	}
+
+	plat, err := platform(mutateplatform)
+	if err != nil {
+		return err
+	}
+
+	client, err := connect(cmd)
+	if err != nil {
+		return err
+	}
+
+	m, _, err := client.ImageManifest(runctx, src.Name, src.Ref(), plat)
+	if err != nil {
+		return fmt.Errorf("manifest: %w", err)
+	}
+
+	mediaType, layerType := m.MediaType, registry.MediaTypeDockerLayer
+	if mediaType == "" || mediaType == registry.MediaTypeOCIManifest {
+		mediaType, layerType = registry.MediaTypeOCIManifest, registry.MediaTypeOCILayer
+	}
+
+	config, err := imageConfig(runctx, client, src.Name, m.Config.Digest)
+	if err != nil {
+		return err
+	}
+
+	if err := editConfig(cmd, config); err != nil {
+		return &exitError{code: ExitUsage, err: err}
+	}
+
+	for _, l := range m.Layers {
+		if len(l.URLs) > 0 {
+			continue
+		}
+		if err := client.CopyBlob(runctx, dst.Name, src.Name, l.Digest); err != nil {
+			return fmt.Errorf("copy layer %s: %w", l.Digest, err)
+		}
+	}
+
+	if len(mutateappend) > 0 {
+		var rootfs registry.RootFS
+		var history []registry.History
+
+		if err := getField(config, "rootfs", &rootfs); err != nil {
+			return err
+		}
+		if err := getField(config, "history", &history); err != nil {
+			return err
+		}
+
+		for _, path := range mutateappend {
+			data, diffID, err := tarLayer(path)
+			if err != nil {
+				return err
+			}
+
+			digest, err := client.PushBlob(runctx, dst.Name, data)
+			if err != nil {
+				return fmt.Errorf("push layer: %w", err)
+			}
+
+			m.Layers = append(m.Layers, registry.Descriptor{MediaType: layerType, Size: int64(len(data)), Digest: digest})
+			rootfs.DiffIDs = append(rootfs.DiffIDs, diffID)
+
+			// history must account for every layer, or not be kept
+			if history != nil {
+				history = append(history, registry.History{
+					Created:   time.Now().UTC().Format(time.RFC3339),
+					CreatedBy: "regcmd mutate --append " + filepath.Base(path),
+				})
+			}
+		}
+
+		if err := setField(config, "rootfs", rootfs, false); err != nil {
+			return err
+		}
+		if err := setField(config, "history", history, history == nil); err != nil {
+			return err
+		}
+	}
+
+	cdata, err := json.Marshal(config)
+	if err != nil {
+		return err
+	}
+
+	cdigest, err := client.PushBlob(runctx, dst.Name, cdata)
+	if err != nil {
+		return fmt.Errorf("push config: %w", err)
+	}
+
+	m.Config.Digest, m.Config.Size = cdigest, int64(len(cdata))
+
+	data, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+
+	digest, err := client.PushManifest(runctx, dst.Name, dst.Tag, mediaType, data)
+	if err != nil {
+		return fmt.Errorf("push manifest: %w", err)
+	}
+
+	fmt.Printf("mutated %s to %s:%s@%s\n", src, dst.Name, dst.Tag, digest)
+
+	return nil
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/dbulkow/registry_cmd/registry"
+	"github.com/dbulkow/registry_cmd/registry/registrytest"
+)
+
+// pushedConfig returns the manifest and config of an image in the registry.
+func pushedConfig(t *testing.T, e *testenv, repo, ref string) (*registry.Manifest, map[string]interface{}) {
+	t.Helper()
+
+	_, data, ok := e.reg.Manifest(repo, ref)
+	if !ok {
+		t.Fatalf("no manifest %s:%s", repo, ref)
+	}
+
+	var m registry.Manifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		t.Fatal(err)
+	}
+
+	cdata, _ := e.reg.Blob(repo, m.Config.Digest)
+
+	var config map[string]interface{}
+	if err := json.Unmarshal(cdata, &config); err != nil {
+		t.Fatal(err)
+	}
+
+	return &m, config
+}
+
+func TestMutateConfig(t *testing.T) {
+	e := seeded(t)
+
+	out := e.ok("mutate",
+		"--label", "git.sha=abc123", "--unset-label", "team",
+		"--env", "V=3", "--env", "BUILD=42",
+		"--entrypoint", `["/srv/run", "--port", "80"]`, "--cmd", "serve now",
+		"--user", "web", "--workdir", "/srv",
+		"app/web:1.0", "app/web:1.0-ci")
+	contains(t, out, "mutated app/web:1.0 to app/web:1.0-ci@sha256:")
+
+	orig, _ := pushedConfig(t, e, "app/web", "1.0")
+	m, config := pushedConfig(t, e, "app/web", "1.0-ci")
+
+	if !reflect.DeepEqual(m.Layers, orig.Layers) || m.MediaType != orig.MediaType {
+		t.Errorf("layers changed: %+v", m.Layers)
+	}
+
+	cc := config["config"].(map[string]interface{})
+	want := map[string]interface{}{
+		"Labels":     map[string]interface{}{"git.sha": "abc123"},
+		"Env":        []interface{}{"V=3", "BUILD=42"},
+		"Entrypoint": []interface{}{"/srv/run", "--port", "80"},
+		"Cmd":        []interface{}{"serve", "now"},
+		"User":       "web",
+		"WorkingDir": "/srv",
+	}
+	if !reflect.DeepEqual(cc, want) {
+		t.Errorf("config = %v\nwant %v", cc, want)
+	}
+
+	// everything else is kept
+	if config["created"] != "2024-01-01T00:00:00Z" || config["rootfs"] == nil {
+		t.Errorf("config lost fields: %v", config)
+	}
+
+	e.ok("mutate", "--entrypoint", "", "--unset-env", "V", "--unset-env", "BUILD", "--user", "", "app/web:1.0-ci", "app/web:1.0-ci")
+	_, config = pushedConfig(t, e, "app/web", "1.0-ci")
+	cc = config["config"].(map[string]interface{})
+	for _, k := range []string{"Entrypoint", "Env", "User"} {
+		if _, ok := cc[k]; ok {
+			t.Errorf("%s not cleared: %v", k, cc)
+		}
+	}
+	if cc["Cmd"] == nil {
+		t.Errorf("cmd cleared too: %v", cc)
+	}
+}
+
+func TestMutateAppend(t *testing.T) {
+	e := seeded(t)
+
+	dir := t.TempDir()
+	plain := filepath.Join(dir, "extra.tar")
+	gz := filepath.Join(dir, "more.tar.gz")
+	ioutil.WriteFile(plain, registrytest.Tar(registrytest.File{Name: "srv/extra.txt", Body: "extra\n"}), 0644)
+	ioutil.WriteFile(gz, registrytest.Layer(registrytest.File{Name: "srv/index.html", Body: "v3\n"}), 0644)
+
+	e.ok("mutate", "--append", plain, "--append", gz, "--label", "stage=3", "app/api:latest", "app/api:extra")
+
+	orig, _ := pushedConfig(t, e, "app/api", "latest")
+	m, config := pushedConfig(t, e, "app/api", "extra")
+
+	if len(m.Layers) != 4 || m.Layers[2].MediaType != registry.MediaTypeOCILayer {
+		t.Fatalf("layers = %+v", m.Layers)
+	}
+	if m.Layers[1].Digest != orig.Layers[1].Digest {
+		t.Errorf("original layers changed")
+	}
+
+	rootfs := config["rootfs"].(map[string]interface{})
+	diffIDs := rootfs["diff_ids"].([]interface{})
+	if len(diffIDs) != 4 || diffIDs[3] != string(registry.FromBytes(registrytest.Tar(registrytest.File{Name: "srv/index.html", Body: "v3\n"}))) {
+		t.Errorf("diff ids = %v", diffIDs)
+	}
+	if history := config["history"].([]interface{}); len(history) != 4 {
+		t.Errorf("history = %v", history)
+	}
+
+	if out := e.ok("cat", "app/api:extra", "/srv/extra.txt"); out != "extra\n" {
+		t.Errorf("appended file = %q", out)
+	}
+	contains(t, e.ok("cat", "app/api:extra", "/srv/index.html"), "v3")
+
+	// to another repository, from a manifest list
+	e.ok("mutate", "--platform", "linux/arm64", "--append", plain, "multi/arch:1", "other/arch:1")
+	contains(t, e.ok("ls-files", "other/arch:1"), "/arch", "/srv/extra.txt")
+	contains(t, e.ok("cat", "other/arch:1", "/arch"), "arm64")
+
+	notar := filepath.Join(dir, "notar")
+	ioutil.WriteFile(notar, []byte("not a tarball, not at all, no"), 0644)
+	e.fails(ExitFailure, "mutate", "--append", notar, "app/api:latest", "app/api:bad")
+}
+
+func TestMutateUsage(t *testing.T) {
+	e := seeded(t)
+
+	e.fails(ExitUsage, "mutate", "app/web:1.0", "app/web:x")
+	e.fails(ExitUsage, "mutate", "--label", "x=y", "app/web:1.0")
+	e.fails(ExitUsage, "mutate", "--label", "novalue", "app/web:1.0", "app/web:x")
+	e.fails(ExitUsage, "mutate", "--entrypoint", "[bad", "app/web:1.0", "app/web:x")
+	e.fails(ExitNotFound, "mutate", "--label", "x=y", "app/web:9", "app/web:x")
+}
@@ -0,0 +1,376 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/dbulkow/registry_cmd/registry"
+	"github.com/spf13/cobra"
+)
+
+var rebaseold, rebasenew, rebaseplatform string
+
+func init() {
+	rebaseCmd := &cobra.Command{
+		Use:   "rebase --old-base <ref> --new-base <ref> <image:tag> [<image:tag>]",
+		Short: "Move an image onto an updated base image",
+		Long: `Replace the layers an image has from its old base image with the layers
+of a new base image, without rebuilding it, and push the result to the
+second tag, by default the image's own:
+
+  regcmd rebase --old-base library/alpine:3.19.1 --new-base library/alpine:3.19.4 team/app:1
+
+The image's layers and diff IDs must start with those of the old base.
+The history entries of the old base are replaced by those of the new base;
+if either has no history, the result has none. The rest of the config,
+including environment and labels the old base set, is kept as it is.
+
+Base images that are manifest lists are resolved to the platform of the
+image. Every image of a manifest list or index is rebased and a new list
+pushed; attestations are dropped, as the digests they attest change. With
+--platform only that image is rebased, and pushed on its own, which needs
+a destination so as not to replace the list.
+`,
+		Annotations: mutating,
+		RunE:        rebase,
+	}
+
+	rebaseCmd.Flags().StringVar(&rebaseold, "old-base", "", "Base image the image was built on")
+	rebaseCmd.Flags().StringVar(&rebasenew, "new-base", "", "Base image to move the image onto")
+	rebaseCmd.Flags().StringVar(&rebaseplatform, "platform", "", "Platform to select from manifest lists (os/arch[/variant])")
+
+	RootCmd.AddCommand(rebaseCmd)
+}
+
+// baseImage is the manifest and config of a base image.
+type baseImage struct {
+	ref      *Reference
+	manifest *registry.Manifest
+	config   *registry.ImageConfig
+}
+
+// fetchBase fetches the base image at arg for platform plat.
+func fetchBase(cmd *cobra.Command, client *registry.Client, arg string, plat *registry.Platform) (*baseImage, error) {
+	ref, err := useReference(cmd, arg)
+	if err != nil {
+		return nil, err
+	}
+
+	m, _, err := client.ImageManifest(runctx, ref.Name, ref.Ref(), plat)
+	if err != nil {
+		return nil, fmt.Errorf("%s: manifest: %w", ref, err)
+	}
+
+	config, err := client.ImageConfig(runctx, ref.Name, m.Config.Digest)
+	if err != nil {
+		return nil, fmt.Errorf("%s: config: %w", ref, err)
+	}
+
+	if len(m.Layers) != len(config.RootFS.DiffIDs) {
+		return nil, fmt.Errorf("%s: %d layers but %d diff ids", ref, len(m.Layers), len(config.RootFS.DiffIDs))
+	}
+
+	return &baseImage{ref: ref, manifest: m, config: config}, nil
+}
+
+// baseHistory returns how many of the entries of history, which starts with
+// the history of base, belong to base.
+func baseHistory(history []registry.History, base *baseImage) (int, error) {
+	layers := len(base.config.RootFS.DiffIDs)
+
+	// the base's own entries, trailing empty ones included, when they fit
+	if n := len(base.config.History); n > 0 && n <= len(history) {
+		nonempty := 0
+		for _, h := range history[:n] {
+			if !h.EmptyLayer {
+				nonempty++
+			}
+		}
+		if nonempty == layers {
+			return n, nil
+		}
+	}
+
+	nonempty := 0
+	for i, h := range history {
+		if nonempty == layers {
+			return i, nil
+		}
+		if !h.EmptyLayer {
+			nonempty++
+		}
+	}
+	if nonempty == layers {
+		return len(history), nil
+	}
+
+	return 0, fmt.Errorf("history has %d layer entries, fewer than the %d base layers", nonempty, layers)
+}
+
+func rebase(cmd *cobra.Command, args []string) error {
+	if len(args) < 1 || len(args) > 2 || rebaseold == "" || rebasenew == "" {
+		return usageError(cmd)
+	}
+
+	src, err := useReference(cmd, args[0])
+	if err != nil {
+		return err
+	}
+
+	dst := src
+	if len(args) == 2 {
+		dst, err = useReference(cmd, args[1])
+		if err != nil {
+			return err
+		}
+	}
+	if dst.Tag == "" {
+		return fmt.Errorf("%s: destination needs a tag", dst)
+	}
+
+	plat, err := platform(rebaseplatform)
+	if err != nil {
+		return err
+	}
+
+	client, err := connect(cmd)
+	if err != nil {
+		return err
+	}
+
+	raw, err := client.RawManifest(runctx, src.Name, src.Ref())
+	if err != nil {
+		return fmt.Errorf("manifest: %w", err)
+	}
+
+	var idx registry.Index
+	if err := json.Unmarshal(raw.Data, &idx); err != nil {
+		return fmt.Errorf("manifest %s: %v", raw.Digest, err)
+	}
+
+	rb := &rebaser{cmd: cmd, client: client, from: src.Name, to: dst.Name}
+
+	if idx.Manifests == nil || plat != nil {
+		if idx.Manifests != nil && len(args) == 1 {
+			return &exitError{code: ExitUsage, err: fmt.Errorf("%s is a manifest list: give a destination for the %s image alone", src, plat)}
+		}
+
+		d, err := rb.image(src.String(), string(raw.Digest), plat, dst.Tag)
+		if err != nil {
+			return err
+		}
+
+		fmt.Printf("rebased %s onto %s as %s:%s@%s\n", src, rb.base, dst.Name, dst.Tag, d.Digest)
+
+		return nil
+	}
+
+	out := &registry.Index{
+		SchemaVersion: 2,
+		MediaType:     idx.MediaType,
+		Manifests:     []registry.Descriptor{},
+		Annotations:   idx.Annotations,
+	}
+	if out.MediaType == "" {
+		out.MediaType = registry.MediaTypeOCIIndex
+	}
+
+	for _, d := range idx.Manifests {
+		if err := interrupted(); err != nil {
+			return err
+		}
+
+		if d.Annotations["vnd.docker.reference.type"] == "attestation-manifest" {
+			fmt.Fprintf(os.Stderr, "dropping attestation %s\n", d.Digest)
+			continue
+		}
+
+		name := src.String() + " " + string(d.Digest)
+		if d.Platform != nil {
+			name = src.String() + " " + d.Platform.String()
+		}
+
+		nd, err := rb.image(name, string(d.Digest), d.Platform, "")
+		if err != nil {
+			return err
+		}
+
+		nd.Platform, nd.Annotations, nd.ArtifactType = d.Platform, d.Annotations, d.ArtifactType
+		if out.MediaType != registry.MediaTypeOCIIndex {
+			nd.Annotations, nd.ArtifactType = nil, ""
+		}
+
+		out.Manifests = append(out.Manifests, nd)
+	}
+
+	data, err := json.Marshal(out)
+	if err != nil {
+		return err
+	}
+
+	digest, err := client.PushManifest(runctx, dst.Name, dst.Tag, out.MediaType, data)
+	if err != nil {
+		return fmt.Errorf("push manifest: %w", err)
+	}
+
+	fmt.Printf("rebased %s onto %s as %s:%s@%s, %d images\n", src, rb.base, dst.Name, dst.Tag, digest, len(out.Manifests))
+
+	return nil
+}
+
+// rebaser moves images of repository from onto the new base, pushing them
+// to repository to.
+type rebaser struct {
+	cmd      *cobra.Command
+	client   *registry.Client
+	from, to string
+
+	// base is the new base of the last image rebased
+	base *Reference
+}
+
+// image rebases the image at ref, resolved to plat when it is a list, and
+// pushes it tagged with tag, or by digest when tag is empty. name is the
+// image in errors.
+func (rb *rebaser) image(name, ref string, plat *registry.Platform, tag string) (registry.Descriptor, error) {
+	client := rb.client
+	none := registry.Descriptor{}
+
+	m, _, err := client.ImageManifest(runctx, rb.from, ref, plat)
+	if err != nil {
+		return none, fmt.Errorf("manifest: %w", err)
+	}
+
+	oci := m.MediaType == "" || m.MediaType == registry.MediaTypeOCIManifest
+	mediaType := registry.MediaTypeManifest
+	if oci {
+		mediaType = registry.MediaTypeOCIManifest
+	}
+
+	config, err := imageConfig(runctx, client, rb.from, m.Config.Digest)
+	if err != nil {
+		return none, err
+	}
+
+	var imgplat registry.Platform
+	var rootfs registry.RootFS
+	var history []registry.History
+
+	for k, v := range map[string]interface{}{
+		"os":           &imgplat.OS,
+		"architecture": &imgplat.Architecture,
+		"variant":      &imgplat.Variant,
+		"rootfs":       &rootfs,
+		"history":      &history,
+	} {
+		if err := getField(config, k, v); err != nil {
+			return none, fmt.Errorf("config: %v", err)
+		}
+	}
+
+	if len(m.Layers) != len(rootfs.DiffIDs) {
+		return none, fmt.Errorf("%s: %d layers but %d diff ids", name, len(m.Layers), len(rootfs.DiffIDs))
+	}
+
+	old, err := fetchBase(rb.cmd, client, rebaseold, &imgplat)
+	if err != nil {
+		return none, err
+	}
+
+	base, err := fetchBase(rb.cmd, client, rebasenew, &imgplat)
+	if err != nil {
+		return none, err
+	}
+	rb.base = base.ref
+
+	for _, b := range []*baseImage{old, base} {
+		p := registry.Platform{OS: b.config.OS, Architecture: b.config.Architecture, Variant: b.config.Variant}
+		if !p.Matches(&imgplat) {
+			return none, fmt.Errorf("%s is %s, the image is %s", b.ref, p.String(), imgplat.String())
+		}
+	}
+
+	n := len(old.manifest.Layers)
+	if n > len(m.Layers) {
+		return none, fmt.Errorf("%s is not based on %s: it has fewer layers", name, old.ref)
+	}
+	for i, l := range old.manifest.Layers {
+		if m.Layers[i].Digest != l.Digest || rootfs.DiffIDs[i] != old.config.RootFS.DiffIDs[i] {
+			return none, fmt.Errorf("%s is not based on %s: layer %d is %s, not %s", name, old.ref, i, m.Layers[i].Digest, l.Digest)
+		}
+	}
+
+	layers := make([]registry.Descriptor, 0, len(base.manifest.Layers)+len(m.Layers)-n)
+	for _, l := range base.manifest.Layers {
+		if l.MediaType, err = layerType(l.MediaType, oci); err != nil {
+			return none, fmt.Errorf("%s: %v", base.ref, err)
+		}
+		if !oci {
+			l.Annotations = nil
+		}
+		if len(l.URLs) == 0 {
+			if err := client.CopyBlob(runctx, rb.to, base.ref.Name, l.Digest); err != nil {
+				return none, fmt.Errorf("copy layer %s: %w", l.Digest, err)
+			}
+		}
+		layers = append(layers, l)
+	}
+	for _, l := range m.Layers[n:] {
+		if len(l.URLs) == 0 {
+			if err := client.CopyBlob(runctx, rb.to, rb.from, l.Digest); err != nil {
+				return none, fmt.Errorf("copy layer %s: %w", l.Digest, err)
+			}
+		}
+		layers = append(layers, l)
+	}
+	m.Layers = layers
+
+	rootfs.DiffIDs = append(append([]registry.Digest{}, base.config.RootFS.DiffIDs...), rootfs.DiffIDs[n:]...)
+	if err := setField(config, "rootfs", rootfs, false); err != nil {
+		return none, err
+	}
+
+	// history must account for every layer, or not be kept
+	if history != nil && base.config.History != nil {
+		h, err := baseHistory(history, old)
+		if err != nil {
+			return none, fmt.Errorf("%s: %v", name, err)
+		}
+		history = append(append([]registry.History{}, base.config.History...), history[h:]...)
+	} else {
+		history = nil
+	}
+	if err := setField(config, "history", history, history == nil); err != nil {
+		return none, err
+	}
+
+	cdata, err := json.Marshal(config)
+	if err != nil {
+		return none, err
+	}
+
+	cdigest, err := client.PushBlob(runctx, rb.to, cdata)
+	if err != nil {
+		return none, fmt.Errorf("push config: %w", err)
+	}
+
+	m.MediaType = mediaType
+	m.Config.Digest, m.Config.Size = cdigest, int64(len(cdata))
+
+	data, err := json.Marshal(m)
+	if err != nil {
+		return none, err
+	}
+
+	if tag == "" {
+		tag = string(registry.FromBytes(data))
+	}
+
+	digest, err := client.PushManifest(runctx, rb.to, tag, mediaType, data)
+	if err != nil {
+		return none, fmt.Errorf("push manifest: %w", err)
+	}
+
+	return registry.Descriptor{MediaType: mediaType, Size: int64(len(data)), Digest: digest}, nil
+}
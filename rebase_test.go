@@ -0,0 +1,221 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/dbulkow/registry_cmd/registry"
+	"github.com/dbulkow/registry_cmd/registry/registrytest"
+)
+
+var patchedLayer = registrytest.Layer(
+	registrytest.File{Name: "etc/", Type: '5'},
+	registrytest.File{Name: "etc/os-release", Body: "ID=alpine\nVERSION_ID=3.19.4\n"},
+	registrytest.File{Name: "etc/motd", Body: "welcome\n"},
+)
+
+// seedBases pushes base/alpine:3.19.1, the base of the seed images, and
+// base/alpine:3.19.4, which has two layers and a trailing empty history
+// entry.
+func seedBases(reg *registrytest.Registry) {
+	reg.PushImage("base/alpine", "3.19.1", registrytest.Image{
+		Created: "2024-01-01T00:00:00Z",
+		Layers:  [][]byte{baseLayer},
+	})
+	reg.PushImage("base/alpine", "3.19.4", registrytest.Image{
+		Created: "2024-09-01T00:00:00Z",
+		Layers:  [][]byte{patchedLayer, mixLayer},
+		History: []registry.History{
+			{CreatedBy: "ADD rootfs"},
+			{CreatedBy: "RUN apk upgrade"},
+			{CreatedBy: "CMD [\"/bin/sh\"]", EmptyLayer: true},
+		},
+	})
+}
+
+func TestRebase(t *testing.T) {
+	e := seeded(t)
+	seedBases(e.reg)
+
+	out := e.ok("rebase", "--old-base", "base/alpine:3.19.1", "--new-base", "base/alpine:3.19.4", "app/web:1.0", "app/web:1.0-patched")
+	contains(t, out, "rebased app/web:1.0 onto base/alpine:3.19.4 as app/web:1.0-patched@sha256:")
+
+	orig, oconfig := pushedConfig(t, e, "app/web", "1.0")
+	newbase, _ := pushedConfig(t, e, "base/alpine", "3.19.4")
+	m, config := pushedConfig(t, e, "app/web", "1.0-patched")
+
+	want := append(append([]registry.Descriptor{}, newbase.Layers...), orig.Layers[1])
+	if !reflect.DeepEqual(m.Layers, want) {
+		t.Errorf("layers = %+v\nwant %+v", m.Layers, want)
+	}
+
+	diffIDs := config["rootfs"].(map[string]interface{})["diff_ids"].([]interface{})
+	odiffIDs := oconfig["rootfs"].(map[string]interface{})["diff_ids"].([]interface{})
+	if len(diffIDs) != 3 || diffIDs[2] != odiffIDs[1] {
+		t.Errorf("diff ids = %v", diffIDs)
+	}
+
+	var createdBy []interface{}
+	for _, h := range config["history"].([]interface{}) {
+		createdBy = append(createdBy, h.(map[string]interface{})["created_by"])
+	}
+	if !reflect.DeepEqual(createdBy, []interface{}{"ADD rootfs", "RUN apk upgrade", "CMD [\"/bin/sh\"]", "layer 1"}) {
+		t.Errorf("history = %v", createdBy)
+	}
+
+	if !reflect.DeepEqual(config["config"], oconfig["config"]) {
+		t.Errorf("config = %v", config["config"])
+	}
+
+	contains(t, e.ok("cat", "app/web:1.0-patched", "/etc/os-release"), "3.19.4")
+	contains(t, e.ok("cat", "app/web:1.0-patched", "/srv/index.html"), "v1")
+
+	// and back again, in place, through the trailing empty entry
+	e.ok("rebase", "--old-base", "base/alpine:3.19.4", "--new-base", "base/alpine:3.19.1", "app/web:1.0-patched")
+	m, _ = pushedConfig(t, e, "app/web", "1.0-patched")
+	if !reflect.DeepEqual(m.Layers, orig.Layers) {
+		t.Errorf("layers = %+v\nwant %+v", m.Layers, orig.Layers)
+	}
+}
+
+func TestRebaseOCI(t *testing.T) {
+	e := seeded(t)
+	seedBases(e.reg)
+
+	e.ok("rebase", "--old-base", "base/alpine:3.19.1", "--new-base", "base/alpine:3.19.4", "app/api:latest", "other/api:1")
+
+	m, _ := pushedConfig(t, e, "other/api", "1")
+	if m.MediaType != registry.MediaTypeOCIManifest || len(m.Layers) != 3 {
+		t.Fatalf("manifest = %+v", m)
+	}
+	for _, l := range m.Layers {
+		if l.MediaType != registry.MediaTypeOCILayer {
+			t.Errorf("layer media type %s", l.MediaType)
+		}
+	}
+
+	contains(t, e.ok("ls-files", "other/api:1"), "/app/api", "/etc/os-release")
+}
+
+func TestRebaseNotBased(t *testing.T) {
+	e := seeded(t)
+	seedBases(e.reg)
+
+	r := e.fails(ExitFailure, "rebase", "--old-base", "base/alpine:3.19.4", "--new-base", "base/alpine:3.19.1", "app/web:1.0", "app/web:x")
+	contains(t, r.stderr, "app/web:1.0 is not based on base/alpine:3.19.4")
+
+	e.fails(ExitFailure, "rebase", "--old-base", "app/web:2.0", "--new-base", "base/alpine:3.19.4", "app/web:1.0", "app/web:x")
+
+	if _, _, ok := e.reg.Manifest("app/web", "x"); ok {
+		t.Error("pushed after failing")
+	}
+}
+
+func TestRebaseUsage(t *testing.T) {
+	e := seeded(t)
+	seedBases(e.reg)
+
+	e.fails(ExitUsage, "rebase", "app/web:1.0")
+	e.fails(ExitUsage, "rebase", "--old-base", "base/alpine:3.19.1", "app/web:1.0")
+	e.fails(ExitUsage, "rebase", "--old-base", "base/alpine:3.19.1", "--new-base", "base/alpine:3.19.4")
+	e.fails(ExitNotFound, "rebase", "--old-base", "base/alpine:9", "--new-base", "base/alpine:3.19.4", "app/web:1.0")
+}
+
+// pushMultiBase pushes base/multi:old and base/multi:new, and app/multi:1
+// built on the old base, all OCI indexes of linux/amd64 and linux/arm64.
+// The app index has an attestation too.
+func pushMultiBase(reg *registrytest.Registry) {
+	arm := registry.Platform{OS: "linux", Architecture: "arm64"}
+	file := func(name, body string) []byte {
+		return registrytest.Layer(registrytest.File{Name: name, Body: body})
+	}
+
+	for _, tag := range []string{"old", "new"} {
+		amd := reg.PushImage("base/multi", "", registrytest.Image{Layers: [][]byte{file("base", "amd64 "+tag+"\n")}})
+		armd := reg.PushImage("base/multi", "", registrytest.Image{Platform: arm, Layers: [][]byte{file("base", "arm64 "+tag+"\n")}})
+		reg.PushIndex("base/multi", tag, registry.MediaTypeOCIIndex, amd, armd)
+	}
+
+	amd := reg.PushImage("app/multi", "", registrytest.Image{
+		MediaType: registry.MediaTypeOCIManifest,
+		Layers:    [][]byte{file("base", "amd64 old\n"), file("app", "amd64 app\n")},
+	})
+	armd := reg.PushImage("app/multi", "", registrytest.Image{
+		MediaType: registry.MediaTypeOCIManifest,
+		Platform:  arm,
+		Layers:    [][]byte{file("base", "arm64 old\n"), file("app", "arm64 app\n")},
+	})
+
+	att := reg.PushImage("app/multi", "", registrytest.Image{MediaType: registry.MediaTypeOCIManifest})
+	att.Platform = &registry.Platform{OS: "unknown", Architecture: "unknown"}
+	att.Annotations = map[string]string{"vnd.docker.reference.type": "attestation-manifest"}
+
+	reg.PushIndex("app/multi", "1", registry.MediaTypeOCIIndex, amd, armd, att)
+}
+
+func TestRebaseIndex(t *testing.T) {
+	e := newEnv(t)
+	pushMultiBase(e.reg)
+
+	_, data, _ := e.reg.Manifest("app/multi", "1")
+	var orig registry.Index
+	json.Unmarshal(data, &orig)
+	att := orig.Manifests[2]
+
+	// in place: every platform is rebased and the list kept
+	r := e.run("rebase", "--old-base", "base/multi:old", "--new-base", "base/multi:new", "app/multi:1")
+	if r.code != ExitOK {
+		t.Fatalf("exit %d: %s", r.code, r.stderr)
+	}
+	contains(t, r.stdout, "rebased app/multi:1 onto base/multi:new as app/multi:1@sha256:", "2 images")
+	contains(t, r.stderr, "dropping attestation "+string(att.Digest))
+
+	mediaType, data, _ := e.reg.Manifest("app/multi", "1")
+	var idx registry.Index
+	if err := json.Unmarshal(data, &idx); err != nil {
+		t.Fatal(err)
+	}
+	if mediaType != registry.MediaTypeOCIIndex || len(idx.Manifests) != 2 {
+		t.Fatalf("%s %+v", mediaType, idx)
+	}
+
+	for i, arch := range []string{"amd64", "arm64"} {
+		if d := idx.Manifests[i]; d.Platform == nil || d.Platform.Architecture != arch || d.Digest == orig.Manifests[i].Digest {
+			t.Errorf("entry %d = %+v", i, d)
+		}
+
+		plat := "linux/" + arch
+		if out := e.ok("cat", "--platform", plat, "app/multi:1", "/base"); out != arch+" new\n" {
+			t.Errorf("%s base = %q", plat, out)
+		}
+		if out := e.ok("cat", "--platform", plat, "app/multi:1", "/app"); out != arch+" app\n" {
+			t.Errorf("%s app = %q", plat, out)
+		}
+	}
+}
+
+func TestRebaseIndexPlatform(t *testing.T) {
+	e := newEnv(t)
+	pushMultiBase(e.reg)
+
+	_, orig, _ := e.reg.Manifest("app/multi", "1")
+
+	// one platform of a list would replace the list
+	r := e.fails(ExitUsage, "rebase", "--platform", "linux/arm64", "--old-base", "base/multi:old", "--new-base", "base/multi:new", "app/multi:1")
+	contains(t, r.stderr, "manifest list")
+
+	if _, data, _ := e.reg.Manifest("app/multi", "1"); string(data) != string(orig) {
+		t.Error("list replaced")
+	}
+
+	e.ok("rebase", "--platform", "linux/arm64", "--old-base", "base/multi:old", "--new-base", "base/multi:new", "app/multi:1", "app/multi:1-arm64")
+
+	mediaType, _, _ := e.reg.Manifest("app/multi", "1-arm64")
+	if mediaType != registry.MediaTypeOCIManifest {
+		t.Errorf("media type %s", mediaType)
+	}
+	if out := e.ok("cat", "app/multi:1-arm64", "/base"); out != "arm64 new\n" {
+		t.Errorf("base = %q", out)
+	}
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/dbulkow/registry_cmd/registry"
+)
+
+// reclaimable walks every tagged manifest in the registry and totals the
+// blobs referenced only by manifests in remove, keyed by repo@digest. These
+// are the blobs garbage collection frees once the manifests are deleted.
+// Untagged manifests cannot be found this way, so blobs they alone hold
+// are not counted as kept.
+func reclaimable(ctx context.Context, client *registry.Client, remove map[string]bool) (uint64, int, error) {
+	images, err := client.Catalog(ctx)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	keep := make(map[registry.Digest]bool)
+	candidates := make(map[registry.Digest]string) // blob -> repository holding it
+	seen := make(map[string]bool)
+
+	for _, name := range images {
+		tags, err := client.Tags(ctx, name)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "tags %s: %v\n", name, err)
+			continue
+		}
+
+		for _, tag := range tags {
+			if err := interrupted(); err != nil {
+				return 0, 0, err
+			}
+
+			digest, blobs, err := client.Referenced(ctx, name, tag)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "manifest %s:%s: %v (estimate may be high)\n", name, tag, err)
+				continue
+			}
+
+			key := name + "@" + string(digest)
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+
+			for _, b := range blobs {
+				if remove[key] {
+					candidates[b] = name
+				} else {
+					keep[b] = true
+				}
+			}
+		}
+	}
+
+	cache := make(blobcache)
+
+	var total uint64
+	var count int
+
+	for b, name := range candidates {
+		if keep[b] {
+			continue
+		}
+
+		sz, err := cache.size(ctx, client, name, b)
+		if err != nil {
+			return 0, 0, fmt.Errorf("blobsize %s: %v", b, err)
+		}
+
+		total += sz
+		count++
+	}
+
+	return total, count, nil
+}
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+
+	"github.com/dbulkow/registry_cmd/registry"
+	"github.com/dustin/go-humanize"
+	"github.com/klauspost/compress/zstd"
+	"github.com/spf13/cobra"
+)
+
+var recompressto string
+var recompresslevel int
+
+func init() {
+	recompressCmd := &cobra.Command{
+		Use:   "recompress --to zstd|gzip <image:tag> [<image:tag>]",
+		Short: "Recompress the layers of an image",
+		Long: `Recompress the layers of an image with zstd or gzip and push the result,
+by default under the source tag suffixed with -zstd or -gzip.
+
+Each layer is downloaded, recompressed and uploaded as a new blob. The
+uncompressed content is unchanged, so the config and its diff IDs are
+reused as they are. Docker manifests have no zstd layer type: images
+recompressed with zstd become OCI images, and manifest lists OCI indexes.
+With gzip, manifests keep their format. Every image of a manifest list or
+index is recompressed; attestations in an index are dropped, as the
+digests they attest change.
+
+Levels are 1 to 9 for gzip and 1 to 22 for zstd. The zstd encoder has
+four levels, which the zstd levels map onto: 1 and 2 are fastest, 3 to 5
+the default, 6 to 9 better and 10 and above best compression.
+
+Layers are held in memory while they are recompressed. Their digests are
+checked as they are read, so a corrupt layer fails the command rather than
+being pushed as a new blob.
+`,
+		Annotations: mutating,
+		RunE:        recompress,
+	}
+
+	recompressCmd.Flags().StringVar(&recompressto, "to", "", "Compression to use (zstd, gzip)")
+	recompressCmd.Flags().IntVar(&recompresslevel, "level", 0, "Compression level, the default for the compression when 0")
+
+	RootCmd.AddCommand(recompressCmd)
+}
+
+// recompressor rewrites layers with one compression, once per layer.
+type recompressor struct {
+	client   *registry.Client
+	from, to string
+	zstd     bool
+	level    int
+	done     map[registry.Digest]registry.Descriptor
+	before   int64
+	after    int64
+}
+
+// compress writes the tar stream r to w with the chosen compression.
+func (rc *recompressor) compress(w io.Writer, r io.Reader) error {
+	var zw io.WriteCloser
+	var err error
+
+	if rc.zstd {
+		level := zstd.SpeedDefault
+		if rc.level > 0 {
+			level = zstd.EncoderLevelFromZstd(rc.level)
+		}
+		zw, err = zstd.NewWriter(w, zstd.WithEncoderLevel(level), zstd.WithEncoderConcurrency(1))
+	} else {
+		level := gzip.DefaultCompression
+		if rc.level > 0 {
+			level = rc.level
+		}
+		zw, err = gzip.NewWriterLevel(w, level)
+	}
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(zw, r); err != nil {
+		zw.Close()
+		return err
+	}
+
+	return zw.Close()
+}
+
+// layer recompresses a layer for a manifest that is OCI when oci is set.
+func (rc *recompressor) layer(l registry.Descriptor, oci bool) (registry.Descriptor, error) {
+	mediaType := registry.MediaTypeDockerLayer
+	switch {
+	case rc.zstd:
+		mediaType = registry.MediaTypeOCILayerZstd
+	case oci:
+		mediaType = registry.MediaTypeOCILayer
+	}
+
+	if d, ok := rc.done[l.Digest]; ok {
+		d.MediaType = mediaType
+		return d, nil
+	}
+
+	if err := interrupted(); err != nil {
+		return l, err
+	}
+
+	blob, err := rc.client.OpenBlob(runctx, rc.from, l.Digest)
+	if err != nil {
+		return l, fmt.Errorf("layer %s: %w", l.Digest, err)
+	}
+	defer blob.Close()
+
+	r, err := decompress(blob)
+	if err != nil {
+		return l, fmt.Errorf("layer %s: %v", l.Digest, err)
+	}
+	defer r.Close()
+
+	var buf bytes.Buffer
+	if err := rc.compress(&buf, r); err != nil {
+		return l, fmt.Errorf("layer %s: %w", l.Digest, err)
+	}
+
+	// to the end of the blob, so that its digest is checked
+	if _, err := io.Copy(ioutil.Discard, blob); err != nil {
+		return l, fmt.Errorf("layer %s: %w", l.Digest, err)
+	}
+
+	digest, err := rc.client.PushBlob(runctx, rc.to, buf.Bytes())
+	if err != nil {
+		return l, fmt.Errorf("push layer: %w", err)
+	}
+
+	d := registry.Descriptor{
+		MediaType: mediaType,
+		Size:      int64(buf.Len()),
+		Digest:    digest,
+	}
+	if oci {
+		d.Annotations = l.Annotations
+	}
+
+	rc.done[l.Digest] = d
+	rc.before += l.Size
+	rc.after += d.Size
+
+	return d, nil
+}
+
+func recompress(cmd *cobra.Command, args []string) error {
+	if len(args) < 1 || len(args) > 2 {
+		return usageError(cmd)
+	}
+
+	maxlevel := 9
+	switch recompressto {
+	case "gzip":
+	case "zstd":
+		maxlevel = 22
+	default:
+		return usageError(cmd)
+	}
+
+	if recompresslevel < 0 || recompresslevel > maxlevel {
+		return usageError(cmd)
+	}
+
+	refs := make([]*Reference, 0, 2)
+	for _, arg := range args {
+		r, err := useReference(cmd, arg)
+		if err != nil {
+			return err
+		}
+		refs = append(refs, r)
+	}
+
+	src := refs[0]
+
+	dst := &Reference{Name: src.Name, Tag: src.Tag + "-" + recompressto}
+	switch {
+	case len(refs) == 2:
+		dst = refs[1]
+	case src.Tag == "":
+		return fmt.Errorf("%s: give a destination for an image named by digest", src)
+	}
+	if dst.Tag == "" {
+		return fmt.Errorf("%s: destination needs a tag", dst)
+	}
+
+	client, err := connect(cmd)
+	if err != nil {
+		return err
+	}
+
+	raw, err := client.RawManifest(runctx, src.Name, src.Ref())
+	if err != nil {
+		return fmt.Errorf("manifest: %w", err)
+	}
+
+	rc := &recompressor{
+		client: client,
+		from:   src.Name,
+		to:     dst.Name,
+		zstd:   recompressto == "zstd",
+		level:  recompresslevel,
+		done:   make(map[registry.Digest]registry.Descriptor),
+	}
+
+	c := &converter{
+		client: client,
+		from:   src.Name,
+		to:     dst.Name,
+		oci:    rc.zstd,
+		keep:   !rc.zstd,
+		layer:  rc.layer,
+	}
+
+	mediaType, data, err := c.convert(raw)
+	if err != nil {
+		return err
+	}
+
+	digest, err := client.PushManifest(runctx, dst.Name, dst.Tag, mediaType, data)
+	if err != nil {
+		return fmt.Errorf("push manifest: %w", err)
+	}
+
+	fmt.Printf("recompressed %s to %s:%s@%s, %d layers %s -> %s\n", src, dst.Name, dst.Tag, digest,
+		len(rc.done), humanize.Bytes(uint64(rc.before)), humanize.Bytes(uint64(rc.after)))
+
+	return nil
+}
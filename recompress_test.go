@@ -0,0 +1,111 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/dbulkow/registry_cmd/registry"
+)
+
+func TestRecompress(t *testing.T) {
+	e := seeded(t)
+
+	_, data, _ := e.reg.Manifest("app/web", "2.0")
+	var orig registry.Manifest
+	json.Unmarshal(data, &orig)
+
+	out := e.ok("recompress", "--to", "zstd", "--level", "19", "app/web:2.0")
+	contains(t, out, "recompressed app/web:2.0 to app/web:2.0-zstd@sha256:", "2 layers")
+
+	mediaType, data, _ := e.reg.Manifest("app/web", "2.0-zstd")
+	if mediaType != registry.MediaTypeOCIManifest {
+		t.Fatalf("media type %s", mediaType)
+	}
+
+	var m registry.Manifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		t.Fatal(err)
+	}
+
+	// same config, so the same diff IDs
+	if m.Config.Digest != orig.Config.Digest || m.Config.MediaType != registry.MediaTypeOCIConfig {
+		t.Errorf("config %+v, want digest %s", m.Config, orig.Config.Digest)
+	}
+
+	for _, l := range m.Layers {
+		blob, _ := e.reg.Blob("app/web", l.Digest)
+		if l.MediaType != registry.MediaTypeOCILayerZstd || !bytes.HasPrefix(blob, zstdMagic) {
+			t.Errorf("layer %s %s is not zstd", l.MediaType, l.Digest)
+		}
+	}
+
+	// the layers read back as before
+	if out := e.ok("cat", "app/web:2.0-zstd", "/srv/index.html"); out != "v2\n" {
+		t.Errorf("cat = %q", out)
+	}
+	lacks(t, e.ok("ls-files", "app/web:2.0-zstd"), "/etc/motd")
+
+	// and recompress to the gzip layers they started as
+	e.ok("recompress", "--to", "gzip", "app/web:2.0-zstd", "app/web:back")
+
+	mediaType, data, _ = e.reg.Manifest("app/web", "back")
+	if mediaType != registry.MediaTypeOCIManifest {
+		t.Errorf("gzip recompression changed the format to %s", mediaType)
+	}
+
+	json.Unmarshal(data, &m)
+	for i, l := range m.Layers {
+		if l.MediaType != registry.MediaTypeOCILayer || l.Digest != orig.Layers[i].Digest {
+			t.Errorf("layer %d = %s %s, want %s", i, l.MediaType, l.Digest, orig.Layers[i].Digest)
+		}
+	}
+}
+
+func TestRecompressIndex(t *testing.T) {
+	e := seeded(t)
+
+	e.ok("recompress", "--to", "zstd", "multi/arch:1", "multi/zstd:1")
+
+	mediaType, data, _ := e.reg.Manifest("multi/zstd", "1")
+	if mediaType != registry.MediaTypeOCIIndex {
+		t.Fatalf("media type %s", mediaType)
+	}
+
+	var idx registry.Index
+	json.Unmarshal(data, &idx)
+	if len(idx.Manifests) != 2 {
+		t.Errorf("index %s", data)
+	}
+
+	contains(t, e.ok("cat", "--platform", "linux/arm64", "multi/zstd:1", "/arch"), "arm64")
+
+	// a manifest list stays one with gzip
+	e.ok("recompress", "--to", "gzip", "--level", "9", "multi/arch:1")
+	if mediaType, _, _ := e.reg.Manifest("multi/arch", "1-gzip"); mediaType != registry.MediaTypeManifestList {
+		t.Errorf("media type %s", mediaType)
+	}
+}
+
+func TestRecompressUsage(t *testing.T) {
+	e := seeded(t)
+
+	e.fails(ExitUsage, "recompress", "app/web:1.0")
+	e.fails(ExitUsage, "recompress", "--to", "brotli", "app/web:1.0")
+	e.fails(ExitUsage, "recompress", "--to", "gzip", "--level", "12", "app/web:1.0")
+	e.fails(ExitNotFound, "recompress", "--to", "zstd", "app/web:9")
+}
+
+func TestRecompressCorruptLayer(t *testing.T) {
+	e := seeded(t)
+
+	// a valid layer, but not the one the manifest names
+	e.reg.TamperBlob(registry.FromBytes(web2Layer), web1Layer)
+
+	r := e.fails(ExitFailure, "recompress", "--to", "zstd", "app/web:2.0")
+	contains(t, r.stderr, "digest mismatch")
+
+	if _, _, ok := e.reg.Manifest("app/web", "2.0-zstd"); ok {
+		t.Error("pushed the corrupt layer")
+	}
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	neturl "net/url"
+	"regexp"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+// Reference is a parsed image reference such as
+// registry.example.com:5000/team/app:1.2@sha256:...
+type Reference struct {
+	Domain string // registry host[:port], empty when not named
+	Name   string
+	Tag    string
+	Digest string
+}
+
+// DockerHub is the domain Docker Hub references are normalized to.
+const DockerHub = "docker.io"
+
+var (
+	nameComponent = regexp.MustCompile(`^[a-z0-9]+(?:(?:[._]|__|-+)[a-z0-9]+)*$`)
+	tagPattern    = regexp.MustCompile(`^[A-Za-z0-9_][A-Za-z0-9_.-]{0,127}$`)
+	digestPattern = regexp.MustCompile(`^(sha256:[a-f0-9]{64}|sha512:[a-f0-9]{128})$`)
+)
+
+// splitDomain splits the registry host off a reference. As with docker,
+// the first path component is a host when it contains a dot or a port,
+// or is localhost.
+func splitDomain(s string) (string, string) {
+	i := strings.IndexByte(s, '/')
+	if i < 0 {
+		return "", s
+	}
+
+	first := s[:i]
+	if !strings.ContainsAny(first, ".:") && first != "localhost" {
+		return "", s
+	}
+
+	return canonicalDomain(first), s[i+1:]
+}
+
+// canonicalDomain maps the aliases of Docker Hub to DockerHub.
+func canonicalDomain(host string) string {
+	switch host {
+	case "docker.io", "index.docker.io", "registry-1.docker.io", "registry.hub.docker.com":
+		return DockerHub
+	}
+	return host
+}
+
+// parseReference parses [host[:port]/]name[:tag][@digest]. The tag
+// defaults to latest when neither tag nor digest is given, and single
+// component Docker Hub names gain the library/ prefix.
+func parseReference(s string) (*Reference, error) {
+	r := &Reference{}
+
+	rest := s
+	if i := strings.LastIndexByte(rest, '@'); i >= 0 {
+		r.Digest = rest[i+1:]
+		rest = rest[:i]
+
+		if !digestPattern.MatchString(r.Digest) {
+			return nil, fmt.Errorf("%s: invalid digest %q", s, r.Digest)
+		}
+	}
+
+	r.Domain, rest = splitDomain(rest)
+
+	if i := strings.LastIndexByte(rest, ':'); i >= 0 && !strings.Contains(rest[i:], "/") {
+		r.Tag = rest[i+1:]
+		rest = rest[:i]
+
+		if !tagPattern.MatchString(r.Tag) {
+			return nil, fmt.Errorf("%s: invalid tag %q", s, r.Tag)
+		}
+	}
+
+	if rest == "" {
+		return nil, fmt.Errorf("%s: missing repository name", s)
+	}
+
+	for _, c := range strings.Split(rest, "/") {
+		if !nameComponent.MatchString(c) {
+			return nil, fmt.Errorf("%s: invalid repository name %q", s, rest)
+		}
+	}
+	r.Name = rest
+
+	if r.Domain == DockerHub && !strings.Contains(r.Name, "/") {
+		r.Name = "library/" + r.Name
+	}
+
+	if r.Tag == "" && r.Digest == "" {
+		r.Tag = "latest"
+	}
+
+	return r, nil
+}
+
+// Ref returns the digest when the reference is pinned, else the tag.
+func (r *Reference) Ref() string {
+	if r.Digest != "" {
+		return r.Digest
+	}
+	return r.Tag
+}
+
+func (r *Reference) String() string {
+	s := r.Name
+	if r.Domain != "" {
+		s = r.Domain + "/" + s
+	}
+	if r.Tag != "" {
+		s += ":" + r.Tag
+	}
+	if r.Digest != "" {
+		s += "@" + r.Digest
+	}
+	return s
+}
+
+// registryURL returns the base URL of a registry domain. Loopback
+// registries are assumed to serve plain HTTP.
+func registryURL(domain string) string {
+	if domain == DockerHub {
+		return "https://registry-1.docker.io"
+	}
+
+	host := domain
+	if h, _, err := net.SplitHostPort(domain); err == nil {
+		host = h
+	}
+
+	if ip := net.ParseIP(host); host == "localhost" || (ip != nil && ip.IsLoopback()) {
+		return "http://" + domain
+	}
+
+	return "https://" + domain
+}
+
+// urlDomain returns the canonical domain of a registry base URL.
+func urlDomain(url string) string {
+	u, err := neturl.Parse(url)
+	if err != nil {
+		return ""
+	}
+	return canonicalDomain(u.Host)
+}
+
+// refdomain is the registry named by the references of this run.
+var refdomain string
+
+// useDomain points the run at the registry a reference names, replacing
+// --registry and the selected context unless they already refer to it.
+// A context whose registry matches is used for its credentials.
+func useDomain(cmd *cobra.Command, domain string) error {
+	if domain == "" {
+		return nil
+	}
+
+	if refdomain != "" && refdomain != domain {
+		return fmt.Errorf("references name different registries %s and %s", refdomain, domain)
+	}
+	refdomain = domain
+
+	flag := cmd.Flag("registry")
+	if urlDomain(flag.Value.String()) == domain {
+		return nil
+	}
+
+	ctx, err := contextFor(domain)
+	if err != nil {
+		return err
+	}
+
+	current = ctx
+	if ctx != nil {
+		return flag.Value.Set(ctx.Registry)
+	}
+
+	return flag.Value.Set(registryURL(domain))
+}
+
+// useReference parses an image argument and selects its registry.
+func useReference(cmd *cobra.Command, arg string) (*Reference, error) {
+	r, err := parseReference(arg)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := useDomain(cmd, r.Domain); err != nil {
+		return nil, err
+	}
+
+	if r.Domain == "" && urlDomain(cmd.Flag("registry").Value.String()) == DockerHub && !strings.Contains(r.Name, "/") {
+		r.Name = "library/" + r.Name
+	}
+
+	return r, nil
+}
+
+// useRepository selects the registry named by a repository glob and
+// returns the glob without it.
+func useRepository(cmd *cobra.Command, glob string) (string, error) {
+	domain, rest := splitDomain(glob)
+	if err := useDomain(cmd, domain); err != nil {
+		return "", err
+	}
+	return rest, nil
+}
@@ -0,0 +1,72 @@
+package main
+
+import "testing"
+
+func TestParseReference(t *testing.T) {
+	digest := "sha256:" + "0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"
+
+	tests := []struct {
+		in   string
+		want Reference
+	}{
+		{"app", Reference{Name: "app", Tag: "latest"}},
+		{"team/app:1.2", Reference{Name: "team/app", Tag: "1.2"}},
+		{"app@" + digest, Reference{Name: "app", Digest: digest}},
+		{"app:1@" + digest, Reference{Name: "app", Tag: "1", Digest: digest}},
+		{"localhost/app", Reference{Domain: "localhost", Name: "app", Tag: "latest"}},
+		{"localhost:5000/team/app:v1", Reference{Domain: "localhost:5000", Name: "team/app", Tag: "v1"}},
+		{"registry.example.com/app", Reference{Domain: "registry.example.com", Name: "app", Tag: "latest"}},
+		{"docker.io/alpine", Reference{Domain: DockerHub, Name: "library/alpine", Tag: "latest"}},
+		{"index.docker.io/user/app:x", Reference{Domain: DockerHub, Name: "user/app", Tag: "x"}},
+	}
+
+	for _, tt := range tests {
+		r, err := parseReference(tt.in)
+		if err != nil {
+			t.Errorf("parseReference(%q): %v", tt.in, err)
+			continue
+		}
+		if *r != tt.want {
+			t.Errorf("parseReference(%q) = %+v, want %+v", tt.in, *r, tt.want)
+		}
+	}
+
+	for _, in := range []string{"", ":tag", "App", "app:", "app:-x", "app@sha256:abc", "a//b", "host:5000/"} {
+		if _, err := parseReference(in); err == nil {
+			t.Errorf("parseReference(%q) succeeded", in)
+		}
+	}
+}
+
+func TestReferenceString(t *testing.T) {
+	for _, s := range []string{"app:latest", "localhost:5000/team/app:v1", "docker.io/library/alpine:3"} {
+		r, err := parseReference(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if r.String() != s {
+			t.Errorf("%q round trips to %q", s, r.String())
+		}
+	}
+}
+
+func TestGlob(t *testing.T) {
+	tests := []struct {
+		pattern, subj string
+		want          bool
+	}{
+		{"*", "app/web", true},
+		{"app/*", "app/web", true},
+		{"app/*", "tools/app", false},
+		{"*/web", "app/web", true},
+		{"a*w*b", "app/web", true},
+		{"app", "app/web", false},
+		{"", "", true},
+	}
+
+	for _, tt := range tests {
+		if got := Glob(tt.pattern, tt.subj); got != tt.want {
+			t.Errorf("Glob(%q, %q) = %v, want %v", tt.pattern, tt.subj, got, tt.want)
+		}
+	}
+}
@@ -1,18 +1,119 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
 
 	"github.com/spf13/cobra"
 )
 
-func check_registry(cmd *cobra.Command, args []string) {
-	regvar := cmd.Flag("registry").Value.String()
-	if regvar == "" {
-		fmt.Fprintf(os.Stderr, "please set registry location")
-		os.Exit(1)
+func check_registry(cmd *cobra.Command, args []string) error {
+	ctx, err := selectContext()
+	if err != nil {
+		return err
 	}
+	current = ctx
+
+	flag := cmd.Flag("registry")
+	if current != nil && !flag.Changed && os.Getenv("REGISTRY") == "" {
+		flag.Value.Set(current.Registry)
+	}
+
+	// credentials and certificates of a context are only for its registry
+	if domain := urlDomain(flag.Value.String()); current != nil && urlDomain(current.Registry) != domain {
+		if current, err = contextFor(domain); err != nil {
+			return err
+		}
+	}
+
+	if !nocache {
+		ttl := cachettl
+		if cmd.Annotations[mutatingKey] != "" {
+			ttl = 0
+		}
+		cache = openCache(ttl)
+	}
+
+	if overalltimeout > 0 {
+		deadline := time.Now().Add(overalltimeout)
+
+		var cancelrun, cancelwork context.CancelFunc
+		runctx, cancelrun = context.WithDeadline(runctx, deadline)
+		workctx, cancelwork = context.WithDeadline(workctx, deadline)
+		runcancel = func() { cancelrun(); cancelwork() }
+	}
+
+	return nil
+}
+
+var reqtimeout time.Duration
+var overalltimeout time.Duration
+var retries int
+var ratelimit float64
+
+// interrupt is done once SIGINT or SIGTERM is received.
+var interrupt = context.Background()
+
+// runctx bounds the requests of the command. It is cancelled on interrupt
+// and by --timeout when set.
+var runctx = context.Background()
+
+// workctx bounds requests that must complete once started, such as one
+// delete of a bulk delete. Only --timeout cancels it.
+var workctx = context.Background()
+
+var runcancel context.CancelFunc = func() {}
+
+// interrupted returns an error once the command has been interrupted, for
+// bulk commands to stop between items.
+func interrupted() error {
+	if interrupt.Err() != nil {
+		return &exitError{code: ExitInterrupted, err: errors.New("interrupted")}
+	}
+	return nil
+}
+
+var concurrency int
+
+// workers returns the number of concurrent requests to make.
+func workers() int {
+	if concurrency > 0 {
+		return concurrency
+	}
+	if current != nil && current.Concurrency > 0 {
+		return current.Concurrency
+	}
+	return 1
+}
+
+// parallel calls fn for 0 through count-1 using n goroutines. Once
+// interrupted, no more calls are started.
+func parallel(n, count int, fn func(int)) {
+	next := make(chan int)
+
+	var wg sync.WaitGroup
+	for w := 0; w < n; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				fn(i)
+			}
+		}()
+	}
+
+	for i := 0; i < count && interrupt.Err() == nil; i++ {
+		next <- i
+	}
+	close(next)
+
+	wg.Wait()
 }
 
 var RootCmd = &cobra.Command{
@@ -20,19 +121,51 @@ var RootCmd = &cobra.Command{
 	Short: "Docker Registry CLI",
 	Long: `Docker Registry command interface for maintenance.
 
+Images may be given as full references, such as
+registry.example.com:5000/team/app:1.2 or nginx@sha256:<hex>. A registry
+named in a reference overrides --registry, and docker.io references are
+fetched from Docker Hub with the library/ prefix added to official images.
+
 Environment:
 
 REGISTRY            Base URL for registry
+REGCMD_CONTEXT      Named registry context from the config file
 REGISTRY_TLS_KEYS   Directory containing TLS keys
 REGISTRY_TLS_VERIFY Enable TLS verification
+
+Exit status:
+
+0  success
+1  other error
+2  invalid arguments or flags
+3  image, repository or blob not found
+4  authentication failed or access denied
+5  registry unreachable, timed out or unavailable
+6  partial failure, some images of a bulk command failed
+7  policy violation, such as scan --fail-on
+130 interrupted by SIGINT or SIGTERM
+
+An interrupt cancels requests in flight. A bulk delete finishes the
+image it is deleting and reports the images left; interrupt again to
+abort at once.
 `,
-	PersistentPreRun: check_registry,
+	PersistentPreRunE: check_registry,
+	SilenceErrors:     true,
+	SilenceUsage:      true,
 }
 
 func main() {
 	regvar := os.Getenv("REGISTRY")
 
 	RootCmd.PersistentFlags().StringVar(&regvar, "registry", regvar, "Base URL for registry")
+	RootCmd.PersistentFlags().StringVar(&contextname, "context", "", "Named registry context from the config file")
+	RootCmd.PersistentFlags().IntVarP(&concurrency, "concurrency", "j", 0, "Number of concurrent requests")
+	RootCmd.PersistentFlags().DurationVar(&reqtimeout, "request-timeout", 30*time.Second, "Time allowed to connect and receive response headers")
+	RootCmd.PersistentFlags().DurationVar(&overalltimeout, "timeout", 0, "Time allowed for the whole command, 0 for no limit")
+	RootCmd.PersistentFlags().IntVar(&retries, "retries", 4, "Retries for throttled, unavailable or reset requests")
+	RootCmd.PersistentFlags().Float64Var(&ratelimit, "rate", 0, "Maximum requests per second, 0 for no limit")
+	RootCmd.PersistentFlags().BoolVar(&nocache, "no-cache", false, "Do not use the local manifest cache")
+	RootCmd.PersistentFlags().DurationVar(&cachettl, "cache-ttl", 5*time.Minute, "How long cached tags are trusted before revalidation")
 
 	RootCmd.AddCommand(&cobra.Command{
 		Use:   "version",
@@ -52,8 +185,25 @@ func main() {
 	// REGISTRY_TLS_VERIFY=1
 	// --tlsverify
 
-	if err := RootCmd.Execute(); err != nil {
-		fmt.Fprintln(os.Stderr, err)
-		return
+	RootCmd.SetFlagErrorFunc(func(cmd *cobra.Command, err error) error {
+		cmd.UsageFunc()(cmd)
+		return &exitError{code: ExitUsage, err: err}
+	})
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-ctx.Done()
+		// restore the default handling, a second signal kills us
+		stop()
+	}()
+	interrupt, runctx = ctx, ctx
+
+	err := RootCmd.Execute()
+	runcancel()
+	if err != nil {
+		if msg := err.Error(); msg != "" {
+			fmt.Fprintln(os.Stderr, msg)
+		}
+		os.Exit(exitCode(err))
 	}
 }
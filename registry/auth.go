@@ -0,0 +1,231 @@
+package registry
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	neturl "net/url"
+	"strings"
+	"sync"
+)
+
+// Auth holds registry credentials.
+type Auth struct {
+	// Method is none, basic or token. Token uses the registry token
+	// service named in the WWW-Authenticate challenge.
+	Method   string
+	Username string
+	Password string
+}
+
+// authTransport answers registry authentication challenges, sending basic
+// credentials or fetching bearer tokens from the token service the
+// registry names. Tokens are reused for the repository they were issued
+// for. Requests to other hosts than the registry's, such as the storage
+// blob downloads are redirected to, are sent without credentials.
+type authTransport struct {
+	base http.RoundTripper
+	auth Auth
+	host string
+
+	mu     sync.Mutex
+	tokens map[string]string
+}
+
+func newAuthTransport(base http.RoundTripper, auth Auth, host string) *authTransport {
+	return &authTransport{base: base, auth: auth, host: host, tokens: make(map[string]string)}
+}
+
+// repository returns the repository a /v2/ request path refers to.
+func repository(path string) string {
+	path = strings.TrimPrefix(path, "/v2/")
+	for _, sep := range []string{"/manifests/", "/blobs/", "/tags/", "/referrers/"} {
+		if i := strings.Index(path, sep); i >= 0 {
+			return path[:i]
+		}
+	}
+	return ""
+}
+
+func (t *authTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.URL.Host != t.host {
+		return t.base.RoundTrip(req)
+	}
+
+	repo := repository(req.URL.Path)
+
+	t.mu.Lock()
+	token := t.tokens[repo]
+	t.mu.Unlock()
+
+	first := req.Clone(req.Context())
+	switch {
+	case token != "":
+		first.Header.Set("Authorization", "Bearer "+token)
+	case t.auth.Method == "basic":
+		first.SetBasicAuth(t.auth.Username, t.auth.Password)
+	}
+
+	resp, err := t.base.RoundTrip(first)
+	if err != nil || resp.StatusCode != http.StatusUnauthorized {
+		return resp, err
+	}
+
+	scheme, params := parseChallenge(resp.Header.Get("WWW-Authenticate"))
+
+	retry := req.Clone(req.Context())
+	if req.GetBody != nil {
+		if retry.Body, err = req.GetBody(); err != nil {
+			return resp, nil
+		}
+	} else if req.Body != nil && req.Body != http.NoBody {
+		return resp, nil
+	}
+
+	switch scheme {
+	case "basic":
+		if t.auth.Username == "" || t.auth.Method != "token" {
+			return resp, nil
+		}
+		retry.SetBasicAuth(t.auth.Username, t.auth.Password)
+
+	case "bearer":
+		token, err := t.token(params)
+		if err != nil {
+			resp.Body.Close()
+			return nil, err
+		}
+
+		t.mu.Lock()
+		t.tokens[repo] = token
+		t.mu.Unlock()
+
+		retry.Header.Set("Authorization", "Bearer "+token)
+
+	default:
+		return resp, nil
+	}
+
+	ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+
+	return t.base.RoundTrip(retry)
+}
+
+// token fetches a bearer token for the challenge.
+func (t *authTransport) token(params map[string]string) (string, error) {
+	realm := params["realm"]
+	if realm == "" {
+		return "", errors.New("bearer challenge without realm")
+	}
+
+	u, err := neturl.Parse(realm)
+	if err != nil {
+		return "", fmt.Errorf("token realm: %v", err)
+	}
+
+	q := u.Query()
+	if params["service"] != "" {
+		q.Set("service", params["service"])
+	}
+	if params["scope"] != "" {
+		q.Set("scope", params["scope"])
+	}
+	u.RawQuery = q.Encode()
+
+	req, err := http.NewRequest(http.MethodGet, u.String(), nil)
+	if err != nil {
+		return "", err
+	}
+
+	if t.auth.Username != "" && t.auth.Method != "none" {
+		req.SetBasicAuth(t.auth.Username, t.auth.Password)
+	}
+
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		return "", fmt.Errorf("token: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("%w: token: bad status: %s", ErrAuth, http.StatusText(resp.StatusCode))
+	}
+
+	var rpy struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(&rpy); err != nil {
+		return "", fmt.Errorf("token: %v", err)
+	}
+
+	if rpy.Token != "" {
+		return rpy.Token, nil
+	}
+	if rpy.AccessToken != "" {
+		return rpy.AccessToken, nil
+	}
+
+	return "", errors.New("token: empty response")
+}
+
+// parseChallenge splits a WWW-Authenticate header into its lower cased
+// scheme and parameters.
+func parseChallenge(hdr string) (string, map[string]string) {
+	params := make(map[string]string)
+
+	hdr = strings.TrimSpace(hdr)
+	i := strings.IndexByte(hdr, ' ')
+	if i < 0 {
+		return strings.ToLower(hdr), params
+	}
+
+	scheme := strings.ToLower(hdr[:i])
+	rest := hdr[i+1:]
+
+	for len(rest) > 0 {
+		rest = strings.TrimLeft(rest, " ,")
+
+		eq := strings.IndexByte(rest, '=')
+		if eq < 0 {
+			break
+		}
+
+		key := strings.ToLower(strings.TrimSpace(rest[:eq]))
+		rest = rest[eq+1:]
+
+		var val string
+		if strings.HasPrefix(rest, `"`) {
+			end := 1
+			for end < len(rest) && rest[end] != '"' {
+				if rest[end] == '\\' {
+					end++
+				}
+				end++
+			}
+			if end > len(rest) {
+				end = len(rest)
+			}
+			val = strings.ReplaceAll(rest[1:end], `\"`, `"`)
+			if end < len(rest) {
+				end++
+			}
+			rest = rest[end:]
+		} else {
+			end := strings.IndexByte(rest, ',')
+			if end < 0 {
+				end = len(rest)
+			}
+			val = strings.TrimSpace(rest[:end])
+			rest = rest[end:]
+		}
+
+		params[key] = val
+	}
+
+	return scheme, params
+}
@@ -0,0 +1,70 @@
+package registry
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+)
+
+type blobDecoder struct {
+	digest Digest
+	length string
+}
+
+func (b *blobDecoder) Method() string { return http.MethodHead }
+
+func (b *blobDecoder) SetHeaders(hdr *http.Header) {}
+
+func (b *blobDecoder) UnmarshalJSON(body []byte) error {
+	return nil
+}
+
+func (b *blobDecoder) ExtractHeaders(hdr *http.Header) {
+	b.digest = Digest(hdr.Get("Docker-Content-Digest"))
+	b.length = hdr.Get("Content-Length")
+}
+
+// BlobSize returns the size of a blob.
+func (c *Client) BlobSize(ctx context.Context, repo string, digest Digest) (uint64, error) {
+	b := &blobDecoder{}
+
+	err := c.get(ctx, c.base+"/v2/"+repo+"/blobs/"+string(digest), b)
+	if err != nil {
+		return 0, err
+	}
+
+	// a HEAD has no content to hash, so only a digest the registry
+	// reports can be checked
+	if b.digest != "" && b.digest != digest {
+		return 0, fmt.Errorf("blob %s: %w: registry reported %s", digest, ErrDigestMismatch, b.digest)
+	}
+
+	size, err := strconv.ParseUint(b.length, 10, 64)
+	if err != nil {
+		return 0, err
+	}
+
+	return size, nil
+}
+
+// ImageSize returns the total size of the layers of the manifest at ref.
+func (c *Client) ImageSize(ctx context.Context, repo, ref string) (uint64, error) {
+	_, blobs, err := c.Blobs(ctx, repo, ref)
+	if err != nil {
+		return 0, err
+	}
+
+	var total uint64
+
+	for _, b := range blobs {
+		size, err := c.BlobSize(ctx, repo, b)
+		if err != nil {
+			return 0, err
+		}
+
+		total += size
+	}
+
+	return total, nil
+}
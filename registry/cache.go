@@ -0,0 +1,281 @@
+package registry
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Cache keeps registry responses between runs.
+type Cache interface {
+	// Lookup returns a stored response for a GET or HEAD request.
+	Lookup(method, url string) (http.Header, []byte, bool)
+
+	// Prepare adds revalidation headers to a request.
+	Prepare(req *http.Request)
+
+	// Revalidated returns the stored response the registry answered
+	// 304 Not Modified for.
+	Revalidated(url string) (http.Header, []byte, bool)
+
+	// Store records a response.
+	Store(method, url string, hdr http.Header, body []byte)
+}
+
+// DiskCache keeps registry responses on disk. Content is addressed by
+// sha256 digest; tags map to digests and expire after ttl.
+type DiskCache struct {
+	dir string
+	ttl time.Duration
+}
+
+// NewDiskCache returns a cache stored below dir.
+func NewDiskCache(dir string, ttl time.Duration) *DiskCache {
+	return &DiskCache{dir: dir, ttl: ttl}
+}
+
+var cacheURL = regexp.MustCompile(`^(.*)/v2/(.+)/(manifests|blobs)/([^/]+)$`)
+
+type cachekey struct {
+	base  string
+	image string
+	kind  string
+	ref   string
+}
+
+func parseCacheURL(url string) (*cachekey, bool) {
+	m := cacheURL.FindStringSubmatch(url)
+	if m == nil || strings.HasSuffix(m[2], "/blobs") {
+		return nil, false
+	}
+	return &cachekey{base: m[1], image: m[2], kind: m[3], ref: m[4]}, true
+}
+
+func isDigest(ref string) bool {
+	return strings.HasPrefix(ref, "sha256:") && len(ref) == 71
+}
+
+type cachemeta struct {
+	MediaType string `json:"mediaType,omitempty"`
+	Size      int64  `json:"size"`
+}
+
+type cachetag struct {
+	Digest  string    `json:"digest"`
+	ETag    string    `json:"etag,omitempty"`
+	Checked time.Time `json:"checked"`
+}
+
+func (c *DiskCache) dataPath(digest string) string {
+	return filepath.Join(c.dir, "data", "sha256", strings.TrimPrefix(digest, "sha256:"))
+}
+
+func (c *DiskCache) metaPath(digest string) string {
+	return filepath.Join(c.dir, "meta", "sha256", strings.TrimPrefix(digest, "sha256:")+".json")
+}
+
+func (c *DiskCache) repoPath(k *cachekey) string {
+	return filepath.Join(c.dir, "tags", fmt.Sprintf("%x", sha256.Sum256([]byte(k.base))), filepath.FromSlash(k.image))
+}
+
+func (c *DiskCache) tagPath(k *cachekey) string {
+	return filepath.Join(c.repoPath(k), k.ref+".json")
+}
+
+func writeAtomic(path string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(path), ".tmp")
+	if err != nil {
+		return err
+	}
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
+
+func readJSON(path string, v interface{}) bool {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return false
+	}
+	return json.Unmarshal(data, v) == nil
+}
+
+func writeJSON(path string, v interface{}) {
+	data, err := json.Marshal(v)
+	if err == nil {
+		writeAtomic(path, data)
+	}
+}
+
+// touch marks an entry as used, for prune.
+func touch(path string) {
+	now := time.Now()
+	os.Chtimes(path, now, now)
+}
+
+// content returns the cached response for a digest.
+func (c *DiskCache) content(digest string, body bool) (http.Header, []byte, bool) {
+	var meta cachemeta
+	if !readJSON(c.metaPath(digest), &meta) {
+		return nil, nil, false
+	}
+	touch(c.metaPath(digest))
+
+	hdr := make(http.Header)
+	hdr.Set("Docker-Content-Digest", digest)
+	hdr.Set("Content-Length", strconv.FormatInt(meta.Size, 10))
+	if meta.MediaType != "" {
+		hdr.Set("Content-Type", meta.MediaType)
+	}
+
+	if !body {
+		return hdr, nil, true
+	}
+
+	data, err := ioutil.ReadFile(c.dataPath(digest))
+	if err != nil || int64(len(data)) != meta.Size {
+		return nil, nil, false
+	}
+	touch(c.dataPath(digest))
+
+	return hdr, data, true
+}
+
+// Lookup returns a cached response for a GET or HEAD request.
+func (c *DiskCache) Lookup(method, url string) (http.Header, []byte, bool) {
+	if method != http.MethodGet && method != http.MethodHead {
+		return nil, nil, false
+	}
+
+	k, ok := parseCacheURL(url)
+	if !ok {
+		return nil, nil, false
+	}
+
+	digest := k.ref
+	if !isDigest(digest) {
+		if k.kind != "manifests" {
+			return nil, nil, false
+		}
+
+		var tag cachetag
+		if !readJSON(c.tagPath(k), &tag) || time.Since(tag.Checked) > c.ttl {
+			return nil, nil, false
+		}
+		digest = tag.Digest
+	}
+
+	return c.content(digest, method == http.MethodGet)
+}
+
+// Prepare asks the registry to revalidate an expired tag.
+func (c *DiskCache) Prepare(req *http.Request) {
+	if req.Method != http.MethodGet {
+		return
+	}
+
+	k, ok := parseCacheURL(req.URL.String())
+	if !ok || k.kind != "manifests" || isDigest(k.ref) {
+		return
+	}
+
+	var tag cachetag
+	if readJSON(c.tagPath(k), &tag) && tag.ETag != "" {
+		req.Header.Set("If-None-Match", tag.ETag)
+	}
+}
+
+// Revalidated refreshes a tag the registry answered 304 Not Modified for.
+func (c *DiskCache) Revalidated(url string) (http.Header, []byte, bool) {
+	k, ok := parseCacheURL(url)
+	if !ok {
+		return nil, nil, false
+	}
+
+	var tag cachetag
+	if !readJSON(c.tagPath(k), &tag) {
+		return nil, nil, false
+	}
+
+	tag.Checked = time.Now()
+	writeJSON(c.tagPath(k), &tag)
+
+	return c.content(tag.Digest, true)
+}
+
+// Store records a registry response. Content is only kept when it hashes
+// to its digest. Changes to a repository forget its tags.
+func (c *DiskCache) Store(method, url string, hdr http.Header, body []byte) {
+	k, ok := parseCacheURL(url)
+	if !ok {
+		return
+	}
+
+	switch method {
+	case http.MethodPut, http.MethodDelete:
+		if k.kind == "manifests" {
+			os.RemoveAll(c.repoPath(k))
+		}
+		return
+
+	case http.MethodHead:
+		if k.kind != "blobs" || !isDigest(k.ref) {
+			return
+		}
+
+		size, err := strconv.ParseInt(hdr.Get("Content-Length"), 10, 64)
+		if err != nil {
+			return
+		}
+
+		if _, err := os.Stat(c.metaPath(k.ref)); err != nil {
+			writeJSON(c.metaPath(k.ref), &cachemeta{Size: size})
+		}
+
+	case http.MethodGet:
+		digest := fmt.Sprintf("sha256:%x", sha256.Sum256(body))
+		if isDigest(k.ref) && k.ref != digest {
+			return
+		}
+		if reported := hdr.Get("Docker-Content-Digest"); reported != "" && Digest(reported).Verify(body) != nil {
+			return
+		}
+
+		mediaType := ""
+		if k.kind == "manifests" {
+			mediaType = hdr.Get("Content-Type")
+		}
+
+		if err := writeAtomic(c.dataPath(digest), body); err != nil {
+			return
+		}
+		writeJSON(c.metaPath(digest), &cachemeta{MediaType: mediaType, Size: int64(len(body))})
+
+		if k.kind == "manifests" && !isDigest(k.ref) {
+			etag := hdr.Get("ETag")
+			writeJSON(c.tagPath(k), &cachetag{Digest: digest, ETag: etag, Checked: time.Now()})
+		}
+	}
+}
@@ -0,0 +1,87 @@
+package registry
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+type catalogDecoder struct {
+	images []string
+	next   string
+}
+
+func (c *catalogDecoder) Method() string { return http.MethodGet }
+
+func (c *catalogDecoder) SetHeaders(hdr *http.Header) {}
+
+func (c *catalogDecoder) UnmarshalJSON(b []byte) error {
+	type Catalog struct {
+		Repositories []string `json:"repositories"`
+	}
+
+	cat := &Catalog{}
+
+	err := json.Unmarshal(b, &cat)
+	if err != nil {
+		return fmt.Errorf("unmarshal: %v", err)
+	}
+
+	c.images = cat.Repositories
+
+	return nil
+}
+
+func (c *catalogDecoder) ExtractHeaders(hdr *http.Header) {
+	c.next = nextLink(hdr.Get("Link"))
+}
+
+// Catalog lists the repositories of the registry, following pagination.
+func (c *Client) Catalog(ctx context.Context) ([]string, error) {
+	var images []string
+
+	url := c.base + "/v2/_catalog"
+	for {
+		cat := &catalogDecoder{}
+
+		err := c.get(ctx, url, cat)
+		if err != nil {
+			return nil, err
+		}
+
+		images = append(images, cat.images...)
+
+		if cat.next == "" {
+			return images, nil
+		}
+
+		url, err = resolve(c.base, cat.next)
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+// nextLink returns the target of the rel="next" link of a Link header,
+// which paginated catalog and tag lists send while entries remain.
+func nextLink(hdr string) string {
+	for _, link := range strings.Split(hdr, ",") {
+		parts := strings.Split(link, ";")
+
+		target := strings.TrimSpace(parts[0])
+		if !strings.HasPrefix(target, "<") || !strings.HasSuffix(target, ">") {
+			continue
+		}
+
+		for _, p := range parts[1:] {
+			switch strings.TrimSpace(p) {
+			case `rel="next"`, "rel=next":
+				return target[1 : len(target)-1]
+			}
+		}
+	}
+
+	return ""
+}
@@ -0,0 +1,280 @@
+// Package registry is a client for the Docker Registry HTTP API V2 and the
+// OCI distribution API.
+//
+// A Client is bound to one registry. Requests authenticate as configured,
+// retry throttled and failed requests with backoff, and can be served from
+// a Cache:
+//
+//	c, err := registry.New("https://registry.example.com",
+//		registry.WithAuth(registry.Auth{Method: "token", Username: "ci", Password: pw}))
+//	if err != nil {
+//		return err
+//	}
+//
+//	tags, err := c.Tags(ctx, "team/app")
+package registry
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	neturl "net/url"
+	"strings"
+	"time"
+)
+
+// Client talks to one registry.
+type Client struct {
+	base      string
+	conn      *http.Client
+	cache     Cache
+	limiter   *limiter
+	retries   int
+	timeout   time.Duration
+	tls       *tls.Config
+	auth      Auth
+	transport http.RoundTripper
+}
+
+// Option configures a Client.
+type Option func(*Client)
+
+// WithAuth sets the credentials sent to the registry and its token service.
+func WithAuth(auth Auth) Option {
+	return func(c *Client) { c.auth = auth }
+}
+
+// WithTLSConfig sets the TLS configuration of the default transport.
+func WithTLSConfig(config *tls.Config) Option {
+	return func(c *Client) { c.tls = config }
+}
+
+// WithTimeout bounds connecting and waiting for response headers on the
+// default transport. The default is 30 seconds.
+func WithTimeout(d time.Duration) Option {
+	return func(c *Client) { c.timeout = d }
+}
+
+// WithTransport replaces the default transport. Authentication is still
+// layered on top of it.
+func WithTransport(rt http.RoundTripper) Option {
+	return func(c *Client) { c.transport = rt }
+}
+
+// WithCache serves responses from cache where possible.
+func WithCache(cache Cache) Option {
+	return func(c *Client) { c.cache = cache }
+}
+
+// WithRetries sets how often throttled, unavailable or reset requests are
+// retried. Reset requests are only retried when sending them again is
+// safe. The default is 4.
+func WithRetries(n int) Option {
+	return func(c *Client) { c.retries = n }
+}
+
+// WithRateLimit limits the client to rps requests per second.
+func WithRateLimit(rps float64) Option {
+	return func(c *Client) { c.limiter = newLimiter(rps) }
+}
+
+// New returns a client for the registry at base, such as
+// https://registry.example.com.
+func New(base string, opts ...Option) (*Client, error) {
+	if base == "" {
+		return nil, errors.New("no registry location")
+	}
+
+	c := &Client{
+		base:    strings.TrimSuffix(base, "/"),
+		retries: 4,
+		timeout: 30 * time.Second,
+	}
+
+	for _, opt := range opts {
+		opt(c)
+	}
+
+	transport := c.transport
+	if transport == nil {
+		dialer := &net.Dialer{Timeout: c.timeout, KeepAlive: 30 * time.Second}
+
+		transport = &http.Transport{
+			Proxy:                 http.ProxyFromEnvironment,
+			DialContext:           dialer.DialContext,
+			TLSClientConfig:       c.tls,
+			TLSHandshakeTimeout:   c.timeout,
+			ResponseHeaderTimeout: c.timeout,
+			IdleConnTimeout:       90 * time.Second,
+		}
+	}
+
+	u, err := neturl.Parse(c.base)
+	if err != nil || u.Host == "" {
+		return nil, fmt.Errorf("registry location %q: not a URL", base)
+	}
+
+	c.conn = &http.Client{Transport: newAuthTransport(transport, c.auth, u.Host)}
+
+	return c, nil
+}
+
+// URL returns the base URL of the registry.
+func (c *Client) URL() string { return c.base }
+
+// Ping checks the registry is reachable, accepts our credentials and
+// speaks the V2 API.
+func (c *Client) Ping(ctx context.Context) error {
+	url := c.base + "/v2/"
+
+	resp, err := c.send(ctx, func(ctx context.Context) (*http.Request, error) {
+		return http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	})
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusUnauthorized:
+		return ErrAuth
+	case http.StatusNotFound:
+		return errors.New("registry does not support v2 API")
+	case http.StatusOK:
+		break
+	default:
+		return fmt.Errorf("bad status (%s) %d", url, resp.StatusCode)
+	}
+
+	// consume body so connection can be reused
+	ioutil.ReadAll(resp.Body)
+
+	ver := resp.Header.Get("Docker-Distribution-API-Version")
+	if ver != "registry/2.0" {
+		return errors.New("registry does not support v2 API")
+	}
+
+	return nil
+}
+
+// Decoder describes a request and receives its response.
+type Decoder interface {
+	Method() string
+	SetHeaders(*http.Header)
+	UnmarshalJSON([]byte) error
+	ExtractHeaders(*http.Header)
+}
+
+// Encoder is implemented by a Decoder that sends a request body.
+type Encoder interface {
+	Body() []byte
+}
+
+// ErrAuth is wrapped by errors from failed authentication.
+var ErrAuth = errors.New("authentication failed")
+
+// ErrNotFound matches the errors returned for 404 responses.
+var ErrNotFound = errors.New("not found")
+
+// Do sends the request dec describes for path, such as
+// /v2/team/app/manifests/latest, and hands it the response.
+func (c *Client) Do(ctx context.Context, path string, dec Decoder) error {
+	return c.get(ctx, c.base+path, dec)
+}
+
+func (c *Client) get(ctx context.Context, url string, dec Decoder) error {
+	if c.cache != nil {
+		if hdr, body, ok := c.cache.Lookup(dec.Method(), url); ok {
+			dec.ExtractHeaders(&hdr)
+			return dec.UnmarshalJSON(body)
+		}
+	}
+
+	resp, err := c.do(ctx, url, dec)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotModified {
+		if c.cache == nil {
+			return errors.New("not modified, but no cache")
+		}
+
+		hdr, body, ok := c.cache.Revalidated(url)
+		if !ok {
+			return errors.New("not modified, but no cached copy")
+		}
+
+		dec.ExtractHeaders(&hdr)
+		return dec.UnmarshalJSON(body)
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("readall: %v", err)
+	}
+
+	if c.cache != nil {
+		c.cache.Store(dec.Method(), url, resp.Header, body)
+	}
+
+	dec.ExtractHeaders(&resp.Header)
+
+	return dec.UnmarshalJSON(body)
+}
+
+// do sends the request described by dec and checks the response status.
+// The caller must close the response body.
+func (c *Client) do(ctx context.Context, url string, dec Decoder) (*http.Response, error) {
+	resp, err := c.send(ctx, func(ctx context.Context) (*http.Request, error) {
+		var reqbody io.Reader
+		if enc, ok := dec.(Encoder); ok {
+			reqbody = bytes.NewReader(enc.Body())
+		}
+
+		req, err := http.NewRequestWithContext(ctx, dec.Method(), url, reqbody)
+		if err != nil {
+			return nil, fmt.Errorf("new request: %v", err)
+		}
+
+		req.Header.Add("Accept", "application/vnd.docker.distribution.manifest.v2+json,application/vnd.docker.distribution.manifest.list.v2+json")
+
+		dec.SetHeaders(&req.Header)
+
+		if c.cache != nil {
+			c.cache.Prepare(req)
+		}
+
+		return req, nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("get: %w", err)
+	}
+
+	if err := status(resp); err != nil {
+		resp.Body.Close()
+		return nil, err
+	}
+
+	return resp, nil
+}
+
+func status(resp *http.Response) error {
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusCreated:
+	case http.StatusAccepted:
+	case http.StatusNotModified:
+	default:
+		return newRegistryError(resp)
+	}
+
+	return nil
+}
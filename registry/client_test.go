@@ -0,0 +1,943 @@
+package registry_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha512"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dbulkow/registry_cmd/registry"
+	"github.com/dbulkow/registry_cmd/registry/registrytest"
+)
+
+var ctx = context.Background()
+
+func newClient(t *testing.T, reg *registrytest.Registry, opts ...registry.Option) *registry.Client {
+	t.Helper()
+
+	c, err := registry.New(reg.URL, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return c
+}
+
+func layer(name, body string) []byte {
+	return registrytest.Layer(registrytest.File{Name: name, Body: body})
+}
+
+func TestPing(t *testing.T) {
+	reg := registrytest.New()
+	defer reg.Close()
+
+	if err := newClient(t, reg).Ping(ctx); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCatalogPagination(t *testing.T) {
+	reg := registrytest.New(registrytest.WithPageSize(2))
+	defer reg.Close()
+
+	want := []string{"a/one", "a/two", "b", "c/x/y", "d"}
+	for _, name := range want {
+		reg.PushImage(name, "latest", registrytest.Image{})
+	}
+
+	got, err := newClient(t, reg).Catalog(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("catalog = %v, want %v", got, want)
+	}
+
+	if n := reg.Count("GET", "^/v2/_catalog$"); n != 3 {
+		t.Errorf("%d catalog requests, want 3", n)
+	}
+}
+
+func TestTagsPagination(t *testing.T) {
+	reg := registrytest.New(registrytest.WithPageSize(3))
+	defer reg.Close()
+
+	want := make([]string, 0)
+	for i := 0; i < 7; i++ {
+		tag := fmt.Sprintf("v%d", i)
+		reg.PushImage("app", tag, registrytest.Image{Layers: [][]byte{layer("f", tag)}})
+		want = append(want, tag)
+	}
+
+	got, err := newClient(t, reg).Tags(ctx, "app")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("tags = %v, want %v", got, want)
+	}
+}
+
+func TestTagsUnknownRepository(t *testing.T) {
+	reg := registrytest.New()
+	defer reg.Close()
+
+	_, err := newClient(t, reg).Tags(ctx, "nope")
+	if !errors.Is(err, registry.ErrNotFound) {
+		t.Fatalf("err = %v, want ErrNotFound", err)
+	}
+	if !registry.HasCode(err, registry.ErrNameUnknown) {
+		t.Errorf("err = %v, want NAME_UNKNOWN", err)
+	}
+}
+
+func TestBlobsAndSizes(t *testing.T) {
+	reg := registrytest.New()
+	defer reg.Close()
+
+	l1, l2 := layer("a", "first"), layer("b", "second")
+	desc := reg.PushImage("app", "1", registrytest.Image{Layers: [][]byte{l1, l2}})
+
+	c := newClient(t, reg)
+
+	digest, blobs, err := c.Blobs(ctx, "app", "1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if digest != desc.Digest {
+		t.Errorf("digest = %s, want %s", digest, desc.Digest)
+	}
+
+	want := []registry.Digest{registry.FromBytes(l1), registry.FromBytes(l2)}
+	if !reflect.DeepEqual(blobs, want) {
+		t.Errorf("blobs = %v, want %v", blobs, want)
+	}
+
+	size, err := c.BlobSize(ctx, "app", want[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size != uint64(len(l1)) {
+		t.Errorf("blob size = %d, want %d", size, len(l1))
+	}
+
+	total, err := c.ImageSize(ctx, "app", "1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if total != uint64(len(l1)+len(l2)) {
+		t.Errorf("image size = %d, want %d", total, len(l1)+len(l2))
+	}
+}
+
+func TestImageManifestPlatform(t *testing.T) {
+	for _, mediaType := range []string{registry.MediaTypeManifestList, registry.MediaTypeOCIIndex} {
+		t.Run(mediaType, func(t *testing.T) {
+			reg := registrytest.New()
+			defer reg.Close()
+
+			amd := reg.PushImage("multi", "", registrytest.Image{Layers: [][]byte{layer("arch", "amd64")}})
+			arm := reg.PushImage("multi", "", registrytest.Image{
+				Platform: registry.Platform{OS: "linux", Architecture: "arm64", Variant: "v8"},
+				Layers:   [][]byte{layer("arch", "arm64")},
+			})
+			reg.PushIndex("multi", "1", mediaType, amd, arm)
+
+			c := newClient(t, reg)
+
+			_, digest, err := c.ImageManifest(ctx, "multi", "1", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if digest != amd.Digest {
+				t.Errorf("default platform picked %s, want %s", digest, amd.Digest)
+			}
+
+			p, err := registry.ParsePlatform("linux/arm64")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			m, digest, err := c.ImageManifest(ctx, "multi", "1", p)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if digest != arm.Digest {
+				t.Errorf("linux/arm64 picked %s, want %s", digest, arm.Digest)
+			}
+
+			config, err := c.ImageConfig(ctx, "multi", m.Config.Digest)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if config.Architecture != "arm64" {
+				t.Errorf("config architecture = %q, want arm64", config.Architecture)
+			}
+
+			p, _ = registry.ParsePlatform("windows/amd64")
+			if _, _, err := c.ImageManifest(ctx, "multi", "1", p); err == nil {
+				t.Error("windows/amd64 matched")
+			}
+		})
+	}
+}
+
+func TestOpenBlob(t *testing.T) {
+	reg := registrytest.New()
+	defer reg.Close()
+
+	digest := reg.PushBlob("app", []byte("content"))
+
+	rc, err := newClient(t, reg).OpenBlob(ctx, "app", digest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rc.Close()
+
+	data, err := ioutil.ReadAll(rc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "content" {
+		t.Errorf("blob = %q", data)
+	}
+}
+
+func TestManifestNotFound(t *testing.T) {
+	reg := registrytest.New()
+	defer reg.Close()
+
+	reg.PushImage("app", "1", registrytest.Image{})
+
+	_, _, err := newClient(t, reg).Blobs(ctx, "app", "2")
+
+	var rerr *registry.RegistryError
+	if !errors.As(err, &rerr) {
+		t.Fatalf("err = %v, want RegistryError", err)
+	}
+	if rerr.StatusCode != http.StatusNotFound || rerr.Code() != registry.ErrManifestUnknown {
+		t.Errorf("err = %d %s, want 404 MANIFEST_UNKNOWN", rerr.StatusCode, rerr.Code())
+	}
+	if !errors.Is(err, registry.ErrNotFound) {
+		t.Error("err is not ErrNotFound")
+	}
+}
+
+func TestRetry(t *testing.T) {
+	reg := registrytest.New()
+	defer reg.Close()
+
+	reg.PushImage("app", "1", registrytest.Image{})
+	reg.Fail("GET", "/manifests/", http.StatusTooManyRequests, 1)
+	reg.Fail("GET", "/manifests/", http.StatusServiceUnavailable, 1)
+
+	if _, _, err := newClient(t, reg).Blobs(ctx, "app", "1"); err != nil {
+		t.Fatal(err)
+	}
+
+	if n := reg.Count("GET", "/manifests/"); n != 3 {
+		t.Errorf("%d manifest requests, want 3", n)
+	}
+}
+
+func TestRetryExhausted(t *testing.T) {
+	reg := registrytest.New()
+	defer reg.Close()
+
+	reg.PushImage("app", "1", registrytest.Image{})
+	reg.Fail("GET", "/manifests/", http.StatusTooManyRequests, 0)
+
+	_, _, err := newClient(t, reg, registry.WithRetries(1)).Blobs(ctx, "app", "1")
+	if !registry.HasCode(err, registry.ErrTooManyRequests) {
+		t.Fatalf("err = %v, want TOOMANYREQUESTS", err)
+	}
+
+	if n := reg.Count("GET", "/manifests/"); n != 2 {
+		t.Errorf("%d manifest requests, want 2", n)
+	}
+}
+
+// flaky fails the first times requests with method, passing the others
+// to the default transport.
+type flaky struct {
+	method string
+	times  int
+	resp   func(*http.Request) (*http.Response, error)
+}
+
+func (f *flaky) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Method == f.method && f.times > 0 {
+		f.times--
+		return f.resp(req)
+	}
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+func reset(req *http.Request) (*http.Response, error) {
+	return nil, io.ErrUnexpectedEOF
+}
+
+func TestRetryTransportError(t *testing.T) {
+	reg := registrytest.New()
+	defer reg.Close()
+
+	reg.PushImage("app", "1", registrytest.Image{})
+
+	// reads are retried
+	c := newClient(t, reg, registry.WithTransport(&flaky{method: "GET", times: 1, resp: reset}))
+	if _, err := c.Tags(ctx, "app"); err != nil {
+		t.Fatal(err)
+	}
+
+	// an upload may have started, so is not
+	c = newClient(t, reg, registry.WithTransport(&flaky{method: "POST", times: 1, resp: reset}))
+	if _, err := c.PushBlob(ctx, "app", []byte("content")); err == nil {
+		t.Fatal("push retried after a reset upload request")
+	}
+	if n := reg.Count("POST", "/blobs/uploads/"); n != 0 {
+		t.Errorf("%d uploads started, want 0", n)
+	}
+
+	// the PUT completing it is, with its body
+	c = newClient(t, reg, registry.WithTransport(&flaky{method: "PUT", times: 1, resp: reset}))
+	if _, err := c.PushBlob(ctx, "app", []byte("content")); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRetryAfterTooLong(t *testing.T) {
+	reg := registrytest.New()
+	defer reg.Close()
+
+	reg.PushImage("app", "1", registrytest.Image{})
+
+	throttled := func(req *http.Request) (*http.Response, error) {
+		return &http.Response{
+			StatusCode: http.StatusTooManyRequests,
+			Header:     http.Header{"Retry-After": []string{"3600"}},
+			Body:       ioutil.NopCloser(strings.NewReader("")),
+			Request:    req,
+		}, nil
+	}
+
+	start := time.Now()
+
+	c := newClient(t, reg, registry.WithTransport(&flaky{method: "GET", times: 1, resp: throttled}))
+	if _, err := c.Tags(ctx, "app"); !registry.HasCode(err, registry.ErrTooManyRequests) {
+		t.Fatalf("err = %v, want the 429", err)
+	}
+
+	if d := time.Since(start); d > 10*time.Second {
+		t.Errorf("waited %s for a Retry-After of an hour", d)
+	}
+}
+
+func TestCancel(t *testing.T) {
+	reg := registrytest.New()
+	defer reg.Close()
+
+	cctx, cancel := context.WithCancel(ctx)
+	cancel()
+
+	_, err := newClient(t, reg).Catalog(cctx)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("err = %v, want context.Canceled", err)
+	}
+}
+
+func TestBasicAuth(t *testing.T) {
+	reg := registrytest.New(registrytest.WithBasicAuth("ci", "secret"))
+	defer reg.Close()
+
+	reg.PushImage("app", "1", registrytest.Image{})
+
+	c := newClient(t, reg, registry.WithAuth(registry.Auth{Method: "basic", Username: "ci", Password: "secret"}))
+	if _, err := c.Tags(ctx, "app"); err != nil {
+		t.Fatal(err)
+	}
+
+	c = newClient(t, reg, registry.WithAuth(registry.Auth{Method: "basic", Username: "ci", Password: "wrong"}))
+	if err := c.Ping(ctx); !errors.Is(err, registry.ErrAuth) {
+		t.Fatalf("err = %v, want ErrAuth", err)
+	}
+}
+
+func TestTokenAuth(t *testing.T) {
+	reg := registrytest.New(registrytest.WithTokenAuth("ci", "secret"))
+	defer reg.Close()
+
+	reg.PushImage("app", "1", registrytest.Image{})
+	reg.PushImage("web", "1", registrytest.Image{})
+
+	c := newClient(t, reg, registry.WithAuth(registry.Auth{Method: "token", Username: "ci", Password: "secret"}))
+
+	if err := c.Ping(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := c.Catalog(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, repo := range []string{"app", "web", "app"} {
+		if _, err := c.Tags(ctx, repo); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// one token for the catalog and one per repository, reused
+	if n := reg.Count("GET", "^/token$"); n != 3 {
+		t.Errorf("%d token requests, want 3", n)
+	}
+
+	c = newClient(t, reg, registry.WithAuth(registry.Auth{Method: "token", Username: "ci", Password: "wrong"}))
+	if _, err := c.Tags(ctx, "app"); !errors.Is(err, registry.ErrAuth) {
+		t.Fatalf("err = %v, want ErrAuth", err)
+	}
+}
+
+func TestAuthRedirect(t *testing.T) {
+	for _, tt := range []struct {
+		opt  registrytest.Option
+		auth registry.Auth
+	}{
+		{registrytest.WithBasicAuth("ci", "secret"), registry.Auth{Method: "basic", Username: "ci", Password: "secret"}},
+		{registrytest.WithTokenAuth("ci", "secret"), registry.Auth{Method: "token", Username: "ci", Password: "secret"}},
+	} {
+		reg := registrytest.New(tt.opt, registrytest.WithBlobStorage())
+		defer reg.Close()
+
+		digest := reg.PushBlob("app", []byte("content"))
+
+		rc, err := newClient(t, reg, registry.WithAuth(tt.auth)).OpenBlob(ctx, "app", digest)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := ioutil.ReadAll(rc)
+		rc.Close()
+		if err != nil || string(data) != "content" {
+			t.Fatalf("blob = %q, %v", data, err)
+		}
+
+		// the storage host gets no registry credentials
+		auth := reg.StorageAuth()
+		if len(auth) == 0 {
+			t.Fatalf("%s: download not redirected", tt.auth.Method)
+		}
+		for _, a := range auth {
+			if a != "" {
+				t.Errorf("%s: storage sent Authorization %q", tt.auth.Method, a)
+			}
+		}
+	}
+}
+
+func TestPush(t *testing.T) {
+	reg := registrytest.New()
+	defer reg.Close()
+
+	c := newClient(t, reg)
+
+	config := []byte(`{"architecture":"amd64","os":"linux","rootfs":{"type":"layers","diff_ids":[]}}`)
+	cdigest, err := c.PushBlob(ctx, "app", config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if data, ok := reg.Blob("app", cdigest); !ok || string(data) != string(config) {
+		t.Fatalf("blob %s not stored", cdigest)
+	}
+
+	// pushing again finds the blob and skips the upload
+	if _, err := c.PushBlob(ctx, "app", config); err != nil {
+		t.Fatal(err)
+	}
+	if n := reg.Count("POST", "/blobs/uploads/"); n != 1 {
+		t.Errorf("%d uploads, want 1", n)
+	}
+
+	man, _ := json.Marshal(registry.Manifest{
+		SchemaVersion: 2,
+		MediaType:     registry.MediaTypeManifest,
+		Config:        registry.Descriptor{MediaType: registry.MediaTypeDockerConfig, Size: int64(len(config)), Digest: cdigest},
+		Layers:        []registry.Descriptor{},
+	})
+
+	digest, err := c.PushManifest(ctx, "app", "1", registry.MediaTypeManifest, man)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	raw, err := c.RawManifest(ctx, "app", "1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if raw.Digest != digest || raw.MediaType != registry.MediaTypeManifest || string(raw.Data) != string(man) {
+		t.Errorf("raw manifest = %s %s, want %s %s", raw.MediaType, raw.Digest, registry.MediaTypeManifest, digest)
+	}
+}
+
+func TestPushManifestBlobUnknown(t *testing.T) {
+	reg := registrytest.New()
+	defer reg.Close()
+
+	man, _ := json.Marshal(registry.Manifest{
+		SchemaVersion: 2,
+		MediaType:     registry.MediaTypeManifest,
+		Config:        registry.Descriptor{Digest: registry.FromBytes([]byte("missing"))},
+	})
+
+	_, err := newClient(t, reg).PushManifest(ctx, "app", "1", registry.MediaTypeManifest, man)
+	if !registry.HasCode(err, registry.ErrManifestBlobUnknown) {
+		t.Fatalf("err = %v, want MANIFEST_BLOB_UNKNOWN", err)
+	}
+}
+
+func TestDeleteManifest(t *testing.T) {
+	reg := registrytest.New()
+	defer reg.Close()
+
+	desc := reg.PushImage("app", "1", registrytest.Image{})
+	reg.PushManifest("app", "same", desc.MediaType, mustManifest(t, reg, "app", "1"))
+
+	c := newClient(t, reg)
+
+	if err := c.DeleteManifest(ctx, "app", desc.Digest); err != nil {
+		t.Fatal(err)
+	}
+
+	if tags := reg.Tags("app"); len(tags) != 0 {
+		t.Errorf("tags left after delete: %v", tags)
+	}
+
+	err := c.DeleteManifest(ctx, "app", desc.Digest)
+	if !errors.Is(err, registry.ErrNotFound) {
+		t.Errorf("second delete: err = %v, want ErrNotFound", err)
+	}
+}
+
+func TestDeleteDisabled(t *testing.T) {
+	reg := registrytest.New(registrytest.WithoutDelete())
+	defer reg.Close()
+
+	desc := reg.PushImage("app", "1", registrytest.Image{})
+
+	err := newClient(t, reg).DeleteManifest(ctx, "app", desc.Digest)
+	if !registry.HasCode(err, registry.ErrUnsupported) {
+		t.Fatalf("err = %v, want UNSUPPORTED", err)
+	}
+}
+
+func mustManifest(t *testing.T, reg *registrytest.Registry, repo, ref string) []byte {
+	t.Helper()
+
+	_, data, ok := reg.Manifest(repo, ref)
+	if !ok {
+		t.Fatalf("no manifest %s:%s", repo, ref)
+	}
+	return data
+}
+
+func TestPushReferrer(t *testing.T) {
+	for _, referrers := range []bool{true, false} {
+		t.Run(fmt.Sprintf("referrers=%v", referrers), func(t *testing.T) {
+			var opts []registrytest.Option
+			if !referrers {
+				opts = append(opts, registrytest.WithoutReferrers())
+			}
+
+			reg := registrytest.New(opts...)
+			defer reg.Close()
+
+			reg.PushImage("app", "1", registrytest.Image{})
+
+			c := newClient(t, reg)
+
+			subject, err := c.RawManifest(ctx, "app", "1")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			digest, err := c.PushReferrer(ctx, "app", subject, "application/example", "application/example", []byte("doc"), nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			_, data, ok := reg.Manifest("app", subject.Digest.Tag(""))
+			if ok == referrers {
+				t.Fatalf("fallback tag index present = %v, want %v", ok, !referrers)
+			}
+
+			if !referrers {
+				var index registry.Index
+				if err := json.Unmarshal(data, &index); err != nil {
+					t.Fatal(err)
+				}
+				if len(index.Manifests) != 1 || index.Manifests[0].Digest != digest {
+					t.Errorf("fallback index = %+v, want %s", index.Manifests, digest)
+				}
+			}
+		})
+	}
+}
+
+func TestDiskCache(t *testing.T) {
+	reg := registrytest.New()
+	defer reg.Close()
+
+	desc := reg.PushImage("app", "1", registrytest.Image{})
+
+	c := newClient(t, reg, registry.WithCache(registry.NewDiskCache(t.TempDir(), 0)))
+
+	for i := 0; i < 3; i++ {
+		digest, _, err := c.Blobs(ctx, "app", "1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if digest != desc.Digest {
+			t.Fatalf("digest = %s, want %s", digest, desc.Digest)
+		}
+	}
+
+	// the tag expires at once, so it is revalidated and the registry
+	// answers 304 Not Modified from the second request on
+	if n := reg.Count("GET", "/manifests/1$"); n != 3 {
+		t.Errorf("%d manifest requests, want 3", n)
+	}
+
+	for i := 0; i < 2; i++ {
+		if _, _, err := c.Blobs(ctx, "app", string(desc.Digest)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if n := reg.Count("GET", "/manifests/sha256:"); n != 0 {
+		t.Errorf("%d requests for a cached digest, want 0", n)
+	}
+}
+
+func TestDigest(t *testing.T) {
+	d := registry.FromBytes([]byte("hello"))
+
+	if d.Algorithm() != "sha256" {
+		t.Errorf("algorithm = %q", d.Algorithm())
+	}
+	if d.Hex() != "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824" {
+		t.Errorf("hex = %q", d.Hex())
+	}
+	if d.Tag(".sig") != "sha256-"+d.Hex()+".sig" {
+		t.Errorf("tag = %q", d.Tag(".sig"))
+	}
+
+	if _, err := registry.ParseDigest(string(d)); err != nil {
+		t.Error(err)
+	}
+	for _, bad := range []string{"", "sha256:abc", "md5:" + d.Hex(), d.Hex()} {
+		if _, err := registry.ParseDigest(bad); err == nil {
+			t.Errorf("ParseDigest(%q) succeeded", bad)
+		}
+	}
+
+	if err := d.Verify([]byte("hello")); err != nil {
+		t.Error(err)
+	}
+	if err := d.Verify([]byte("hello\n")); !errors.Is(err, registry.ErrDigestMismatch) {
+		t.Errorf("Verify of other content = %v", err)
+	}
+
+	sha512 := registry.Digest("sha512:9b71d224bd62f3785d96d46ad3ea3d73319bfbc2890caadae2dff72519673ca72323c3d99ba5c11d7c7acc6e14b8c5da0c4663475c2e5c3adef46f73bcdec043")
+	if err := sha512.Verify([]byte("hello")); err != nil {
+		t.Error(err)
+	}
+	if err := registry.Digest("md5:abc").Verify([]byte("hello")); err == nil {
+		t.Error("md5 digest verified")
+	}
+}
+
+func TestManifestDigestMissing(t *testing.T) {
+	reg := registrytest.New(registrytest.WithoutDigestHeader())
+	defer reg.Close()
+
+	l := layer("a", "first")
+	desc := reg.PushImage("app", "1", registrytest.Image{Layers: [][]byte{l}})
+
+	c := newClient(t, reg)
+
+	digest, _, err := c.Blobs(ctx, "app", "1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if digest != desc.Digest {
+		t.Errorf("digest = %s, want %s", digest, desc.Digest)
+	}
+
+	m, err := c.RawManifest(ctx, "app", string(desc.Digest))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Digest != desc.Digest {
+		t.Errorf("raw manifest digest = %s, want %s", m.Digest, desc.Digest)
+	}
+
+	if _, err := c.BlobSize(ctx, "app", registry.FromBytes(l)); err != nil {
+		t.Errorf("blob size without digest header: %v", err)
+	}
+}
+
+func TestManifestDigestMismatch(t *testing.T) {
+	reg := registrytest.New()
+	defer reg.Close()
+
+	desc := reg.PushImage("app", "1", registrytest.Image{Layers: [][]byte{layer("a", "first")}})
+	_, data, _ := reg.Manifest("app", "1")
+	reg.Tamper("app", "1", append(data, ' '))
+
+	c := newClient(t, reg, registry.WithCache(registry.NewDiskCache(t.TempDir(), 0)))
+
+	for _, ref := range []string{"1", string(desc.Digest)} {
+		if _, _, err := c.Blobs(ctx, "app", ref); !errors.Is(err, registry.ErrDigestMismatch) {
+			t.Errorf("Blobs(%s) = %v, want digest mismatch", ref, err)
+		}
+		if _, err := c.RawManifest(ctx, "app", ref); !errors.Is(err, registry.ErrDigestMismatch) {
+			t.Errorf("RawManifest(%s) = %v, want digest mismatch", ref, err)
+		}
+	}
+
+	// the bad copy must not have been cached
+	reg.Tamper("app", "1", data)
+	if _, _, err := c.Blobs(ctx, "app", "1"); err != nil {
+		t.Errorf("after repair: %v", err)
+	}
+}
+
+func TestManifestSHA512(t *testing.T) {
+	reg := registrytest.New()
+	defer reg.Close()
+
+	c := newClient(t, reg)
+
+	data := []byte(`{"schemaVersion":2,"mediaType":"` + registry.MediaTypeOCIIndex + `","manifests":[]}`)
+	digest := registry.Digest(fmt.Sprintf("sha512:%x", sha512.Sum512(data)))
+
+	if _, err := c.PushManifest(ctx, "app", string(digest), registry.MediaTypeOCIIndex, data); err != nil {
+		t.Fatal(err)
+	}
+
+	m, err := c.RawManifest(ctx, "app", string(digest))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Digest != digest {
+		t.Errorf("digest = %s, want %s", m.Digest, digest)
+	}
+}
+
+func TestSchema1(t *testing.T) {
+	reg := registrytest.New()
+	defer reg.Close()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	l := layer("a", "first")
+	signed := reg.PushSchema1("app", "signed", registrytest.Image{Layers: [][]byte{l}}, key)
+	unsigned := reg.PushSchema1("app", "unsigned", registrytest.Image{Layers: [][]byte{l}}, nil)
+
+	c := newClient(t, reg)
+
+	for _, d := range []registry.Descriptor{signed, unsigned} {
+		m, err := c.RawManifest(ctx, "app", string(d.Digest))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if m.Digest != d.Digest || m.MediaType != d.MediaType {
+			t.Errorf("manifest %s %s, want %s %s", m.MediaType, m.Digest, d.MediaType, d.Digest)
+		}
+
+		s1, err := registry.ParseSchema1(m.Data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(s1.FSLayers) != 1 || s1.FSLayers[0].BlobSum != registry.FromBytes(l) {
+			t.Errorf("layers = %v", s1.FSLayers)
+		}
+	}
+
+	digest, blobs, err := c.Blobs(ctx, "app", "signed")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if digest != signed.Digest || len(blobs) != 1 {
+		t.Errorf("Blobs = %s %v", digest, blobs)
+	}
+
+	// content other than signed no longer has the digest
+	_, data, _ := reg.Manifest("app", "signed")
+	reg.Tamper("app", "signed", bytes.Replace(data, []byte(`"name": "app"`), []byte(`"name": "bad"`), 1))
+
+	if _, err := c.RawManifest(ctx, "app", "signed"); !errors.Is(err, registry.ErrDigestMismatch) {
+		t.Errorf("tampered manifest: %v, want digest mismatch", err)
+	}
+
+	// a bad signature is only found by verifying it
+	reg.Tamper("app", "signed", badSignature(t, data))
+
+	m, err := c.RawManifest(ctx, "app", "signed")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Digest != signed.Digest {
+		t.Errorf("digest = %s, want %s", m.Digest, signed.Digest)
+	}
+	if _, err := registry.VerifySchema1(m.Data); !errors.Is(err, registry.ErrSignature) {
+		t.Errorf("bad signature: %v, want signature error", err)
+	}
+}
+
+// badSignature returns the signed schema 1 manifest data with its
+// signature altered.
+func badSignature(t *testing.T, data []byte) []byte {
+	t.Helper()
+
+	s1, err := registry.ParseSchema1(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sig := s1.Signatures[0].Signature
+	flipped := "A" + sig[1:]
+	if sig[0] == 'A' {
+		flipped = "B" + sig[1:]
+	}
+
+	return bytes.Replace(data, []byte(sig), []byte(flipped), 1)
+}
+
+func TestVerifySchema1(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	payload := []byte("{\n   \"schemaVersion\": 1,\n   \"name\": \"app\",\n   \"fsLayers\": [{\"blobSum\": \"sha256:0\"}],\n   \"history\": [{\"v1Compatibility\": \"{}\"}]\n}")
+	data := registrytest.SignSchema1(payload, key)
+
+	got, err := registry.VerifySchema1(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, payload) {
+		t.Errorf("payload = %s", got)
+	}
+
+	// two signatures over the same payload
+	other, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	twice := registrytest.SignSchema1(payload, other)
+	s1, _ := registry.ParseSchema1(data)
+	s2, _ := registry.ParseSchema1(twice)
+	s1.Signatures = append(s1.Signatures, s2.Signatures...)
+
+	both, _ := json.Marshal(s1.Signatures)
+	n := bytes.LastIndex(payload, []byte("\n}"))
+	combined := append(append(append([]byte{}, payload[:n]...), []byte(`, "signatures": `+string(both))...), payload[n:]...)
+
+	if _, err := registry.VerifySchema1(combined); err != nil {
+		t.Errorf("two signatures: %v", err)
+	}
+
+	// fields after formatLength are not signed, and would win when parsed
+	evil := `"fsLayers": [{"blobSum": "sha256:1"}], "history": [{"v1Compatibility": "{}"}]`
+	end := bytes.LastIndex(data, []byte("}"))
+	for name, tampered := range map[string][]byte{
+		"before the signatures": bytes.Replace(data, []byte(`"signatures"`), []byte(evil+`, "signatures"`), 1),
+		"after the signatures":  append(append(append([]byte{}, data[:end]...), ", "+evil...), data[end:]...),
+	} {
+		if _, err := registry.VerifySchema1(tampered); !errors.Is(err, registry.ErrSignature) {
+			t.Errorf("content %s: %v, want signature error", name, err)
+		}
+	}
+
+	bad := bytes.Replace(data, []byte(`"alg": "ES256"`), []byte(`"alg": "ES384"`), 1)
+	if _, err := registry.VerifySchema1(bad); err == nil {
+		t.Error("signature verified with the wrong algorithm")
+	}
+
+	if _, err := registry.VerifySchema1([]byte(`{"schemaVersion": 2}`)); err == nil {
+		t.Error("schema 2 manifest verified as schema 1")
+	}
+}
+
+func TestCopyBlob(t *testing.T) {
+	for _, opts := range [][]registrytest.Option{nil, {registrytest.WithoutMount()}} {
+		reg := registrytest.New(opts...)
+		defer reg.Close()
+
+		l := layer("a", "first")
+		digest := reg.PushBlob("src", l)
+
+		c := newClient(t, reg)
+
+		if err := c.CopyBlob(ctx, "dst", "src", digest); err != nil {
+			t.Fatal(err)
+		}
+
+		if data, ok := reg.Blob("dst", digest); !ok || !bytes.Equal(data, l) {
+			t.Errorf("blob not copied")
+		}
+
+		// already there
+		if err := c.CopyBlob(ctx, "dst", "src", digest); err != nil {
+			t.Error(err)
+		}
+
+		if err := c.CopyBlob(ctx, "dst", "src", registry.FromBytes([]byte("missing"))); !errors.Is(err, registry.ErrNotFound) {
+			t.Errorf("copy of missing blob: %v", err)
+		}
+	}
+}
+
+func TestOpenBlobVerify(t *testing.T) {
+	reg := registrytest.New()
+	defer reg.Close()
+
+	l := layer("a", "first")
+	digest := reg.PushBlob("app", l)
+
+	c := newClient(t, reg)
+
+	read := func() ([]byte, error) {
+		blob, err := c.OpenBlob(ctx, "app", digest)
+		if err != nil {
+			return nil, err
+		}
+		defer blob.Close()
+
+		return ioutil.ReadAll(blob)
+	}
+
+	if data, err := read(); err != nil || !bytes.Equal(data, l) {
+		t.Fatalf("read = %v", err)
+	}
+
+	reg.TamperBlob(digest, layer("a", "other"))
+	if _, err := read(); !errors.Is(err, registry.ErrDigestMismatch) {
+		t.Errorf("tampered blob: %v, want digest mismatch", err)
+	}
+}
@@ -0,0 +1,119 @@
+package registry
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"regexp"
+	"strings"
+)
+
+// Digest identifies content by hash, as algorithm:hex.
+type Digest string
+
+var digestPattern = regexp.MustCompile(`^(sha256:[a-f0-9]{64}|sha512:[a-f0-9]{128})$`)
+
+// FromBytes returns the sha256 digest of b.
+func FromBytes(b []byte) Digest {
+	return Digest(fmt.Sprintf("sha256:%x", sha256.Sum256(b)))
+}
+
+// ErrDigestMismatch is wrapped by errors for content that does not hash to
+// its digest.
+var ErrDigestMismatch = errors.New("digest mismatch")
+
+// digestOf returns the digest of b using algorithm.
+func digestOf(algorithm string, b []byte) (Digest, error) {
+	switch algorithm {
+	case "sha256":
+		return Digest(fmt.Sprintf("sha256:%x", sha256.Sum256(b))), nil
+	case "sha512":
+		return Digest(fmt.Sprintf("sha512:%x", sha512.Sum512(b))), nil
+	}
+	return "", fmt.Errorf("unsupported digest algorithm %q", algorithm)
+}
+
+// Verify checks b hashes to d, using the algorithm of d.
+func (d Digest) Verify(b []byte) error {
+	got, err := digestOf(d.Algorithm(), b)
+	if err != nil {
+		return err
+	}
+
+	if got != d {
+		return fmt.Errorf("%w: want %s, got %s", ErrDigestMismatch, d, got)
+	}
+
+	return nil
+}
+
+// verifier hashes what is read through it and fails the read that reaches
+// the end unless the content hashes to digest.
+type verifier struct {
+	io.ReadCloser
+	hash   hash.Hash
+	digest Digest
+}
+
+// newVerifier checks r hashes to d as it is read to its end.
+func newVerifier(r io.ReadCloser, d Digest) (io.ReadCloser, error) {
+	var h hash.Hash
+	switch d.Algorithm() {
+	case "sha256":
+		h = sha256.New()
+	case "sha512":
+		h = sha512.New()
+	default:
+		return nil, fmt.Errorf("unsupported digest algorithm %q", d.Algorithm())
+	}
+
+	return &verifier{ReadCloser: r, hash: h, digest: d}, nil
+}
+
+func (v *verifier) Read(p []byte) (int, error) {
+	n, err := v.ReadCloser.Read(p)
+	v.hash.Write(p[:n])
+
+	if err == io.EOF {
+		if got := Digest(fmt.Sprintf("%s:%x", v.digest.Algorithm(), v.hash.Sum(nil))); got != v.digest {
+			return n, fmt.Errorf("%w: want %s, got %s", ErrDigestMismatch, v.digest, got)
+		}
+	}
+
+	return n, err
+}
+
+// ParseDigest checks s is a sha256 or sha512 digest.
+func ParseDigest(s string) (Digest, error) {
+	if !digestPattern.MatchString(s) {
+		return "", fmt.Errorf("invalid digest %q", s)
+	}
+	return Digest(s), nil
+}
+
+// Algorithm returns the hash algorithm, such as sha256.
+func (d Digest) Algorithm() string {
+	if i := strings.IndexByte(string(d), ':'); i >= 0 {
+		return string(d[:i])
+	}
+	return ""
+}
+
+// Hex returns the encoded hash.
+func (d Digest) Hex() string {
+	if i := strings.IndexByte(string(d), ':'); i >= 0 {
+		return string(d[i+1:])
+	}
+	return string(d)
+}
+
+// Tag returns the tag naming content about d in the tag schema used by
+// cosign and the referrers fallback: sha256-<hex> followed by suffix.
+func (d Digest) Tag(suffix string) string {
+	return strings.Replace(string(d), ":", "-", 1) + suffix
+}
+
+func (d Digest) String() string { return string(d) }
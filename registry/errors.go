@@ -0,0 +1,130 @@
+package registry
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+)
+
+// ErrorCode is an error code of the distribution API.
+type ErrorCode string
+
+const (
+	ErrBlobUnknown         ErrorCode = "BLOB_UNKNOWN"
+	ErrBlobUploadInvalid   ErrorCode = "BLOB_UPLOAD_INVALID"
+	ErrBlobUploadUnknown   ErrorCode = "BLOB_UPLOAD_UNKNOWN"
+	ErrDigestInvalid       ErrorCode = "DIGEST_INVALID"
+	ErrManifestBlobUnknown ErrorCode = "MANIFEST_BLOB_UNKNOWN"
+	ErrManifestInvalid     ErrorCode = "MANIFEST_INVALID"
+	ErrManifestUnknown     ErrorCode = "MANIFEST_UNKNOWN"
+	ErrManifestUnverified  ErrorCode = "MANIFEST_UNVERIFIED"
+	ErrNameInvalid         ErrorCode = "NAME_INVALID"
+	ErrNameUnknown         ErrorCode = "NAME_UNKNOWN"
+	ErrSizeInvalid         ErrorCode = "SIZE_INVALID"
+	ErrTagInvalid          ErrorCode = "TAG_INVALID"
+	ErrUnauthorized        ErrorCode = "UNAUTHORIZED"
+	ErrDenied              ErrorCode = "DENIED"
+	ErrUnsupported         ErrorCode = "UNSUPPORTED"
+	ErrTooManyRequests     ErrorCode = "TOOMANYREQUESTS"
+	ErrUnknown             ErrorCode = "UNKNOWN"
+)
+
+// ErrorDetail is one entry of the errors array a registry responds with.
+type ErrorDetail struct {
+	Code    ErrorCode       `json:"code"`
+	Message string          `json:"message"`
+	Detail  json.RawMessage `json:"detail,omitempty"`
+}
+
+// RegistryError is returned for 4xx and 5xx responses. Errors holds the
+// decoded errors array, empty when the registry sent none.
+type RegistryError struct {
+	StatusCode int
+	Errors     []ErrorDetail
+}
+
+// Code returns the code of the first error, or failing that the code
+// implied by the status.
+func (e *RegistryError) Code() ErrorCode {
+	if len(e.Errors) > 0 {
+		return e.Errors[0].Code
+	}
+
+	switch e.StatusCode {
+	case http.StatusUnauthorized:
+		return ErrUnauthorized
+	case http.StatusForbidden:
+		return ErrDenied
+	case http.StatusMethodNotAllowed:
+		return ErrUnsupported
+	case http.StatusTooManyRequests:
+		return ErrTooManyRequests
+	}
+
+	return ErrUnknown
+}
+
+// Has reports whether any of the errors carries code.
+func (e *RegistryError) Has(code ErrorCode) bool {
+	for _, d := range e.Errors {
+		if d.Code == code {
+			return true
+		}
+	}
+	return len(e.Errors) == 0 && e.Code() == code
+}
+
+func (e *RegistryError) Error() string {
+	if len(e.Errors) == 0 {
+		return fmt.Sprintf("bad status: %s", http.StatusText(e.StatusCode))
+	}
+
+	msgs := make([]string, 0, len(e.Errors))
+	for _, d := range e.Errors {
+		msg := string(d.Code)
+		if d.Message != "" {
+			msg += ": " + d.Message
+		}
+		if len(d.Detail) > 0 && string(d.Detail) != "null" {
+			msg += " " + string(d.Detail)
+		}
+		msgs = append(msgs, msg)
+	}
+
+	return strings.Join(msgs, "; ")
+}
+
+// Is makes a 404 RegistryError match ErrNotFound.
+func (e *RegistryError) Is(target error) bool {
+	return target == ErrNotFound && e.StatusCode == http.StatusNotFound
+}
+
+// HasCode reports whether err is a RegistryError carrying code.
+func HasCode(err error, code ErrorCode) bool {
+	var rerr *RegistryError
+	return errors.As(err, &rerr) && rerr.Has(code)
+}
+
+// newRegistryError decodes the errors array of a failed response.
+func newRegistryError(resp *http.Response) *RegistryError {
+	e := &RegistryError{StatusCode: resp.StatusCode}
+
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil || len(body) == 0 {
+		return e
+	}
+
+	var rpy struct {
+		Errors []ErrorDetail `json:"errors"`
+	}
+
+	if json.Unmarshal(body, &rpy) == nil {
+		e.Errors = rpy.Errors
+	}
+
+	return e
+}
@@ -0,0 +1,173 @@
+package registry
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+type blobGet struct{}
+
+func (b *blobGet) Method() string { return http.MethodGet }
+
+func (b *blobGet) SetHeaders(hdr *http.Header) {}
+
+func (b *blobGet) UnmarshalJSON(body []byte) error {
+	return nil
+}
+
+func (b *blobGet) ExtractHeaders(hdr *http.Header) {}
+
+// OpenBlob streams the content of a blob. The caller must close it. The
+// read reaching the end of the blob fails with an error wrapping
+// ErrDigestMismatch unless the content matches digest.
+func (c *Client) OpenBlob(ctx context.Context, repo string, digest Digest) (io.ReadCloser, error) {
+	resp, err := c.do(ctx, c.base+"/v2/"+repo+"/blobs/"+string(digest), &blobGet{})
+	if err != nil {
+		return nil, err
+	}
+
+	r, err := newVerifier(resp.Body, digest)
+	if err != nil {
+		resp.Body.Close()
+		return nil, err
+	}
+
+	return r, nil
+}
+
+type configDecoder struct {
+	config *ImageConfig
+	data   []byte
+}
+
+func (c *configDecoder) Method() string { return http.MethodGet }
+
+func (c *configDecoder) SetHeaders(hdr *http.Header) {}
+
+func (c *configDecoder) UnmarshalJSON(b []byte) error {
+	c.data = b
+	c.config = &ImageConfig{}
+
+	err := json.Unmarshal(b, c.config)
+	if err != nil {
+		return fmt.Errorf("unmarshal config: %v", err)
+	}
+
+	return nil
+}
+
+func (c *configDecoder) ExtractHeaders(hdr *http.Header) {}
+
+// ImageConfig fetches the image configuration blob with digest.
+func (c *Client) ImageConfig(ctx context.Context, repo string, digest Digest) (*ImageConfig, error) {
+	cfg := &configDecoder{}
+
+	err := c.get(ctx, c.base+"/v2/"+repo+"/blobs/"+string(digest), cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := digest.Verify(cfg.data); err != nil {
+		return nil, fmt.Errorf("config %s: %w", digest, err)
+	}
+
+	return cfg.config, nil
+}
+
+// ParsePlatform parses os/arch[/variant].
+func ParsePlatform(s string) (*Platform, error) {
+	parts := strings.Split(s, "/")
+	if len(parts) < 2 || len(parts) > 3 || parts[0] == "" || parts[1] == "" {
+		return nil, fmt.Errorf("bad platform %q, want os/arch[/variant]", s)
+	}
+
+	p := &Platform{OS: parts[0], Architecture: parts[1]}
+	if len(parts) == 3 {
+		p.Variant = parts[2]
+	}
+
+	return p, nil
+}
+
+func (p *Platform) String() string {
+	s := p.OS + "/" + p.Architecture
+	if p.Variant != "" {
+		s += "/" + p.Variant
+	}
+	return s
+}
+
+// Matches reports whether p satisfies want. An empty variant in want
+// matches any variant.
+func (p *Platform) Matches(want *Platform) bool {
+	return p.OS == want.OS && p.Architecture == want.Architecture &&
+		(want.Variant == "" || p.Variant == want.Variant)
+}
+
+// DefaultPlatform is selected from manifest lists with several entries
+// when no platform is asked for.
+var DefaultPlatform = Platform{OS: "linux", Architecture: "amd64"}
+
+// ImageManifest fetches the image manifest at ref and its digest.
+// Manifest lists and indexes are resolved to the entry for platform, which
+// defaults to DefaultPlatform when the list has more than one entry.
+func (c *Client) ImageManifest(ctx context.Context, repo, ref string, platform *Platform) (*Manifest, Digest, error) {
+	raw, err := c.RawManifest(ctx, repo, ref)
+	if err != nil {
+		return nil, "", err
+	}
+
+	probe := struct {
+		SchemaVersion int          `json:"schemaVersion"`
+		Manifests     []Descriptor `json:"manifests"`
+	}{}
+
+	if err := json.Unmarshal(raw.Data, &probe); err != nil {
+		return nil, "", fmt.Errorf("unmarshal manifest: %v", err)
+	}
+
+	if probe.SchemaVersion != 2 {
+		return nil, "", fmt.Errorf("unsupported schema version %d", probe.SchemaVersion)
+	}
+
+	if probe.Manifests != nil {
+		want := platform
+		if want == nil {
+			want = &DefaultPlatform
+		}
+
+		var child *Descriptor
+		for i, d := range probe.Manifests {
+			if d.Platform != nil && d.Platform.Matches(want) {
+				child = &probe.Manifests[i]
+				break
+			}
+		}
+
+		if child == nil && len(probe.Manifests) == 1 {
+			child = &probe.Manifests[0]
+		}
+
+		if child == nil {
+			return nil, "", fmt.Errorf("no manifest for platform %s", want)
+		}
+
+		return c.ImageManifest(ctx, repo, string(child.Digest), want)
+	}
+
+	m := &Manifest{}
+	if err := json.Unmarshal(raw.Data, m); err != nil {
+		return nil, "", fmt.Errorf("unmarshal manifest: %v", err)
+	}
+
+	if m.Config.Digest == "" {
+		return nil, "", errors.New("manifest has no config")
+	}
+
+	return m, raw.Digest, nil
+}
@@ -0,0 +1,237 @@
+package registry
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+const (
+	MediaTypeManifest     = "application/vnd.docker.distribution.manifest.v2+json"
+	MediaTypeManifestList = "application/vnd.docker.distribution.manifest.list.v2+json"
+	MediaTypeOCIManifest  = "application/vnd.oci.image.manifest.v1+json"
+	MediaTypeOCIIndex     = "application/vnd.oci.image.index.v1+json"
+	MediaTypeOCIConfig    = "application/vnd.oci.image.config.v1+json"
+	MediaTypeOCIEmpty     = "application/vnd.oci.empty.v1+json"
+	MediaTypeDockerConfig = "application/vnd.docker.container.image.v1+json"
+	MediaTypeDockerLayer  = "application/vnd.docker.image.rootfs.diff.tar.gzip"
+	MediaTypeOCILayer     = "application/vnd.oci.image.layer.v1.tar+gzip"
+	MediaTypeOCILayerTar  = "application/vnd.oci.image.layer.v1.tar"
+	MediaTypeOCILayerZstd = "application/vnd.oci.image.layer.v1.tar+zstd"
+
+	// Foreign layers are fetched from their URLs rather than the registry.
+	MediaTypeDockerForeignLayer = "application/vnd.docker.image.rootfs.foreign.diff.tar.gzip"
+	MediaTypeOCIForeignLayer    = "application/vnd.oci.image.layer.nondistributable.v1.tar+gzip"
+)
+
+// manifestAccept lists every manifest media type the client understands.
+var manifestAccept = strings.Join([]string{
+	MediaTypeManifest,
+	MediaTypeManifestList,
+	MediaTypeOCIManifest,
+	MediaTypeOCIIndex,
+	MediaTypeSignedSchema1,
+	MediaTypeSchema1,
+}, ",")
+
+type manifestDecoder struct {
+	data      []byte
+	digest    Digest
+	config    Digest
+	blobs     []Digest
+	manifests []Digest
+}
+
+func (m *manifestDecoder) Method() string { return http.MethodGet }
+
+func (m *manifestDecoder) SetHeaders(hdr *http.Header) {
+	hdr.Set("Accept", manifestAccept)
+}
+
+func (m *manifestDecoder) UnmarshalJSON(b []byte) error {
+	type Layer struct {
+		MediaType string `json:"mediaType"`
+		Size      int    `json:"size"`
+		Digest    Digest `json:"digest"`
+	}
+	manifest := struct {
+		Architecture string `json:"architecture"`
+		FsLayers     []struct {
+			BlobSum Digest `json:"blobSum"`
+		} `json:"fsLayers"`
+		History []struct {
+			V1Compatibility string `json:"v1Compatibility"`
+		} `json:"history"`
+		Name          string `json:"name"`
+		SchemaVersion int    `json:"schemaVersion"`
+		Signatures    []struct {
+			Header struct {
+				Alg string `json:"alg"`
+				Jwk struct {
+					Crv string `json:"crv"`
+					Kid string `json:"kid"`
+					Kty string `json:"kty"`
+					X   string `json:"x"`
+					Y   string `json:"y"`
+				} `json:"jwk"`
+			} `json:"header"`
+			Protected string `json:"protected"`
+			Signature string `json:"signature"`
+		} `json:"signatures"`
+		Tag       string `json:"tag"`
+		MediaType string `json:"mediaType"`
+		Config    struct {
+			MediaType string `json:"mediaType"`
+			Size      int    `json:"size"`
+			Digest    Digest `json:"digest"`
+		} `json:"config"`
+		Layers    []Layer `json:"layers"`
+		Manifests []Layer `json:"manifests"`
+	}{}
+
+	m.data = b
+
+	err := json.Unmarshal(b, &manifest)
+	if err != nil {
+		return fmt.Errorf("unmarshal %v", err)
+	}
+
+	switch manifest.SchemaVersion {
+	case 1:
+		m.blobs = make([]Digest, 0)
+		for _, d := range manifest.FsLayers {
+			m.blobs = append(m.blobs, d.BlobSum)
+		}
+
+	case 2:
+		m.config = manifest.Config.Digest
+		m.blobs = make([]Digest, 0)
+		for _, layer := range manifest.Layers {
+			m.blobs = append(m.blobs, layer.Digest)
+		}
+		m.manifests = make([]Digest, 0)
+		for _, child := range manifest.Manifests {
+			m.manifests = append(m.manifests, child.Digest)
+		}
+
+	default:
+		return fmt.Errorf("unknown schema version %d", manifest.SchemaVersion)
+	}
+
+	return nil
+}
+
+func (m *manifestDecoder) ExtractHeaders(hdr *http.Header) {
+	m.digest = Digest(hdr.Get("Docker-Content-Digest"))
+}
+
+// verifyManifest checks manifest data fetched for ref hashes to the digest
+// asked for and to the digest the registry reported, and returns its
+// digest. When ref is a tag and the registry sent no digest, as some
+// proxies do, the sha256 digest is computed. Signed schema 1 manifests
+// are identified by the payload they sign; their signatures are left for
+// VerifySchema1 by callers that use the content.
+func verifyManifest(ref string, reported Digest, data []byte) (Digest, error) {
+	digest := reported
+
+	probe := struct {
+		SchemaVersion int               `json:"schemaVersion"`
+		Signatures    []json.RawMessage `json:"signatures"`
+	}{}
+	if json.Unmarshal(data, &probe) == nil && probe.SchemaVersion == 1 && len(probe.Signatures) > 0 {
+		payload, err := Schema1Payload(data)
+		if err != nil {
+			return "", fmt.Errorf("manifest %s: %w", ref, err)
+		}
+		data = payload
+	}
+
+	if d, err := ParseDigest(ref); err == nil {
+		if err := d.Verify(data); err != nil {
+			return "", fmt.Errorf("manifest %s: %w", ref, err)
+		}
+		digest = d
+	}
+
+	if reported != "" {
+		if err := reported.Verify(data); err != nil {
+			return "", fmt.Errorf("manifest %s: Docker-Content-Digest: %w", ref, err)
+		}
+	}
+
+	if digest == "" {
+		digest = FromBytes(data)
+	}
+
+	return digest, nil
+}
+
+// Blobs returns the digest of the manifest at ref and the layers it
+// references, in manifest order.
+func (c *Client) Blobs(ctx context.Context, repo, ref string) (Digest, []Digest, error) {
+	m := &manifestDecoder{}
+
+	err := c.get(ctx, c.base+"/v2/"+repo+"/manifests/"+ref, m)
+	if err != nil {
+		return "", nil, err
+	}
+
+	digest, err := verifyManifest(ref, m.digest, m.data)
+	if err != nil {
+		return "", nil, err
+	}
+
+	return digest, m.blobs, nil
+}
+
+// Referenced returns the digest of the manifest at ref and every blob it
+// keeps alive: layers, config and the blobs of any manifests it indexes.
+func (c *Client) Referenced(ctx context.Context, repo, ref string) (Digest, []Digest, error) {
+	m := &manifestDecoder{}
+
+	err := c.get(ctx, c.base+"/v2/"+repo+"/manifests/"+ref, m)
+	if err != nil {
+		return "", nil, err
+	}
+
+	digest, err := verifyManifest(ref, m.digest, m.data)
+	if err != nil {
+		return "", nil, err
+	}
+
+	blobs := append([]Digest{}, m.blobs...)
+	if m.config != "" {
+		blobs = append(blobs, m.config)
+	}
+
+	for _, child := range m.manifests {
+		_, cb, err := c.Referenced(ctx, repo, string(child))
+		if err != nil {
+			return "", nil, err
+		}
+
+		blobs = append(blobs, cb...)
+	}
+
+	return digest, blobs, nil
+}
+
+type deleteDecoder struct{}
+
+func (d *deleteDecoder) Method() string { return http.MethodDelete }
+
+func (d *deleteDecoder) SetHeaders(hdr *http.Header) {}
+
+func (d *deleteDecoder) UnmarshalJSON(b []byte) error {
+	return nil
+}
+
+func (d *deleteDecoder) ExtractHeaders(hdr *http.Header) {}
+
+// DeleteManifest deletes the manifest with digest, and with it every tag
+// pointing at it.
+func (c *Client) DeleteManifest(ctx context.Context, repo string, digest Digest) error {
+	return c.get(ctx, c.base+"/v2/"+repo+"/manifests/"+string(digest), &deleteDecoder{})
+}
@@ -0,0 +1,241 @@
+package registry
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	neturl "net/url"
+	"strings"
+)
+
+type uploadDecoder struct {
+	location string
+}
+
+func (u *uploadDecoder) Method() string { return http.MethodPost }
+
+func (u *uploadDecoder) SetHeaders(hdr *http.Header) {}
+
+func (u *uploadDecoder) UnmarshalJSON(b []byte) error {
+	return nil
+}
+
+func (u *uploadDecoder) ExtractHeaders(hdr *http.Header) {
+	u.location = hdr.Get("Location")
+}
+
+type blobPut struct {
+	data   []byte
+	digest string
+}
+
+func (p *blobPut) Method() string { return http.MethodPut }
+
+func (p *blobPut) SetHeaders(hdr *http.Header) {
+	hdr.Set("Content-Type", "application/octet-stream")
+}
+
+func (p *blobPut) Body() []byte { return p.data }
+
+func (p *blobPut) UnmarshalJSON(b []byte) error {
+	return nil
+}
+
+func (p *blobPut) ExtractHeaders(hdr *http.Header) {
+	p.digest = hdr.Get("Docker-Content-Digest")
+}
+
+// PushBlob uploads data to the repository with a monolithic upload unless
+// the registry already holds a blob with the same digest.
+func (c *Client) PushBlob(ctx context.Context, repo string, data []byte) (Digest, error) {
+	digest := FromBytes(data)
+
+	// ask the registry, a cached size may belong to a collected blob
+	if resp, err := c.do(ctx, c.base+"/v2/"+repo+"/blobs/"+string(digest), &blobDecoder{}); err == nil {
+		resp.Body.Close()
+		return digest, nil
+	}
+
+	u := &uploadDecoder{}
+
+	err := c.get(ctx, c.base+"/v2/"+repo+"/blobs/uploads/", u)
+	if err != nil {
+		return "", fmt.Errorf("start upload: %w", err)
+	}
+
+	if err := c.upload(ctx, u.location, digest, data); err != nil {
+		return "", err
+	}
+
+	return digest, nil
+}
+
+// upload completes the upload session at location with data.
+func (c *Client) upload(ctx context.Context, location string, digest Digest, data []byte) error {
+	loc, err := resolve(c.base, location)
+	if err != nil {
+		return err
+	}
+
+	sep := "?"
+	if strings.Contains(loc, "?") {
+		sep = "&"
+	}
+
+	p := &blobPut{data: data}
+
+	err = c.get(ctx, loc+sep+"digest="+neturl.QueryEscape(string(digest)), p)
+	if err != nil {
+		return fmt.Errorf("upload: %w", err)
+	}
+
+	return nil
+}
+
+// CopyBlob makes the blob digest of repository from available in repo. The
+// registry is asked to mount it, and the blob is copied through the client
+// when it declines, as registries do across auth scopes or storage.
+func (c *Client) CopyBlob(ctx context.Context, repo, from string, digest Digest) error {
+	if repo == from {
+		return nil
+	}
+
+	if resp, err := c.do(ctx, c.base+"/v2/"+repo+"/blobs/"+string(digest), &blobDecoder{}); err == nil {
+		resp.Body.Close()
+		return nil
+	}
+
+	u := &uploadDecoder{}
+
+	resp, err := c.do(ctx, c.base+"/v2/"+repo+"/blobs/uploads/?mount="+neturl.QueryEscape(string(digest))+"&from="+neturl.QueryEscape(from), u)
+	if err != nil {
+		return fmt.Errorf("mount: %w", err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode == http.StatusCreated {
+		return nil
+	}
+
+	// declined, the registry started an upload session instead
+	u.ExtractHeaders(&resp.Header)
+
+	blob, err := c.OpenBlob(ctx, from, digest)
+	if err != nil {
+		return err
+	}
+	defer blob.Close()
+
+	data, err := ioutil.ReadAll(blob)
+	if err != nil {
+		return fmt.Errorf("read blob %s: %v", digest, err)
+	}
+
+	if err := digest.Verify(data); err != nil {
+		return fmt.Errorf("blob %s: %w", digest, err)
+	}
+
+	return c.upload(ctx, u.location, digest, data)
+}
+
+// resolve returns the absolute form of a Location header, which registries
+// may send relative to the base URL.
+func resolve(base, location string) (string, error) {
+	if location == "" {
+		return "", fmt.Errorf("upload: no location")
+	}
+
+	b, err := neturl.Parse(base)
+	if err != nil {
+		return "", err
+	}
+
+	l, err := neturl.Parse(location)
+	if err != nil {
+		return "", err
+	}
+
+	return b.ResolveReference(l).String(), nil
+}
+
+type manifestPut struct {
+	mediaType string
+	data      []byte
+	digest    string
+	subject   string
+}
+
+func (p *manifestPut) Method() string { return http.MethodPut }
+
+func (p *manifestPut) SetHeaders(hdr *http.Header) {
+	hdr.Set("Content-Type", p.mediaType)
+}
+
+func (p *manifestPut) Body() []byte { return p.data }
+
+func (p *manifestPut) UnmarshalJSON(b []byte) error {
+	return nil
+}
+
+func (p *manifestPut) ExtractHeaders(hdr *http.Header) {
+	p.digest = hdr.Get("Docker-Content-Digest")
+	p.subject = hdr.Get("OCI-Subject")
+}
+
+// PushManifest stores a manifest under a tag or digest reference and
+// returns its digest.
+func (c *Client) PushManifest(ctx context.Context, repo, ref, mediaType string, data []byte) (Digest, error) {
+	p := &manifestPut{mediaType: mediaType, data: data}
+
+	err := c.get(ctx, c.base+"/v2/"+repo+"/manifests/"+ref, p)
+	if err != nil {
+		return "", err
+	}
+
+	return FromBytes(data), nil
+}
+
+// RawManifest is a manifest as the registry stores it.
+type RawManifest struct {
+	MediaType string
+	Digest    Digest
+	Data      []byte
+}
+
+type rawDecoder struct {
+	m *RawManifest
+}
+
+func (r *rawDecoder) Method() string { return http.MethodGet }
+
+func (r *rawDecoder) SetHeaders(hdr *http.Header) {
+	hdr.Set("Accept", manifestAccept)
+}
+
+func (r *rawDecoder) UnmarshalJSON(b []byte) error {
+	r.m.Data = b
+	return nil
+}
+
+func (r *rawDecoder) ExtractHeaders(hdr *http.Header) {
+	r.m.MediaType = hdr.Get("Content-Type")
+	r.m.Digest = Digest(hdr.Get("Docker-Content-Digest"))
+}
+
+// RawManifest fetches a manifest without interpreting it.
+func (c *Client) RawManifest(ctx context.Context, repo, ref string) (*RawManifest, error) {
+	m := &RawManifest{}
+
+	err := c.get(ctx, c.base+"/v2/"+repo+"/manifests/"+ref, &rawDecoder{m: m})
+	if err != nil {
+		return nil, err
+	}
+
+	m.Digest, err = verifyManifest(ref, m.Digest, m.Data)
+	if err != nil {
+		return nil, err
+	}
+
+	return m, nil
+}
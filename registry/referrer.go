@@ -0,0 +1,115 @@
+package registry
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// PushReferrer stores data as a single layer artifact whose subject is the
+// given manifest. Registries without the referrers API get the fallback
+// sha256-<digest> tag index updated instead.
+func (c *Client) PushReferrer(ctx context.Context, repo string, subject *RawManifest, artifactType, mediaType string, data []byte, annotations map[string]string) (Digest, error) {
+	empty := []byte("{}")
+
+	edigest, err := c.PushBlob(ctx, repo, empty)
+	if err != nil {
+		return "", err
+	}
+
+	ldigest, err := c.PushBlob(ctx, repo, data)
+	if err != nil {
+		return "", err
+	}
+
+	man := &Manifest{
+		SchemaVersion: 2,
+		MediaType:     MediaTypeOCIManifest,
+		ArtifactType:  artifactType,
+		Config: Descriptor{
+			MediaType: MediaTypeOCIEmpty,
+			Size:      int64(len(empty)),
+			Digest:    edigest,
+		},
+		Layers: []Descriptor{{
+			MediaType:   mediaType,
+			Size:        int64(len(data)),
+			Digest:      ldigest,
+			Annotations: annotations,
+		}},
+		Subject: &Descriptor{
+			MediaType: subject.MediaType,
+			Size:      int64(len(subject.Data)),
+			Digest:    subject.Digest,
+		},
+	}
+
+	mdata, err := json.Marshal(man)
+	if err != nil {
+		return "", err
+	}
+
+	digest := FromBytes(mdata)
+
+	p := &manifestPut{mediaType: MediaTypeOCIManifest, data: mdata}
+
+	err = c.get(ctx, c.base+"/v2/"+repo+"/manifests/"+string(digest), p)
+	if err != nil {
+		return "", err
+	}
+
+	if p.subject != "" {
+		return digest, nil
+	}
+
+	desc := Descriptor{
+		MediaType:    MediaTypeOCIManifest,
+		Size:         int64(len(mdata)),
+		Digest:       digest,
+		ArtifactType: artifactType,
+	}
+
+	if err := c.AddReferrer(ctx, repo, subject.Digest, desc); err != nil {
+		return "", err
+	}
+
+	return digest, nil
+}
+
+// AddReferrer appends desc to the referrers tag schema index of subject.
+func (c *Client) AddReferrer(ctx context.Context, repo string, subject Digest, desc Descriptor) error {
+	tag := subject.Tag("")
+
+	index := &Index{
+		SchemaVersion: 2,
+		MediaType:     MediaTypeOCIIndex,
+	}
+
+	old, err := c.RawManifest(ctx, repo, tag)
+	switch {
+	case err == nil:
+		if err := json.Unmarshal(old.Data, index); err != nil {
+			return fmt.Errorf("referrers index: %v", err)
+		}
+	case !errors.Is(err, ErrNotFound):
+		return err
+	}
+
+	for _, d := range index.Manifests {
+		if d.Digest == desc.Digest {
+			return nil
+		}
+	}
+
+	index.Manifests = append(index.Manifests, desc)
+
+	data, err := json.Marshal(index)
+	if err != nil {
+		return err
+	}
+
+	_, err = c.PushManifest(ctx, repo, tag, MediaTypeOCIIndex, data)
+
+	return err
+}
@@ -0,0 +1,202 @@
+package registrytest
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"time"
+
+	"github.com/dbulkow/registry_cmd/registry"
+)
+
+// File is an entry of a layer built by Layer.
+type File struct {
+	Name     string
+	Body     string
+	Mode     int64 // 0644 for files, 0755 for directories when zero
+	Type     byte  // tar.TypeReg when zero
+	Linkname string
+}
+
+// Tar returns an uncompressed tarball of files, in order.
+func Tar(files ...File) []byte {
+	var buf bytes.Buffer
+
+	tw := tar.NewWriter(&buf)
+	for _, f := range files {
+		hdr := &tar.Header{
+			Name:     f.Name,
+			Typeflag: f.Type,
+			Mode:     f.Mode,
+			Size:     int64(len(f.Body)),
+			Linkname: f.Linkname,
+			ModTime:  time.Unix(0, 0),
+		}
+
+		if hdr.Typeflag == 0 {
+			hdr.Typeflag = tar.TypeReg
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			hdr.Size = 0
+		}
+		if hdr.Mode == 0 {
+			hdr.Mode = 0644
+			if hdr.Typeflag == tar.TypeDir {
+				hdr.Mode = 0755
+			}
+		}
+
+		if err := tw.WriteHeader(hdr); err != nil {
+			panic(err)
+		}
+		if hdr.Size > 0 {
+			tw.Write([]byte(f.Body))
+		}
+	}
+	tw.Close()
+
+	return buf.Bytes()
+}
+
+// Layer returns a gzip compressed tarball of files, in order.
+func Layer(files ...File) []byte {
+	var buf bytes.Buffer
+
+	zw := gzip.NewWriter(&buf)
+	zw.Write(Tar(files...))
+	zw.Close()
+
+	return buf.Bytes()
+}
+
+// Image describes an image for PushImage.
+type Image struct {
+	// MediaType is the manifest media type, Docker schema 2 by default.
+	// An OCI manifest gets OCI config and layer media types.
+	MediaType string
+
+	// Platform defaults to linux/amd64.
+	Platform registry.Platform
+
+	Config  registry.ContainerConfig
+	Created string
+
+	// Layers are gzip compressed tarballs, such as built by Layer.
+	Layers [][]byte
+
+	// History defaults to one entry per layer.
+	History []registry.History
+}
+
+// PushImage stores the layers, config and manifest of img in repo, tagged
+// with tag unless it is empty, and returns the manifest descriptor.
+func (r *Registry) PushImage(repo, tag string, img Image) registry.Descriptor {
+	mediaType := img.MediaType
+	if mediaType == "" {
+		mediaType = registry.MediaTypeManifest
+	}
+
+	configType, layerType := registry.MediaTypeDockerConfig, registry.MediaTypeDockerLayer
+	if mediaType == registry.MediaTypeOCIManifest {
+		configType, layerType = registry.MediaTypeOCIConfig, registry.MediaTypeOCILayer
+	}
+
+	platform := img.Platform
+	if platform.OS == "" {
+		platform.OS = "linux"
+	}
+	if platform.Architecture == "" {
+		platform.Architecture = "amd64"
+	}
+
+	config := registry.ImageConfig{
+		Architecture: platform.Architecture,
+		OS:           platform.OS,
+		Variant:      platform.Variant,
+		Created:      img.Created,
+		Config:       img.Config,
+		RootFS:       registry.RootFS{Type: "layers", DiffIDs: []registry.Digest{}},
+		History:      img.History,
+	}
+
+	man := registry.Manifest{
+		SchemaVersion: 2,
+		MediaType:     mediaType,
+		Layers:        []registry.Descriptor{},
+	}
+
+	for i, l := range img.Layers {
+		config.RootFS.DiffIDs = append(config.RootFS.DiffIDs, diffID(l))
+		if img.History == nil {
+			config.History = append(config.History, registry.History{Created: img.Created, CreatedBy: fmt.Sprintf("layer %d", i)})
+		}
+
+		man.Layers = append(man.Layers, registry.Descriptor{
+			MediaType: layerType,
+			Size:      int64(len(l)),
+			Digest:    r.PushBlob(repo, l),
+		})
+	}
+
+	cdata := mustMarshal(config)
+	man.Config = registry.Descriptor{
+		MediaType: configType,
+		Size:      int64(len(cdata)),
+		Digest:    r.PushBlob(repo, cdata),
+	}
+
+	mdata := mustMarshal(man)
+
+	return registry.Descriptor{
+		MediaType: mediaType,
+		Size:      int64(len(mdata)),
+		Digest:    r.PushManifest(repo, tag, mediaType, mdata),
+		Platform:  &platform,
+	}
+}
+
+// PushIndex stores a manifest list, or an OCI index when mediaType is
+// registry.MediaTypeOCIIndex, of manifests already in repo.
+func (r *Registry) PushIndex(repo, tag, mediaType string, manifests ...registry.Descriptor) registry.Descriptor {
+	if mediaType == "" {
+		mediaType = registry.MediaTypeManifestList
+	}
+
+	data := mustMarshal(registry.Index{
+		SchemaVersion: 2,
+		MediaType:     mediaType,
+		Manifests:     manifests,
+	})
+
+	return registry.Descriptor{
+		MediaType: mediaType,
+		Size:      int64(len(data)),
+		Digest:    r.PushManifest(repo, tag, mediaType, data),
+	}
+}
+
+// diffID returns the digest of the uncompressed content of a layer.
+func diffID(layer []byte) registry.Digest {
+	zr, err := gzip.NewReader(bytes.NewReader(layer))
+	if err != nil {
+		return registry.FromBytes(layer)
+	}
+
+	data, err := ioutil.ReadAll(zr)
+	if err != nil {
+		panic(err)
+	}
+
+	return registry.FromBytes(data)
+}
+
+func mustMarshal(v interface{}) []byte {
+	data, err := json.Marshal(v)
+	if err != nil {
+		panic(err)
+	}
+	return data
+}
@@ -0,0 +1,967 @@
+// Package registrytest provides an in-process fake registry implementing
+// the distribution API, for tests that must not touch the network.
+//
+//	reg := registrytest.New(registrytest.WithPageSize(2))
+//	defer reg.Close()
+//
+//	reg.PushImage("team/app", "1.0", registrytest.Image{
+//		Layers: [][]byte{registrytest.Layer(registrytest.File{Name: "etc/hostname", Body: "app\n"})},
+//	})
+//
+//	c, _ := registry.New(reg.URL)
+//
+// The fake serves the catalog and tag lists with pagination, manifests of
+// every media type, blob HEAD, GET and upload, manifest deletion and the
+// referrers API. It can challenge for basic or token authentication,
+// redirect blob downloads to a storage server and fail requests on demand.
+package registrytest
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/dbulkow/registry_cmd/registry"
+)
+
+// Registry is a fake registry served over HTTP on the loopback interface.
+type Registry struct {
+	*httptest.Server
+
+	mu        sync.Mutex
+	repos     map[string]*repository
+	blobs     map[registry.Digest][]byte
+	uploads   map[string]*upload
+	failures  []*failure
+	tokens    map[string]string // token -> repository it grants, "" for the catalog
+	requests  []string
+	pageSize  int
+	auth      string // "", basic or token
+	username  string
+	password  string
+	noDelete  bool
+	noDigest  bool
+	noMount   bool
+	referrers bool
+
+	// storage serves blobs redirected to it, as object storage would
+	storage     *httptest.Server
+	storageAuth []string
+}
+
+type repository struct {
+	manifests map[registry.Digest]*manifest
+	tags      map[string]registry.Digest
+	blobs     map[registry.Digest]bool
+}
+
+type manifest struct {
+	mediaType string
+	data      []byte
+}
+
+type upload struct {
+	repo string
+	data []byte
+}
+
+type failure struct {
+	method string
+	path   *regexp.Regexp
+	status int
+	times  int // failures left, 0 for always and -1 once used up
+	after  int // matching requests to serve before failing
+}
+
+// Option configures a Registry.
+type Option func(*Registry)
+
+// WithPageSize limits catalog and tag list responses to n entries unless
+// the client asks for another page size.
+func WithPageSize(n int) Option {
+	return func(r *Registry) { r.pageSize = n }
+}
+
+// WithBasicAuth requires basic authentication as username and password.
+func WithBasicAuth(username, password string) Option {
+	return func(r *Registry) { r.auth, r.username, r.password = "basic", username, password }
+}
+
+// WithTokenAuth requires bearer tokens issued by the fake token service at
+// /token, which authenticates as username and password. An empty username
+// issues tokens to anyone.
+func WithTokenAuth(username, password string) Option {
+	return func(r *Registry) { r.auth, r.username, r.password = "token", username, password }
+}
+
+// WithoutDelete refuses manifest deletion as registries do when it is
+// disabled.
+func WithoutDelete() Option {
+	return func(r *Registry) { r.noDelete = true }
+}
+
+// WithoutReferrers serves no referrers API, so clients fall back to the
+// referrers tag schema.
+func WithoutReferrers() Option {
+	return func(r *Registry) { r.referrers = false }
+}
+
+// WithoutDigestHeader omits Docker-Content-Digest from manifest and blob
+// responses, as some proxies do.
+func WithoutDigestHeader() Option {
+	return func(r *Registry) { r.noDigest = true }
+}
+
+// WithoutMount declines cross repository blob mounts, so clients upload
+// the blob instead.
+func WithoutMount() Option {
+	return func(r *Registry) { r.noMount = true }
+}
+
+// WithBlobStorage redirects blob downloads to a second server on another
+// port, as registries backed by object storage do. StorageAuth reports
+// what it was sent.
+func WithBlobStorage() Option {
+	return func(r *Registry) {
+		r.storage = httptest.NewServer(http.HandlerFunc(r.serveStorage))
+	}
+}
+
+// New starts a fake registry. Close it when done.
+func New(opts ...Option) *Registry {
+	r := &Registry{
+		repos:     make(map[string]*repository),
+		blobs:     make(map[registry.Digest][]byte),
+		uploads:   make(map[string]*upload),
+		tokens:    make(map[string]string),
+		referrers: true,
+	}
+
+	for _, opt := range opts {
+		opt(r)
+	}
+
+	r.Server = httptest.NewServer(http.HandlerFunc(r.serve))
+
+	return r
+}
+
+// Close shuts the registry and its blob storage down.
+func (r *Registry) Close() {
+	r.Server.Close()
+	if r.storage != nil {
+		r.storage.Close()
+	}
+}
+
+// StorageAuth returns the Authorization header of every request to the
+// blob storage, empty where there was none.
+func (r *Registry) StorageAuth() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return append([]string(nil), r.storageAuth...)
+}
+
+// Host returns the host:port of the registry, for image references.
+func (r *Registry) Host() string {
+	return strings.TrimPrefix(r.URL, "http://")
+}
+
+// Fail makes the next times requests with method whose path matches the
+// regular expression path fail with status. Times of zero or less fails
+// every such request. An empty method matches any method.
+func (r *Registry) Fail(method, path string, status, times int) {
+	if times < 0 {
+		times = 0
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.failures = append(r.failures, &failure{
+		method: method,
+		path:   regexp.MustCompile(path),
+		status: status,
+		times:  times,
+	})
+}
+
+// FailAfter is Fail, except the first after matching requests are served
+// normally.
+func (r *Registry) FailAfter(method, path string, status, times, after int) {
+	r.Fail(method, path, status, times)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.failures[len(r.failures)-1].after = after
+}
+
+// Requests returns the requests served so far as "METHOD path?query".
+func (r *Registry) Requests() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return append([]string(nil), r.requests...)
+}
+
+// Count returns how many requests with method had a path matching the
+// regular expression path.
+func (r *Registry) Count(method, path string) int {
+	re := regexp.MustCompile(path)
+
+	n := 0
+	for _, req := range r.Requests() {
+		m, p := splitRequest(req)
+		if (method == "" || m == method) && re.MatchString(p) {
+			n++
+		}
+	}
+
+	return n
+}
+
+func splitRequest(req string) (string, string) {
+	i := strings.IndexByte(req, ' ')
+	p := req[i+1:]
+	if j := strings.IndexByte(p, '?'); j >= 0 {
+		p = p[:j]
+	}
+	return req[:i], p
+}
+
+// PushBlob stores data in repo and returns its digest.
+func (r *Registry) PushBlob(repo string, data []byte) registry.Digest {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	digest := registry.FromBytes(data)
+	r.storeBlob(repo, digest, data)
+
+	return digest
+}
+
+// PushManifest stores a manifest in repo, tagged with tag unless it is
+// empty, and returns its digest. Unlike a push over HTTP, the blobs it
+// references are not checked.
+func (r *Registry) PushManifest(repo, tag, mediaType string, data []byte) registry.Digest {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	digest := registry.FromBytes(data)
+	r.storeManifest(repo, tag, digest, mediaType, data)
+
+	return digest
+}
+
+// Manifest returns the media type and content of the manifest at ref, a
+// tag or digest.
+func (r *Registry) Manifest(repo, ref string) (string, []byte, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	m, _ := r.lookup(repo, ref)
+	if m == nil {
+		return "", nil, false
+	}
+
+	return m.mediaType, m.data, true
+}
+
+// Tamper replaces the content served for the manifest at ref, keeping
+// its digest, as a corrupting mirror would.
+func (r *Registry) Tamper(repo, ref string, data []byte) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if m, _ := r.lookup(repo, ref); m != nil {
+		m.data = data
+	}
+}
+
+// TamperBlob replaces the content served for a blob, keeping its digest.
+func (r *Registry) TamperBlob(digest registry.Digest, data []byte) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.blobs[digest]; ok {
+		r.blobs[digest] = data
+	}
+}
+
+// Blob returns the content of a blob in repo.
+func (r *Registry) Blob(repo string, digest registry.Digest) ([]byte, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	rp := r.repos[repo]
+	if rp == nil || !rp.blobs[digest] {
+		return nil, false
+	}
+
+	return r.blobs[digest], true
+}
+
+// Tags returns the sorted tags of repo.
+func (r *Registry) Tags(repo string) []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	rp := r.repos[repo]
+	if rp == nil {
+		return nil
+	}
+
+	return sortedTags(rp)
+}
+
+func (r *Registry) repo(name string) *repository {
+	rp := r.repos[name]
+	if rp == nil {
+		rp = &repository{
+			manifests: make(map[registry.Digest]*manifest),
+			tags:      make(map[string]registry.Digest),
+			blobs:     make(map[registry.Digest]bool),
+		}
+		r.repos[name] = rp
+	}
+	return rp
+}
+
+func (r *Registry) storeBlob(repo string, digest registry.Digest, data []byte) {
+	r.blobs[digest] = data
+	r.repo(repo).blobs[digest] = true
+}
+
+func (r *Registry) storeManifest(repo, tag string, digest registry.Digest, mediaType string, data []byte) {
+	rp := r.repo(repo)
+	rp.manifests[digest] = &manifest{mediaType: mediaType, data: data}
+	if tag != "" {
+		rp.tags[tag] = digest
+	}
+}
+
+// lookup returns the manifest at ref and its digest.
+func (r *Registry) lookup(repo, ref string) (*manifest, registry.Digest) {
+	rp := r.repos[repo]
+	if rp == nil {
+		return nil, ""
+	}
+
+	digest := registry.Digest(ref)
+	if d, ok := rp.tags[ref]; ok {
+		digest = d
+	}
+
+	return rp.manifests[digest], digest
+}
+
+func sortedTags(rp *repository) []string {
+	tags := make([]string, 0, len(rp.tags))
+	for t := range rp.tags {
+		tags = append(tags, t)
+	}
+	sort.Strings(tags)
+	return tags
+}
+
+// digestOf computes the digest of data with the algorithm of want.
+func digestOf(want registry.Digest, data []byte) registry.Digest {
+	var h hash.Hash
+	switch want.Algorithm() {
+	case "sha512":
+		h = sha512.New()
+	default:
+		h = sha256.New()
+	}
+	h.Write(data)
+
+	alg := want.Algorithm()
+	if alg == "" {
+		alg = "sha256"
+	}
+
+	return registry.Digest(alg + ":" + hex.EncodeToString(h.Sum(nil)))
+}
+
+func randomID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// writeError sends a distribution error response.
+func writeError(w http.ResponseWriter, status int, code registry.ErrorCode, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"errors": []registry.ErrorDetail{{Code: code, Message: message}},
+	})
+}
+
+// statusCode returns the error code a registry sends with status.
+func statusCode(status int) registry.ErrorCode {
+	switch status {
+	case http.StatusUnauthorized:
+		return registry.ErrUnauthorized
+	case http.StatusForbidden:
+		return registry.ErrDenied
+	case http.StatusNotFound:
+		return registry.ErrNameUnknown
+	case http.StatusMethodNotAllowed:
+		return registry.ErrUnsupported
+	case http.StatusTooManyRequests:
+		return registry.ErrTooManyRequests
+	}
+	return registry.ErrUnknown
+}
+
+func (r *Registry) serve(w http.ResponseWriter, req *http.Request) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.requests = append(r.requests, req.Method+" "+req.URL.RequestURI())
+
+	if req.URL.Path == "/token" {
+		r.serveToken(w, req)
+		return
+	}
+
+	for _, f := range r.failures {
+		if f.times == -1 || (f.method != "" && f.method != req.Method) || !f.path.MatchString(req.URL.Path) {
+			continue
+		}
+
+		if f.after > 0 {
+			f.after--
+			continue
+		}
+
+		switch {
+		case f.times == 1:
+			f.times = -1
+		case f.times > 1:
+			f.times--
+		}
+
+		if f.status == http.StatusTooManyRequests {
+			w.Header().Set("Retry-After", "0")
+		}
+		writeError(w, f.status, statusCode(f.status), "injected failure")
+		return
+	}
+
+	if !strings.HasPrefix(req.URL.Path, "/v2/") {
+		http.NotFound(w, req)
+		return
+	}
+
+	name, kind, ref := route(req.URL.Path)
+
+	if !r.authorized(w, req, name, kind) {
+		return
+	}
+
+	w.Header().Set("Docker-Distribution-API-Version", "registry/2.0")
+
+	switch kind {
+	case "base":
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte("{}"))
+	case "catalog":
+		r.serveCatalog(w, req)
+	case "tags":
+		r.serveTags(w, req, name)
+	case "manifests":
+		r.serveManifest(w, req, name, ref)
+	case "blobs":
+		r.serveBlob(w, req, name, registry.Digest(ref))
+	case "uploads":
+		r.serveUpload(w, req, name, ref)
+	case "referrers":
+		r.serveReferrers(w, req, name, registry.Digest(ref))
+	default:
+		writeError(w, http.StatusNotFound, registry.ErrUnsupported, "unknown endpoint")
+	}
+}
+
+// route splits a /v2/ path into repository name, endpoint and reference.
+func route(path string) (string, string, string) {
+	switch path {
+	case "/v2/":
+		return "", "base", ""
+	case "/v2/_catalog":
+		return "", "catalog", ""
+	}
+
+	p := strings.TrimPrefix(path, "/v2/")
+
+	if strings.HasSuffix(p, "/tags/list") {
+		return strings.TrimSuffix(p, "/tags/list"), "tags", ""
+	}
+
+	for _, ep := range []string{"/blobs/uploads/", "/blobs/uploads", "/manifests/", "/blobs/", "/referrers/"} {
+		if i := strings.LastIndex(p, ep); i > 0 {
+			kind := strings.Trim(ep, "/")
+			if kind == "blobs/uploads" {
+				kind = "uploads"
+			}
+			return p[:i], kind, p[i+len(ep):]
+		}
+	}
+
+	return "", "", ""
+}
+
+// authorized checks the credentials of a request, challenging for them
+// when they are missing or wrong.
+func (r *Registry) authorized(w http.ResponseWriter, req *http.Request, name, kind string) bool {
+	switch r.auth {
+	case "basic":
+		user, pass, ok := req.BasicAuth()
+		if ok && user == r.username && pass == r.password {
+			return true
+		}
+		w.Header().Set("WWW-Authenticate", `Basic realm="registrytest"`)
+
+	case "token":
+		hdr := req.Header.Get("Authorization")
+		if strings.HasPrefix(hdr, "Bearer ") {
+			if repo, ok := r.tokens[strings.TrimPrefix(hdr, "Bearer ")]; ok && (repo == name || kind == "base") {
+				return true
+			}
+		}
+
+		scope := "repository:" + name + ":pull,push"
+		if kind == "catalog" || kind == "base" {
+			scope = "registry:catalog:*"
+		}
+		w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="%s/token",service="registrytest",scope="%s"`, r.URL, scope))
+
+	default:
+		return true
+	}
+
+	writeError(w, http.StatusUnauthorized, registry.ErrUnauthorized, "authentication required")
+	return false
+}
+
+// serveToken issues a token for the scope requested.
+func (r *Registry) serveToken(w http.ResponseWriter, req *http.Request) {
+	if r.username != "" {
+		user, pass, ok := req.BasicAuth()
+		if !ok || user != r.username || pass != r.password {
+			writeError(w, http.StatusUnauthorized, registry.ErrUnauthorized, "bad credentials")
+			return
+		}
+	}
+
+	repo := ""
+	if scope := req.URL.Query().Get("scope"); strings.HasPrefix(scope, "repository:") {
+		repo = strings.TrimPrefix(scope, "repository:")
+		if i := strings.LastIndexByte(repo, ':'); i >= 0 {
+			repo = repo[:i]
+		}
+	}
+
+	token := randomID()
+	r.tokens[token] = repo
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"token": token})
+}
+
+// page returns the entries of a list after the last query parameter, at
+// most n of them, and the Link header value when entries remain.
+func (r *Registry) page(req *http.Request, entries []string) ([]string, string) {
+	q := req.URL.Query()
+
+	if last := q.Get("last"); last != "" {
+		i := sort.SearchStrings(entries, last)
+		if i < len(entries) && entries[i] == last {
+			i++
+		}
+		entries = entries[i:]
+	}
+
+	n := r.pageSize
+	if s := q.Get("n"); s != "" {
+		n, _ = strconv.Atoi(s)
+	}
+
+	if n <= 0 || len(entries) <= n {
+		return entries, ""
+	}
+
+	entries = entries[:n]
+
+	next := *req.URL
+	nq := next.Query()
+	nq.Set("last", entries[n-1])
+	nq.Set("n", strconv.Itoa(n))
+	next.RawQuery = nq.Encode()
+
+	return entries, fmt.Sprintf(`<%s>; rel="next"`, next.RequestURI())
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+func (r *Registry) serveCatalog(w http.ResponseWriter, req *http.Request) {
+	names := make([]string, 0, len(r.repos))
+	for name, rp := range r.repos {
+		if len(rp.manifests) > 0 {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	names, link := r.page(req, names)
+	if link != "" {
+		w.Header().Set("Link", link)
+	}
+
+	writeJSON(w, map[string]interface{}{"repositories": names})
+}
+
+func (r *Registry) serveTags(w http.ResponseWriter, req *http.Request, name string) {
+	rp := r.repos[name]
+	if rp == nil {
+		writeError(w, http.StatusNotFound, registry.ErrNameUnknown, "repository name not known to registry")
+		return
+	}
+
+	tags, link := r.page(req, sortedTags(rp))
+	if link != "" {
+		w.Header().Set("Link", link)
+	}
+
+	writeJSON(w, map[string]interface{}{"name": name, "tags": tags})
+}
+
+func (r *Registry) serveManifest(w http.ResponseWriter, req *http.Request, name, ref string) {
+	switch req.Method {
+	case http.MethodGet, http.MethodHead:
+		m, digest := r.lookup(name, ref)
+		if m == nil {
+			writeError(w, http.StatusNotFound, registry.ErrManifestUnknown, "manifest unknown")
+			return
+		}
+
+		etag := `"` + string(digest) + `"`
+
+		w.Header().Set("Content-Type", m.mediaType)
+		if !r.noDigest {
+			w.Header().Set("Docker-Content-Digest", string(digest))
+		}
+		w.Header().Set("ETag", etag)
+
+		if req.Header.Get("If-None-Match") == etag {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+
+		w.Header().Set("Content-Length", strconv.Itoa(len(m.data)))
+		if req.Method == http.MethodGet {
+			w.Write(m.data)
+		}
+
+	case http.MethodPut:
+		r.putManifest(w, req, name, ref)
+
+	case http.MethodDelete:
+		if r.noDelete {
+			writeError(w, http.StatusMethodNotAllowed, registry.ErrUnsupported, "The operation is unsupported.")
+			return
+		}
+
+		if _, err := registry.ParseDigest(ref); err != nil {
+			writeError(w, http.StatusBadRequest, registry.ErrDigestInvalid, "manifests are deleted by digest")
+			return
+		}
+
+		m, digest := r.lookup(name, ref)
+		if m == nil {
+			writeError(w, http.StatusNotFound, registry.ErrManifestUnknown, "manifest unknown")
+			return
+		}
+
+		rp := r.repos[name]
+		delete(rp.manifests, digest)
+		for tag, d := range rp.tags {
+			if d == digest {
+				delete(rp.tags, tag)
+			}
+		}
+
+		w.WriteHeader(http.StatusAccepted)
+
+	default:
+		writeError(w, http.StatusMethodNotAllowed, registry.ErrUnsupported, "method not allowed")
+	}
+}
+
+func (r *Registry) putManifest(w http.ResponseWriter, req *http.Request, name, ref string) {
+	data, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, registry.ErrManifestInvalid, err.Error())
+		return
+	}
+
+	mediaType := req.Header.Get("Content-Type")
+	if mediaType == "" {
+		writeError(w, http.StatusBadRequest, registry.ErrManifestInvalid, "no content type")
+		return
+	}
+
+	var probe struct {
+		SchemaVersion int                   `json:"schemaVersion"`
+		Config        *registry.Descriptor  `json:"config"`
+		Layers        []registry.Descriptor `json:"layers"`
+		Manifests     []registry.Descriptor `json:"manifests"`
+		Subject       *registry.Descriptor  `json:"subject"`
+	}
+
+	if err := json.Unmarshal(data, &probe); err != nil {
+		writeError(w, http.StatusBadRequest, registry.ErrManifestInvalid, err.Error())
+		return
+	}
+
+	rp := r.repo(name)
+
+	if probe.SchemaVersion == 2 {
+		blobs := append([]registry.Descriptor(nil), probe.Layers...)
+		if probe.Config != nil {
+			blobs = append(blobs, *probe.Config)
+		}
+
+		for _, b := range blobs {
+			if !rp.blobs[b.Digest] {
+				writeError(w, http.StatusBadRequest, registry.ErrManifestBlobUnknown, "blob unknown: "+string(b.Digest))
+				return
+			}
+		}
+
+		for _, m := range probe.Manifests {
+			if rp.manifests[m.Digest] == nil {
+				writeError(w, http.StatusBadRequest, registry.ErrManifestUnknown, "manifest unknown: "+string(m.Digest))
+				return
+			}
+		}
+	}
+
+	digest := registry.FromBytes(data)
+
+	tag := ref
+	if want, err := registry.ParseDigest(ref); err == nil {
+		if digest = digestOf(want, data); digest != want {
+			writeError(w, http.StatusBadRequest, registry.ErrDigestInvalid, "digest does not match content")
+			return
+		}
+		tag = ""
+	}
+
+	r.storeManifest(name, tag, digest, mediaType, data)
+
+	if probe.Subject != nil && r.referrers {
+		w.Header().Set("OCI-Subject", string(probe.Subject.Digest))
+	}
+
+	w.Header().Set("Location", "/v2/"+name+"/manifests/"+string(digest))
+	w.Header().Set("Docker-Content-Digest", string(digest))
+	w.WriteHeader(http.StatusCreated)
+}
+
+func (r *Registry) serveBlob(w http.ResponseWriter, req *http.Request, name string, digest registry.Digest) {
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		writeError(w, http.StatusMethodNotAllowed, registry.ErrUnsupported, "method not allowed")
+		return
+	}
+
+	rp := r.repos[name]
+	if rp == nil || !rp.blobs[digest] {
+		writeError(w, http.StatusNotFound, registry.ErrBlobUnknown, "blob unknown to registry")
+		return
+	}
+
+	if r.storage != nil && req.Method == http.MethodGet {
+		http.Redirect(w, req, r.storage.URL+"/"+string(digest)+"?signature=x", http.StatusTemporaryRedirect)
+		return
+	}
+
+	data := r.blobs[digest]
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+	if !r.noDigest {
+		w.Header().Set("Docker-Content-Digest", string(digest))
+	}
+	w.Header().Set("ETag", `"`+string(digest)+`"`)
+
+	if req.Method == http.MethodGet {
+		w.Write(data)
+	}
+}
+
+func (r *Registry) serveStorage(w http.ResponseWriter, req *http.Request) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.storageAuth = append(r.storageAuth, req.Header.Get("Authorization"))
+
+	data, ok := r.blobs[registry.Digest(strings.TrimPrefix(req.URL.Path, "/"))]
+	if !ok {
+		http.NotFound(w, req)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+	w.Write(data)
+}
+
+func (r *Registry) serveUpload(w http.ResponseWriter, req *http.Request, name, id string) {
+	body, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, registry.ErrBlobUploadInvalid, err.Error())
+		return
+	}
+
+	q := req.URL.Query()
+
+	if req.Method == http.MethodPost {
+		if from, mount := q.Get("from"), registry.Digest(q.Get("mount")); mount != "" && !r.noMount {
+			if src := r.repos[from]; src != nil && src.blobs[mount] {
+				r.repo(name).blobs[mount] = true
+				r.blobCreated(w, name, mount)
+				return
+			}
+		}
+
+		if d := q.Get("digest"); d != "" {
+			r.finishUpload(w, name, d, body)
+			return
+		}
+
+		id = randomID()
+		r.uploads[id] = &upload{repo: name, data: body}
+
+		w.Header().Set("Location", "/v2/"+name+"/blobs/uploads/"+id)
+		w.Header().Set("Docker-Upload-UUID", id)
+		w.Header().Set("Range", "0-0")
+		w.WriteHeader(http.StatusAccepted)
+		return
+	}
+
+	u := r.uploads[id]
+	if u == nil || u.repo != name {
+		writeError(w, http.StatusNotFound, registry.ErrBlobUploadUnknown, "blob upload unknown to registry")
+		return
+	}
+
+	u.data = append(u.data, body...)
+
+	switch req.Method {
+	case http.MethodPatch:
+		w.Header().Set("Location", "/v2/"+name+"/blobs/uploads/"+id)
+		w.Header().Set("Docker-Upload-UUID", id)
+		w.Header().Set("Range", fmt.Sprintf("0-%d", len(u.data)-1))
+		w.WriteHeader(http.StatusAccepted)
+
+	case http.MethodPut:
+		delete(r.uploads, id)
+		r.finishUpload(w, name, q.Get("digest"), u.data)
+
+	case http.MethodDelete:
+		delete(r.uploads, id)
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		writeError(w, http.StatusMethodNotAllowed, registry.ErrUnsupported, "method not allowed")
+	}
+}
+
+func (r *Registry) finishUpload(w http.ResponseWriter, name, want string, data []byte) {
+	digest, err := registry.ParseDigest(want)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, registry.ErrDigestInvalid, err.Error())
+		return
+	}
+
+	if digestOf(digest, data) != digest {
+		writeError(w, http.StatusBadRequest, registry.ErrDigestInvalid, "digest does not match content")
+		return
+	}
+
+	r.storeBlob(name, digest, data)
+	r.blobCreated(w, name, digest)
+}
+
+func (r *Registry) blobCreated(w http.ResponseWriter, name string, digest registry.Digest) {
+	w.Header().Set("Location", "/v2/"+name+"/blobs/"+string(digest))
+	w.Header().Set("Docker-Content-Digest", string(digest))
+	w.WriteHeader(http.StatusCreated)
+}
+
+func (r *Registry) serveReferrers(w http.ResponseWriter, req *http.Request, name string, subject registry.Digest) {
+	if !r.referrers {
+		writeError(w, http.StatusNotFound, registry.ErrUnsupported, "referrers API not supported")
+		return
+	}
+
+	filter := req.URL.Query().Get("artifactType")
+
+	index := registry.Index{
+		SchemaVersion: 2,
+		MediaType:     registry.MediaTypeOCIIndex,
+		Manifests:     []registry.Descriptor{},
+	}
+
+	if rp := r.repos[name]; rp != nil {
+		for digest, m := range rp.manifests {
+			var man registry.Manifest
+			if json.Unmarshal(m.data, &man) != nil || man.Subject == nil || man.Subject.Digest != subject {
+				continue
+			}
+
+			artifactType := man.ArtifactType
+			if artifactType == "" {
+				artifactType = man.Config.MediaType
+			}
+			if filter != "" && artifactType != filter {
+				continue
+			}
+
+			index.Manifests = append(index.Manifests, registry.Descriptor{
+				MediaType:    m.mediaType,
+				Size:         int64(len(m.data)),
+				Digest:       digest,
+				ArtifactType: artifactType,
+				Annotations:  man.Annotations,
+			})
+		}
+	}
+
+	sort.Slice(index.Manifests, func(i, j int) bool {
+		return index.Manifests[i].Digest < index.Manifests[j].Digest
+	})
+
+	w.Header().Set("Content-Type", registry.MediaTypeOCIIndex)
+	json.NewEncoder(w).Encode(index)
+}
@@ -0,0 +1,151 @@
+package registrytest
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+
+	"github.com/dbulkow/registry_cmd/registry"
+)
+
+// PushSchema1 stores the layers and a legacy schema 1 manifest of img in
+// repo, tagged with tag, and returns the manifest descriptor. The manifest
+// is signed with key as libtrust signs, or left unsigned when key is nil.
+// History entries marked EmptyLayer become throwaway entries; the layers
+// go to the other entries in order.
+func (r *Registry) PushSchema1(repo, tag string, img Image, key *ecdsa.PrivateKey) registry.Descriptor {
+	platform := img.Platform
+	if platform.OS == "" {
+		platform.OS = "linux"
+	}
+	if platform.Architecture == "" {
+		platform.Architecture = "amd64"
+	}
+
+	history := img.History
+	if history == nil {
+		for i := range img.Layers {
+			history = append(history, registry.History{Created: img.Created, CreatedBy: fmt.Sprintf("layer %d", i)})
+		}
+	}
+
+	man := registry.Schema1{
+		SchemaVersion: 1,
+		Name:          repo,
+		Tag:           tag,
+		Architecture:  platform.Architecture,
+	}
+
+	empty := Layer()
+	layers := img.Layers
+	parent := ""
+
+	for i, h := range history {
+		layer := empty
+		if !h.EmptyLayer {
+			layer, layers = layers[0], layers[1:]
+		}
+
+		id := fmt.Sprintf("%x", sha256.Sum256([]byte(fmt.Sprint(repo, tag, i))))
+
+		v1 := map[string]interface{}{
+			"id":               id,
+			"created":          h.Created,
+			"container_config": map[string]interface{}{"Cmd": []string{"/bin/sh", "-c", h.CreatedBy}},
+		}
+		if parent != "" {
+			v1["parent"] = parent
+		}
+		if h.Author != "" {
+			v1["author"] = h.Author
+		}
+		if h.EmptyLayer {
+			v1["throwaway"] = true
+		}
+		if i == len(history)-1 {
+			v1["architecture"] = platform.Architecture
+			v1["os"] = platform.OS
+			v1["config"] = img.Config
+		}
+		parent = id
+
+		// top layer first
+		man.FSLayers = append([]registry.Schema1Layer{{BlobSum: r.PushBlob(repo, layer)}}, man.FSLayers...)
+		man.History = append([]registry.Schema1History{{V1Compatibility: string(mustMarshal(v1))}}, man.History...)
+	}
+
+	payload, err := json.MarshalIndent(man, "", "   ")
+	if err != nil {
+		panic(err)
+	}
+
+	mediaType, data := registry.MediaTypeSchema1, payload
+	if key != nil {
+		mediaType, data = registry.MediaTypeSignedSchema1, SignSchema1(payload, key)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	digest := registry.FromBytes(payload)
+	r.storeManifest(repo, tag, digest, mediaType, data)
+
+	return registry.Descriptor{MediaType: mediaType, Size: int64(len(data)), Digest: digest}
+}
+
+// SignSchema1 adds an ES256 libtrust signature by key to the pretty
+// printed schema 1 manifest payload.
+func SignSchema1(payload []byte, key *ecdsa.PrivateKey) []byte {
+	enc := base64.RawURLEncoding
+
+	// the signatures go in before the closing brace
+	n := bytes.LastIndex(payload, []byte("\n}"))
+	tail := payload[n:]
+
+	protected := enc.EncodeToString(mustMarshal(map[string]interface{}{
+		"formatLength": n,
+		"formatTail":   enc.EncodeToString(tail),
+		"time":         "2016-01-01T00:00:00Z",
+	}))
+
+	sum := sha256.Sum256([]byte(protected + "." + enc.EncodeToString(payload)))
+
+	rs, ss, err := ecdsa.Sign(rand.Reader, key, sum[:])
+	if err != nil {
+		panic(err)
+	}
+
+	sig := make([]byte, 64)
+	rs.FillBytes(sig[:32])
+	ss.FillBytes(sig[32:])
+
+	x, y := make([]byte, 32), make([]byte, 32)
+	key.X.FillBytes(x)
+	key.Y.FillBytes(y)
+
+	jws := []registry.JWS{{
+		Header: registry.JWSHeader{
+			JWK: &registry.JWK{Kty: "EC", Crv: "P-256", X: enc.EncodeToString(x), Y: enc.EncodeToString(y)},
+			Alg: "ES256",
+		},
+		Signature: enc.EncodeToString(sig),
+		Protected: protected,
+	}}
+
+	sigs, err := json.MarshalIndent(jws, "   ", "   ")
+	if err != nil {
+		panic(err)
+	}
+
+	var buf bytes.Buffer
+	buf.Write(payload[:n])
+	buf.WriteString(",\n   \"signatures\": ")
+	buf.Write(sigs)
+	buf.Write(tail)
+
+	return buf.Bytes()
+}
@@ -0,0 +1,205 @@
+package registry
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const (
+	backoffBase = 500 * time.Millisecond
+	backoffMax  = 30 * time.Second
+)
+
+// limiter spaces requests evenly to stay under a requests per second
+// budget. A nil *limiter does not limit.
+type limiter struct {
+	mu       sync.Mutex
+	interval time.Duration
+	next     time.Time
+}
+
+func newLimiter(rps float64) *limiter {
+	if rps <= 0 {
+		return nil
+	}
+	return &limiter{interval: time.Duration(float64(time.Second) / rps)}
+}
+
+// wait blocks until the next request may be sent.
+func (l *limiter) wait(ctx context.Context) error {
+	if l == nil {
+		return nil
+	}
+
+	l.mu.Lock()
+	now := time.Now()
+	if l.next.Before(now) {
+		l.next = now
+	}
+	d := l.next.Sub(now)
+	l.next = l.next.Add(l.interval)
+	l.mu.Unlock()
+
+	return sleep(ctx, d)
+}
+
+// pause holds back every request until t, for when the registry asks us
+// to slow down.
+func (l *limiter) pause(t time.Time) {
+	if l == nil {
+		return
+	}
+
+	l.mu.Lock()
+	if l.next.Before(t) {
+		l.next = t
+	}
+	l.mu.Unlock()
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+
+	t := time.NewTimer(d)
+	defer t.Stop()
+
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// backoff returns the delay before retry n, exponential with jitter.
+func backoff(n int) time.Duration {
+	d := backoffBase << uint(n)
+	if d <= 0 || d > backoffMax {
+		d = backoffMax
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+// retryAfter parses a Retry-After header given in seconds or as a date.
+func retryAfter(hdr string) (time.Duration, bool) {
+	if hdr == "" {
+		return 0, false
+	}
+
+	if secs, err := strconv.Atoi(hdr); err == nil && secs >= 0 {
+		return time.Duration(secs) * time.Second, true
+	}
+
+	if t, err := http.ParseTime(hdr); err == nil {
+		return time.Until(t), true
+	}
+
+	return 0, false
+}
+
+func retryableStatus(code int) bool {
+	switch code {
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// retryableError reports whether a transport error is worth retrying:
+// connection resets, dropped connections and timeouts of a single request.
+func retryableError(err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+
+	if errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.ECONNREFUSED) ||
+		errors.Is(err, syscall.EPIPE) || errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, io.EOF) {
+		return true
+	}
+
+	var neterr net.Error
+	return errors.As(err, &neterr) && neterr.Timeout()
+}
+
+// replayable reports whether req may be sent again after a transport
+// error, when it is unknown whether the registry acted on it: reads, and
+// PUTs whose body can be sent again, which leave the same result.
+func replayable(req *http.Request) bool {
+	switch req.Method {
+	case http.MethodGet, http.MethodHead:
+		return true
+	case http.MethodPut:
+		return req.Body == nil || req.Body == http.NoBody || req.GetBody != nil
+	}
+	return false
+}
+
+// send performs the request built by newreq, waiting for the rate limiter
+// and retrying throttled, unavailable and reset requests with backoff.
+// newreq is called for every attempt so the body can be sent again.
+// Transport errors are only retried for replayable requests, and a
+// Retry-After longer than backoffMax is not waited for: the response is
+// returned instead.
+func (c *Client) send(ctx context.Context, newreq func(context.Context) (*http.Request, error)) (*http.Response, error) {
+	for attempt := 0; ; attempt++ {
+		if err := c.limiter.wait(ctx); err != nil {
+			return nil, err
+		}
+
+		req, err := newreq(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		resp, err := c.conn.Do(req)
+
+		if attempt >= c.retries {
+			return resp, err
+		}
+
+		var delay time.Duration
+		switch {
+		case err != nil:
+			if !retryableError(err) || !replayable(req) {
+				return nil, err
+			}
+			delay = backoff(attempt)
+
+		case retryableStatus(resp.StatusCode):
+			d, ok := retryAfter(resp.Header.Get("Retry-After"))
+			if !ok {
+				d = backoff(attempt)
+			}
+			if d > backoffMax {
+				return resp, nil
+			}
+			delay = d
+
+			if resp.StatusCode == http.StatusTooManyRequests {
+				c.limiter.pause(time.Now().Add(delay))
+			}
+
+			// consume body so connection can be reused
+			ioutil.ReadAll(resp.Body)
+			resp.Body.Close()
+
+		default:
+			return resp, nil
+		}
+
+		if err := sleep(ctx, delay); err != nil {
+			return nil, err
+		}
+	}
+}
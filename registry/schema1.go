@@ -0,0 +1,383 @@
+package registry
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+)
+
+const (
+	MediaTypeSchema1       = "application/vnd.docker.distribution.manifest.v1+json"
+	MediaTypeSignedSchema1 = "application/vnd.docker.distribution.manifest.v1+prettyjws"
+)
+
+// ErrSignature is wrapped by errors for schema 1 manifests whose
+// signatures do not verify.
+var ErrSignature = errors.New("invalid manifest signature")
+
+// Schema1 is a legacy Docker schema 1 manifest. FSLayers and History run
+// from the top layer down, one history entry per layer.
+type Schema1 struct {
+	SchemaVersion int              `json:"schemaVersion"`
+	Name          string           `json:"name"`
+	Tag           string           `json:"tag"`
+	Architecture  string           `json:"architecture"`
+	FSLayers      []Schema1Layer   `json:"fsLayers"`
+	History       []Schema1History `json:"history"`
+	Signatures    []JWS            `json:"signatures,omitempty"`
+}
+
+type Schema1Layer struct {
+	BlobSum Digest `json:"blobSum"`
+}
+
+type Schema1History struct {
+	V1Compatibility string `json:"v1Compatibility"`
+}
+
+// JWS is a libtrust signature of a schema 1 manifest.
+type JWS struct {
+	Header    JWSHeader `json:"header"`
+	Signature string    `json:"signature"`
+	Protected string    `json:"protected"`
+}
+
+type JWSHeader struct {
+	JWK   *JWK     `json:"jwk,omitempty"`
+	Chain []string `json:"x5c,omitempty"`
+	Alg   string   `json:"alg"`
+}
+
+// JWK is the public key of a signature.
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+}
+
+// protected is the signed header of a JWS. The signed payload is the
+// manifest up to formatLength followed by formatTail, which restores the
+// closing brace the signatures were spliced in before.
+type protected struct {
+	FormatLength int    `json:"formatLength"`
+	FormatTail   string `json:"formatTail"`
+	Time         string `json:"time"`
+}
+
+// joseDecode decodes unpadded base64url, tolerating padding.
+func joseDecode(s string) ([]byte, error) {
+	return base64.RawURLEncoding.DecodeString(strings.TrimRight(s, "="))
+}
+
+// ParseSchema1 parses a schema 1 manifest.
+func ParseSchema1(data []byte) (*Schema1, error) {
+	m := &Schema1{}
+
+	if err := json.Unmarshal(data, m); err != nil {
+		return nil, fmt.Errorf("unmarshal schema 1 manifest: %v", err)
+	}
+
+	if m.SchemaVersion != 1 {
+		return nil, fmt.Errorf("schema version %d, want 1", m.SchemaVersion)
+	}
+
+	if len(m.FSLayers) == 0 {
+		return nil, errors.New("schema 1 manifest has no layers")
+	}
+
+	if len(m.FSLayers) != len(m.History) {
+		return nil, fmt.Errorf("schema 1 manifest has %d layers but %d history entries", len(m.FSLayers), len(m.History))
+	}
+
+	return m, nil
+}
+
+// VerifySchema1 checks every signature of a signed schema 1 manifest and
+// returns the payload they sign, whose digest is the manifest digest.
+// Anything but the signatures outside the payload is an error; callers
+// should still parse the payload rather than data. Unsigned manifests are
+// returned as they are. Keys are not checked
+// against any trust store: a valid signature shows the manifest is intact,
+// not who signed it.
+func VerifySchema1(data []byte) ([]byte, error) {
+	m, err := ParseSchema1(data)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(m.Signatures) == 0 {
+		return data, nil
+	}
+
+	var payload []byte
+
+	for i, sig := range m.Signatures {
+		p, err := sig.verify(data)
+		if err != nil {
+			return nil, fmt.Errorf("signature %d: %w", i, err)
+		}
+
+		if payload != nil && !bytes.Equal(p, payload) {
+			return nil, fmt.Errorf("signature %d: %w: signs other content", i, ErrSignature)
+		}
+		payload = p
+	}
+
+	return payload, nil
+}
+
+// Schema1Payload returns the payload the signatures of a signed schema 1
+// manifest sign, whose digest is the manifest digest, without verifying
+// them; VerifySchema1 does. Unsigned manifests are returned as they are.
+func Schema1Payload(data []byte) ([]byte, error) {
+	var m struct {
+		Signatures []JWS `json:"signatures"`
+	}
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, fmt.Errorf("unmarshal schema 1 manifest: %v", err)
+	}
+
+	if len(m.Signatures) == 0 {
+		return data, nil
+	}
+
+	var payload []byte
+
+	for i, sig := range m.Signatures {
+		p, _, err := sig.payload(data)
+		if err != nil {
+			return nil, fmt.Errorf("signature %d: %w", i, err)
+		}
+
+		if payload != nil && !bytes.Equal(p, payload) {
+			return nil, fmt.Errorf("signature %d: %w: signs other content", i, ErrSignature)
+		}
+		payload = p
+	}
+
+	return payload, nil
+}
+
+// payload returns the part of data the signature signs, and where in
+// data the unsigned part starts.
+func (s *JWS) payload(data []byte) ([]byte, int, error) {
+	hdr, err := joseDecode(s.Protected)
+	if err != nil {
+		return nil, 0, fmt.Errorf("protected header: %v", err)
+	}
+
+	var p protected
+	if err := json.Unmarshal(hdr, &p); err != nil {
+		return nil, 0, fmt.Errorf("protected header: %v", err)
+	}
+
+	tail, err := joseDecode(p.FormatTail)
+	if err != nil {
+		return nil, 0, fmt.Errorf("format tail: %v", err)
+	}
+
+	if p.FormatLength < 0 || p.FormatLength > len(data) {
+		return nil, 0, fmt.Errorf("%w: format length %d out of range", ErrSignature, p.FormatLength)
+	}
+
+	return append(append([]byte{}, data[:p.FormatLength]...), tail...), p.FormatLength, nil
+}
+
+// verify checks the signature over the payload it selects from data and
+// returns the payload.
+func (s *JWS) verify(data []byte) ([]byte, error) {
+	payload, end, err := s.payload(data)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := signaturesOnly(data[end:]); err != nil {
+		return nil, err
+	}
+
+	sig, err := joseDecode(s.Signature)
+	if err != nil {
+		return nil, fmt.Errorf("signature: %v", err)
+	}
+
+	key, err := s.Header.publicKey()
+	if err != nil {
+		return nil, err
+	}
+
+	signed := []byte(s.Protected + "." + base64.RawURLEncoding.EncodeToString(payload))
+
+	if err := verifySignature(key, s.Header.Alg, signed, sig); err != nil {
+		return nil, err
+	}
+
+	return payload, nil
+}
+
+// signaturesOnly checks the unsigned part of a manifest, from formatLength
+// on, holds nothing but the signatures, so the fields that are used are
+// the signed ones.
+func signaturesOnly(rest []byte) error {
+	rest = bytes.TrimLeft(rest, " \t\r\n")
+	if len(rest) == 0 || rest[0] != ',' {
+		return fmt.Errorf("%w: unsigned content after the payload", ErrSignature)
+	}
+
+	fields := make(map[string]json.RawMessage)
+	if err := json.Unmarshal(append([]byte("{"), rest[1:]...), &fields); err != nil {
+		return fmt.Errorf("%w: unsigned content after the payload: %v", ErrSignature, err)
+	}
+
+	if _, ok := fields["signatures"]; !ok || len(fields) != 1 {
+		return fmt.Errorf("%w: unsigned content after the payload", ErrSignature)
+	}
+
+	return nil
+}
+
+// publicKey returns the key from the JWK, or else the leaf certificate of
+// the x5c chain.
+func (h *JWSHeader) publicKey() (crypto.PublicKey, error) {
+	if h.JWK != nil {
+		return h.JWK.PublicKey()
+	}
+
+	if len(h.Chain) == 0 {
+		return nil, errors.New("signature has neither jwk nor x5c")
+	}
+
+	der, err := base64.StdEncoding.DecodeString(h.Chain[0])
+	if err != nil {
+		return nil, fmt.Errorf("x5c: %v", err)
+	}
+
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, fmt.Errorf("x5c: %v", err)
+	}
+
+	return cert.PublicKey, nil
+}
+
+// PublicKey returns the EC or RSA public key k describes.
+func (k *JWK) PublicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("jwk: unsupported curve %q", k.Crv)
+		}
+
+		x, err := joseDecode(k.X)
+		if err != nil {
+			return nil, fmt.Errorf("jwk x: %v", err)
+		}
+		y, err := joseDecode(k.Y)
+		if err != nil {
+			return nil, fmt.Errorf("jwk y: %v", err)
+		}
+
+		key := &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if !curve.IsOnCurve(key.X, key.Y) {
+			return nil, errors.New("jwk: point is not on the curve")
+		}
+
+		return key, nil
+
+	case "RSA":
+		n, err := joseDecode(k.N)
+		if err != nil {
+			return nil, fmt.Errorf("jwk n: %v", err)
+		}
+		e, err := joseDecode(k.E)
+		if err != nil {
+			return nil, fmt.Errorf("jwk e: %v", err)
+		}
+
+		exp := new(big.Int).SetBytes(e)
+		if !exp.IsInt64() || exp.Int64() > 1<<31-1 {
+			return nil, errors.New("jwk: rsa exponent too large")
+		}
+
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(exp.Int64())}, nil
+	}
+
+	return nil, fmt.Errorf("jwk: unsupported key type %q", k.Kty)
+}
+
+// verifySignature checks a JWS signature made with algorithm alg.
+func verifySignature(key crypto.PublicKey, alg string, signed, sig []byte) error {
+	if len(alg) != 5 {
+		return fmt.Errorf("unsupported signature algorithm %q", alg)
+	}
+
+	var hash crypto.Hash
+	switch alg[2:] {
+	case "256":
+		hash = crypto.SHA256
+	case "384":
+		hash = crypto.SHA384
+	case "512":
+		hash = crypto.SHA512
+	default:
+		return fmt.Errorf("unsupported signature algorithm %q", alg)
+	}
+
+	h := hash.New()
+	h.Write(signed)
+	sum := h.Sum(nil)
+
+	switch k := key.(type) {
+	case *ecdsa.PublicKey:
+		if !strings.HasPrefix(alg, "ES") {
+			return fmt.Errorf("%s signature with an EC key", alg)
+		}
+
+		// r and s, each padded to the size of the curve
+		n := (k.Curve.Params().BitSize + 7) / 8
+		if len(sig) != 2*n {
+			return fmt.Errorf("%w: %d byte %s signature", ErrSignature, len(sig), alg)
+		}
+
+		r := new(big.Int).SetBytes(sig[:n])
+		s := new(big.Int).SetBytes(sig[n:])
+
+		if !ecdsa.Verify(k, sum, r, s) {
+			return ErrSignature
+		}
+
+	case *rsa.PublicKey:
+		if !strings.HasPrefix(alg, "RS") {
+			return fmt.Errorf("%s signature with an RSA key", alg)
+		}
+
+		if err := rsa.VerifyPKCS1v15(k, hash, sum, sig); err != nil {
+			return ErrSignature
+		}
+
+	default:
+		return fmt.Errorf("unsupported key type %T", key)
+	}
+
+	return nil
+}
@@ -0,0 +1,65 @@
+package registry
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+type tagsDecoder struct {
+	tags []string
+	next string
+}
+
+func (t *tagsDecoder) Method() string { return http.MethodGet }
+
+func (t *tagsDecoder) SetHeaders(hdr *http.Header) {}
+
+func (t *tagsDecoder) UnmarshalJSON(b []byte) error {
+	type Tags struct {
+		Name string   `json:"name"`
+		Tags []string `json:"tags"`
+	}
+
+	tags := &Tags{}
+
+	err := json.Unmarshal(b, &tags)
+	if err != nil {
+		return fmt.Errorf("unmarshal: %v", err)
+	}
+
+	t.tags = tags.Tags
+
+	return nil
+}
+
+func (t *tagsDecoder) ExtractHeaders(hdr *http.Header) {
+	t.next = nextLink(hdr.Get("Link"))
+}
+
+// Tags lists the tags of a repository, following pagination.
+func (c *Client) Tags(ctx context.Context, repo string) ([]string, error) {
+	var tags []string
+
+	url := c.base + "/v2/" + repo + "/tags/list"
+	for {
+		t := &tagsDecoder{}
+
+		err := c.get(ctx, url, t)
+		if err != nil {
+			return nil, err
+		}
+
+		tags = append(tags, t.tags...)
+
+		if t.next == "" {
+			return tags, nil
+		}
+
+		url, err = resolve(c.base, t.next)
+		if err != nil {
+			return nil, err
+		}
+	}
+}
@@ -0,0 +1,682 @@
+package main
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"debug/buildinfo"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	neturl "net/url"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/dbulkow/registry_cmd/registry"
+	"github.com/spf13/cobra"
+)
+
+const (
+	MediaTypeSPDX      = "application/spdx+json"
+	MediaTypeCycloneDX = "application/vnd.cyclonedx+json"
+
+	// largest executable read looking for Go build information
+	maxBinarySize = 512 << 20
+)
+
+var sbomformat, sbomplatform string
+var sbomattach bool
+
+func init() {
+	sbomCmd := &cobra.Command{
+		Use:   "sbom <image:tag>",
+		Short: "Generate a package inventory of an image",
+		Long: `Generate a package inventory of an image as SPDX or CycloneDX JSON.
+
+Packages are read from the flattened image filesystem:
+
+  dpkg     /var/lib/dpkg/status, /var/lib/dpkg/status.d/*
+  apk      /lib/apk/db/installed
+  Go       build information embedded in executables
+  Python   *.dist-info/METADATA, *.egg-info/PKG-INFO
+  npm      node_modules/*/package.json
+
+dpkg and apk packages built from a source package of another name carry
+it in the upstream qualifier of their package URL.
+
+With --attach the document is pushed as an OCI referrer of the image.
+`,
+		RunE: sbom,
+	}
+
+	sbomCmd.Flags().StringVarP(&sbomformat, "format", "o", "spdx", "Output format (spdx, cyclonedx)")
+	sbomCmd.Flags().StringVar(&sbomplatform, "platform", "", "Platform to select from manifest lists (os/arch[/variant])")
+	sbomCmd.Flags().BoolVar(&sbomattach, "attach", false, "Push the SBOM as a referrer of the image")
+
+	RootCmd.AddCommand(sbomCmd)
+}
+
+type Package struct {
+	Name    string
+	Version string
+	Type    string
+	PURL    string
+	License string
+	Path    string
+	Layer   registry.Digest
+	Distro  string
+
+	// Source is the source package a distribution package is built from,
+	// the dpkg Source or apk origin, or Name when they are the same
+	Source string
+}
+
+type foundfile struct {
+	layer registry.Digest
+	data  []byte
+	build *buildinfo.BuildInfo
+}
+
+// inventoryFile reports whether the contents of p are needed to take the
+// package inventory.
+func inventoryFile(p string) bool {
+	dir, base := path.Split(p)
+	dir = path.Clean(dir)
+
+	switch {
+	case p == "/var/lib/dpkg/status", dir == "/var/lib/dpkg/status.d":
+	case p == "/lib/apk/db/installed":
+	case p == "/etc/os-release", p == "/usr/lib/os-release":
+	case base == "METADATA" && strings.HasSuffix(dir, ".dist-info"):
+	case base == "PKG-INFO" && strings.HasSuffix(dir, ".egg-info"):
+	case base == "package.json" && isNodeModule(dir):
+	default:
+		return false
+	}
+
+	return true
+}
+
+func isNodeModule(dir string) bool {
+	parent := path.Dir(dir)
+	if path.Base(parent) == "node_modules" {
+		return true
+	}
+	return strings.HasPrefix(path.Base(parent), "@") && path.Base(path.Dir(parent)) == "node_modules"
+}
+
+// inventoryFiles collects the files of the flattened image that describe
+// installed packages, and the build information of Go executables.
+func inventoryFiles(ctx context.Context, client *registry.Client, image string, layers []registry.Descriptor) (map[string]*foundfile, error) {
+	files := make(map[string]*foundfile)
+
+	// drop removes entries of lower layers; those of the layer being read
+	// are kept in upper until it is done
+	drop := func(p string, self bool) {
+		prefix := strings.TrimSuffix(p, "/") + "/"
+		for k := range files {
+			if strings.HasPrefix(k, prefix) || (self && k == p) {
+				delete(files, k)
+			}
+		}
+	}
+
+	for _, l := range layers {
+		upper := make(map[string]*foundfile)
+
+		err := walkLayer(ctx, client, image, l.Digest, func(hdr *tar.Header, r io.Reader) error {
+			if p, opaque, ok := whiteout(hdr.Name); ok {
+				drop(p, !opaque)
+				return nil
+			}
+
+			p := cleanPath(hdr.Name)
+
+			// a directory only replaces a file at its own path, anything
+			// else replaces the path and whatever was below it
+			if hdr.Typeflag == tar.TypeDir {
+				delete(files, p)
+				return nil
+			}
+			drop(p, true)
+
+			if hdr.Typeflag != tar.TypeReg {
+				return nil
+			}
+
+			if inventoryFile(p) {
+				data, err := ioutil.ReadAll(r)
+				if err != nil {
+					return err
+				}
+				upper[p] = &foundfile{layer: l.Digest, data: data}
+				return nil
+			}
+
+			if hdr.Mode&0111 == 0 || hdr.Size < 4 || hdr.Size > maxBinarySize {
+				return nil
+			}
+
+			br := bufio.NewReader(r)
+			if magic, err := br.Peek(4); err != nil || string(magic) != "\x7fELF" {
+				return nil
+			}
+
+			data, err := ioutil.ReadAll(br)
+			if err != nil {
+				return err
+			}
+
+			if bi, err := buildinfo.Read(bytes.NewReader(data)); err == nil {
+				upper[p] = &foundfile{layer: l.Digest, build: bi}
+			}
+
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		for p, f := range upper {
+			files[p] = f
+		}
+	}
+
+	return files, nil
+}
+
+// stanzas splits RFC 822 style package databases into key/value records.
+// Continuation lines are appended to the previous value.
+func stanzas(data []byte, sep string) []map[string]string {
+	records := make([]map[string]string, 0)
+	cur := make(map[string]string)
+	var last string
+
+	for _, line := range strings.Split(string(data), "\n") {
+		if strings.TrimSpace(line) == "" {
+			if len(cur) > 0 {
+				records = append(records, cur)
+				cur = make(map[string]string)
+			}
+			continue
+		}
+
+		if (line[0] == ' ' || line[0] == '\t') && last != "" {
+			cur[last] += "\n" + strings.TrimSpace(line)
+			continue
+		}
+
+		kv := strings.SplitN(line, sep, 2)
+		if len(kv) != 2 {
+			continue
+		}
+
+		last = strings.TrimSpace(kv[0])
+		if _, ok := cur[last]; !ok {
+			cur[last] = strings.TrimSpace(kv[1])
+		}
+	}
+
+	if len(cur) > 0 {
+		records = append(records, cur)
+	}
+
+	return records
+}
+
+func osRelease(files map[string]*foundfile) (string, string) {
+	f := files["/etc/os-release"]
+	if f == nil {
+		f = files["/usr/lib/os-release"]
+	}
+	if f == nil {
+		return "", ""
+	}
+
+	var id, version string
+	for _, line := range strings.Split(string(f.data), "\n") {
+		kv := strings.SplitN(line, "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+
+		v := strings.Trim(strings.TrimSpace(kv[1]), `"'`)
+		switch kv[0] {
+		case "ID":
+			id = v
+		case "VERSION_ID":
+			version = v
+		}
+	}
+
+	return id, version
+}
+
+func purl(typ, namespace, name, version string, qualifiers ...string) string {
+	p := "pkg:" + typ + "/"
+	if namespace != "" {
+		p += neturl.PathEscape(namespace) + "/"
+	}
+	p += neturl.PathEscape(name) + "@" + neturl.PathEscape(version)
+
+	q := make([]string, 0)
+	for i := 0; i+1 < len(qualifiers); i += 2 {
+		if qualifiers[i+1] != "" {
+			q = append(q, qualifiers[i]+"="+neturl.QueryEscape(qualifiers[i+1]))
+		}
+	}
+	if len(q) > 0 {
+		p += "?" + strings.Join(q, "&")
+	}
+
+	return p
+}
+
+// upstream returns the upstream qualifier of the package URL of binary
+// package name, built from source package source: empty when they match.
+func upstream(name, source string) string {
+	if source == name {
+		return ""
+	}
+	return source
+}
+
+// inventory extracts packages from the collected files.
+func inventory(files map[string]*foundfile) []*Package {
+	distro, release := osRelease(files)
+	distroq := ""
+	if distro != "" {
+		distroq = distro + "-" + release
+	}
+
+	pkgs := make([]*Package, 0)
+	add := func(p *Package) {
+		pkgs = append(pkgs, p)
+	}
+
+	paths := make([]string, 0, len(files))
+	for p := range files {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+
+	for _, p := range paths {
+		f := files[p]
+
+		switch {
+		case f.build != nil:
+			bi := f.build
+
+			if bi.Main.Path != "" {
+				version := bi.Main.Version
+				add(&Package{Name: bi.Main.Path, Version: version, Type: "golang",
+					PURL: "pkg:golang/" + bi.Main.Path + "@" + version, Path: p, Layer: f.layer})
+			}
+
+			for _, dep := range bi.Deps {
+				if dep.Replace != nil {
+					dep = dep.Replace
+				}
+				add(&Package{Name: dep.Path, Version: dep.Version, Type: "golang",
+					PURL: "pkg:golang/" + dep.Path + "@" + dep.Version, Path: p, Layer: f.layer})
+			}
+
+			add(&Package{Name: "stdlib", Version: bi.GoVersion, Type: "golang",
+				PURL: "pkg:golang/stdlib@" + bi.GoVersion, Path: p, Layer: f.layer})
+
+		case p == "/var/lib/dpkg/status" || path.Dir(p) == "/var/lib/dpkg/status.d":
+			ns := distro
+			if ns == "" {
+				ns = "debian"
+			}
+
+			for _, r := range stanzas(f.data, ":") {
+				if r["Package"] == "" || (r["Status"] != "" && !strings.HasSuffix(r["Status"], " installed")) {
+					continue
+				}
+				// Source may carry the source version in parentheses
+				source := r["Package"]
+				if f := strings.Fields(r["Source"]); len(f) > 0 {
+					source = f[0]
+				}
+				add(&Package{Name: r["Package"], Version: r["Version"], Type: "deb", Distro: distroq, Source: source,
+					PURL: purl("deb", ns, r["Package"], r["Version"], "arch", r["Architecture"], "distro", distroq,
+						"upstream", upstream(r["Package"], source)),
+					Path: p, Layer: f.layer})
+			}
+
+		case p == "/lib/apk/db/installed":
+			ns := distro
+			if ns == "" {
+				ns = "alpine"
+			}
+
+			for _, r := range stanzas(f.data, ":") {
+				if r["P"] == "" {
+					continue
+				}
+				source := r["P"]
+				if r["o"] != "" {
+					source = r["o"]
+				}
+				add(&Package{Name: r["P"], Version: r["V"], Type: "apk", License: r["L"], Distro: distroq, Source: source,
+					PURL: purl("apk", ns, r["P"], r["V"], "arch", r["A"], "distro", distroq,
+						"upstream", upstream(r["P"], source)),
+					Path: p, Layer: f.layer})
+			}
+
+		case path.Base(p) == "METADATA" || path.Base(p) == "PKG-INFO":
+			head := f.data
+			if i := bytes.Index(head, []byte("\n\n")); i >= 0 {
+				head = head[:i]
+			}
+
+			r := stanzas(head, ":")
+			if len(r) == 0 || r[0]["Name"] == "" {
+				continue
+			}
+
+			name := strings.ToLower(strings.NewReplacer("_", "-", ".", "-").Replace(r[0]["Name"]))
+			add(&Package{Name: r[0]["Name"], Version: r[0]["Version"], Type: "pypi", License: r[0]["License"],
+				PURL: purl("pypi", "", name, r[0]["Version"]), Path: p, Layer: f.layer})
+
+		case path.Base(p) == "package.json":
+			var pj struct {
+				Name    string          `json:"name"`
+				Version string          `json:"version"`
+				License json.RawMessage `json:"license"`
+			}
+
+			if err := json.Unmarshal(f.data, &pj); err != nil || pj.Name == "" {
+				continue
+			}
+
+			var license string
+			if err := json.Unmarshal(pj.License, &license); err != nil {
+				var l struct {
+					Type string `json:"type"`
+				}
+				json.Unmarshal(pj.License, &l)
+				license = l.Type
+			}
+
+			ns, name := "", pj.Name
+			if strings.HasPrefix(name, "@") && strings.Contains(name, "/") {
+				parts := strings.SplitN(name, "/", 2)
+				ns, name = parts[0], parts[1]
+			}
+
+			add(&Package{Name: pj.Name, Version: pj.Version, Type: "npm", License: license,
+				PURL: purl("npm", ns, name, pj.Version), Path: p, Layer: f.layer})
+		}
+	}
+
+	return pkgs
+}
+
+// marshalIndent encodes v for people to read, without escaping the & of
+// package URL qualifiers.
+func marshalIndent(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "  ")
+
+	if err := enc.Encode(v); err != nil {
+		return nil, err
+	}
+
+	return bytes.TrimSuffix(buf.Bytes(), []byte("\n")), nil
+}
+
+func uuid() string {
+	var b [16]byte
+	rand.Read(b[:])
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
+
+func spdx(image, digest string, pkgs []*Package) ([]byte, error) {
+	type ExternalRef struct {
+		Category string `json:"referenceCategory"`
+		Type     string `json:"referenceType"`
+		Locator  string `json:"referenceLocator"`
+	}
+	type SPDXPackage struct {
+		Name             string        `json:"name"`
+		SPDXID           string        `json:"SPDXID"`
+		VersionInfo      string        `json:"versionInfo,omitempty"`
+		DownloadLocation string        `json:"downloadLocation"`
+		FilesAnalyzed    bool          `json:"filesAnalyzed"`
+		LicenseConcluded string        `json:"licenseConcluded"`
+		LicenseDeclared  string        `json:"licenseDeclared"`
+		SourceInfo       string        `json:"sourceInfo,omitempty"`
+		ExternalRefs     []ExternalRef `json:"externalRefs,omitempty"`
+	}
+	type Relationship struct {
+		Element string `json:"spdxElementId"`
+		Type    string `json:"relationshipType"`
+		Related string `json:"relatedSpdxElement"`
+	}
+
+	packages := []SPDXPackage{{
+		Name:             image,
+		SPDXID:           "SPDXRef-Image",
+		VersionInfo:      digest,
+		DownloadLocation: "NOASSERTION",
+		LicenseConcluded: "NOASSERTION",
+		LicenseDeclared:  "NOASSERTION",
+		ExternalRefs: []ExternalRef{{
+			Category: "PACKAGE-MANAGER",
+			Type:     "purl",
+			Locator:  purl("oci", "", path.Base(image), digest),
+		}},
+	}}
+
+	relationships := []Relationship{{
+		Element: "SPDXRef-DOCUMENT",
+		Type:    "DESCRIBES",
+		Related: "SPDXRef-Image",
+	}}
+
+	for i, p := range pkgs {
+		id := fmt.Sprintf("SPDXRef-Package-%d", i+1)
+
+		// SPDX license expressions cannot hold free text, leave those out
+		license := "NOASSERTION"
+		if p.License != "" && !strings.ContainsAny(p.License, "\n,;") {
+			license = p.License
+		}
+
+		packages = append(packages, SPDXPackage{
+			Name:             p.Name,
+			SPDXID:           id,
+			VersionInfo:      p.Version,
+			DownloadLocation: "NOASSERTION",
+			LicenseConcluded: "NOASSERTION",
+			LicenseDeclared:  license,
+			SourceInfo:       "found in " + p.Path + " of layer " + string(p.Layer),
+			ExternalRefs: []ExternalRef{{
+				Category: "PACKAGE-MANAGER",
+				Type:     "purl",
+				Locator:  p.PURL,
+			}},
+		})
+
+		relationships = append(relationships, Relationship{
+			Element: "SPDXRef-Image",
+			Type:    "CONTAINS",
+			Related: id,
+		})
+	}
+
+	doc := map[string]interface{}{
+		"spdxVersion":       "SPDX-2.3",
+		"dataLicense":       "CC0-1.0",
+		"SPDXID":            "SPDXRef-DOCUMENT",
+		"name":              image + "@" + digest,
+		"documentNamespace": "https://github.com/dbulkow/registry_cmd/spdx/" + uuid(),
+		"creationInfo": map[string]interface{}{
+			"created":  time.Now().UTC().Format(time.RFC3339),
+			"creators": []string{"Tool: regcmd"},
+		},
+		"packages":      packages,
+		"relationships": relationships,
+	}
+
+	return marshalIndent(doc)
+}
+
+func cyclonedx(image, digest string, pkgs []*Package) ([]byte, error) {
+	type License struct {
+		License struct {
+			Name string `json:"name"`
+		} `json:"license"`
+	}
+	type Property struct {
+		Name  string `json:"name"`
+		Value string `json:"value"`
+	}
+	type Component struct {
+		Type       string     `json:"type"`
+		BOMRef     string     `json:"bom-ref"`
+		Name       string     `json:"name"`
+		Version    string     `json:"version,omitempty"`
+		PURL       string     `json:"purl,omitempty"`
+		Licenses   []License  `json:"licenses,omitempty"`
+		Properties []Property `json:"properties,omitempty"`
+	}
+
+	components := make([]Component, 0, len(pkgs))
+	refs := make(map[string]int)
+
+	for _, p := range pkgs {
+		ref := p.PURL
+		if n := refs[p.PURL]; n > 0 {
+			ref = fmt.Sprintf("%s#%d", p.PURL, n)
+		}
+		refs[p.PURL]++
+
+		c := Component{
+			Type:    "library",
+			BOMRef:  ref,
+			Name:    p.Name,
+			Version: p.Version,
+			PURL:    p.PURL,
+			Properties: []Property{
+				{Name: "regcmd:path", Value: p.Path},
+				{Name: "regcmd:layer", Value: string(p.Layer)},
+			},
+		}
+
+		if p.License != "" {
+			var l License
+			l.License.Name = p.License
+			c.Licenses = []License{l}
+		}
+
+		components = append(components, c)
+	}
+
+	doc := map[string]interface{}{
+		"bomFormat":    "CycloneDX",
+		"specVersion":  "1.5",
+		"serialNumber": "urn:uuid:" + uuid(),
+		"version":      1,
+		"metadata": map[string]interface{}{
+			"timestamp": time.Now().UTC().Format(time.RFC3339),
+			"tools": map[string]interface{}{
+				"components": []map[string]string{{"type": "application", "name": "regcmd"}},
+			},
+			"component": map[string]string{
+				"type":    "container",
+				"bom-ref": image + "@" + digest,
+				"name":    image,
+				"version": digest,
+			},
+		},
+		"components": components,
+	}
+
+	return marshalIndent(doc)
+}
+
+func sbom(cmd *cobra.Command, args []string) error {
+	if len(args) != 1 {
+		return usageError(cmd)
+	}
+
+	r, err := useReference(cmd, args[0])
+	if err != nil {
+		return err
+	}
+	name, ref := r.Name, r.Ref()
+
+	if !cmd.Flag("format").Changed && current != nil && current.Output != "" {
+		sbomformat = current.Output
+	}
+
+	var encode func(string, string, []*Package) ([]byte, error)
+	var mediaType string
+
+	switch sbomformat {
+	case "spdx":
+		encode, mediaType = spdx, MediaTypeSPDX
+	case "cyclonedx":
+		encode, mediaType = cyclonedx, MediaTypeCycloneDX
+	default:
+		return &exitError{code: ExitUsage, err: fmt.Errorf("unknown format %q", sbomformat)}
+	}
+
+	plat, err := platform(sbomplatform)
+	if err != nil {
+		return err
+	}
+
+	client, err := connect(cmd)
+	if err != nil {
+		return err
+	}
+
+	m, digest, err := client.ImageManifest(runctx, name, ref, plat)
+	if err != nil {
+		return fmt.Errorf("manifest: %w", err)
+	}
+
+	files, err := inventoryFiles(runctx, client, name, m.Layers)
+	if err != nil {
+		return err
+	}
+
+	doc, err := encode(name, string(digest), inventory(files))
+	if err != nil {
+		return err
+	}
+
+	if !sbomattach {
+		os.Stdout.Write(append(doc, '\n'))
+		return nil
+	}
+
+	subject, err := client.RawManifest(runctx, name, string(digest))
+	if err != nil {
+		return fmt.Errorf("manifest: %w", err)
+	}
+
+	sbomdigest, err := client.PushReferrer(runctx, name, subject, mediaType, mediaType, doc, nil)
+	if err != nil {
+		return fmt.Errorf("attach: %w", err)
+	}
+
+	fmt.Printf("attached %s sbom %s to %s@%s\n", sbomformat, sbomdigest, name, digest)
+
+	return nil
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/dbulkow/registry_cmd/registry/registrytest"
+)
+
+func TestSbom(t *testing.T) {
+	e := seeded(t)
+
+	out := e.ok("sbom", "tools/mix:1")
+
+	var doc map[string]interface{}
+	if err := json.Unmarshal([]byte(out), &doc); err != nil {
+		t.Fatalf("spdx output: %v", err)
+	}
+	contains(t, out, `"spdxVersion"`, "pkg:npm/lodash@4.17.20", "pkg:pypi/requests@2.31.0", "pkg:apk/alpine/musl@1.2.4-r2")
+
+	out = e.ok("sbom", "-o", "cyclonedx", "tools/mix:1")
+	if err := json.Unmarshal([]byte(out), &doc); err != nil {
+		t.Fatalf("cyclonedx output: %v", err)
+	}
+	contains(t, out, `"bomFormat": "CycloneDX"`, "pkg:npm/lodash@4.17.20")
+
+	contains(t, e.fails(ExitUsage, "sbom", "-o", "bogus", "tools/mix:1").stderr, `unknown format "bogus"`)
+}
+
+func TestSbomAttach(t *testing.T) {
+	e := seeded(t)
+
+	contains(t, e.ok("sbom", "--attach", "tools/mix:1"), "attached spdx sbom")
+
+	if n := e.reg.Count("PUT", "^/v2/tools/mix/manifests/sha256:"); n != 1 {
+		t.Errorf("%d referrer manifests pushed, want 1", n)
+	}
+}
+
+func TestSbomOpaque(t *testing.T) {
+	e := seeded(t)
+	pushOpaque(e.reg)
+
+	// os-release comes from the layer with the opaque marker
+	contains(t, e.ok("sbom", "app/opaque:1"), "pkg:apk/alpine/musl@1.2.4-r2?arch=x86_64&distro=alpine-3.20.0")
+}
+
+func TestSbomDirectories(t *testing.T) {
+	e := seeded(t)
+
+	// directory entries of an upper layer keep the files below them
+	e.reg.PushImage("app/dirs", "1", registrytest.Image{
+		Layers: [][]byte{baseLayer, registrytest.Layer(
+			registrytest.File{Name: "lib/", Type: '5'},
+			registrytest.File{Name: "etc/", Type: '5'},
+			registrytest.File{Name: "etc/hostname", Body: "dirs\n"},
+		)},
+	})
+	contains(t, e.ok("sbom", "app/dirs:1"), "pkg:apk/alpine/musl@1.2.4-r2?arch=x86_64&distro=alpine-3.19.1")
+
+	// a link replacing a directory takes what was below it away
+	e.reg.PushImage("app/dirs", "2", registrytest.Image{
+		Layers: [][]byte{baseLayer, registrytest.Layer(
+			registrytest.File{Name: "lib", Type: '2', Linkname: "usr/lib"},
+		)},
+	})
+	out := e.ok("sbom", "app/dirs:2")
+	contains(t, out, `"spdxVersion"`)
+	lacks(t, out, "pkg:apk/")
+}
+
+func TestSbomSource(t *testing.T) {
+	e := seeded(t)
+
+	e.reg.PushImage("app/deb", "1", registrytest.Image{
+		Layers: [][]byte{registrytest.Layer(
+			registrytest.File{Name: "etc/os-release", Body: "ID=debian\nVERSION_ID=12\n"},
+			registrytest.File{Name: "var/lib/dpkg/status", Body: "" +
+				"Package: libssl3\nStatus: install ok installed\nArchitecture: amd64\nSource: openssl (3.0.11-1)\nVersion: 3.0.11-1~deb12u2\n\n" +
+				"Package: bash\nStatus: install ok installed\nArchitecture: amd64\nVersion: 5.2.15-2+b2\n\n"},
+		)},
+	})
+	e.reg.PushImage("app/apk", "1", registrytest.Image{
+		Layers: [][]byte{registrytest.Layer(
+			registrytest.File{Name: "etc/os-release", Body: "ID=alpine\nVERSION_ID=3.19.1\n"},
+			registrytest.File{Name: "lib/apk/db/installed", Body: "P:libcrypto3\nV:3.1.4-r5\nA:x86_64\no:openssl\n\n"},
+		)},
+	})
+
+	out := e.ok("sbom", "app/deb:1")
+	contains(t, out, "pkg:deb/debian/libssl3@3.0.11-1~deb12u2?arch=amd64&distro=debian-12&upstream=openssl")
+	contains(t, out, "pkg:deb/debian/bash@5.2.15-2+b2?arch=amd64&distro=debian-12\"")
+
+	contains(t, e.ok("sbom", "app/apk:1"), "pkg:apk/alpine/libcrypto3@3.1.4-r5?arch=x86_64&distro=alpine-3.19.1&upstream=openssl")
+}
@@ -0,0 +1,498 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"sort"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+var scandb, scanfailon, scanplatform string
+
+func init() {
+	scanCmd := &cobra.Command{
+		Use:   "scan <image:tag>",
+		Short: "Match the packages of an image against an advisory database",
+		Long: `Match the packages of an image against a local OSV advisory database.
+
+The database is a JSON file holding an array of OSV records, an object with
+a "vulns" array, or one record per line. No network access is needed beyond
+the registry.
+
+Debian, Ubuntu and Alpine advisories name source packages, so binary
+packages are matched by the source package they were built from.
+
+With --fail-on, regcmd exits with status 7 when a vulnerability of that
+severity or above is found. Severities are low, medium, high and critical.
+`,
+		RunE: scan,
+	}
+
+	scanCmd.Flags().StringVar(&scandb, "db", "", "OSV advisory database file")
+	scanCmd.Flags().StringVar(&scanfailon, "fail-on", "", "Fail when a vulnerability of this severity or above is found")
+	scanCmd.Flags().StringVar(&scanplatform, "platform", "", "Platform to select from manifest lists (os/arch[/variant])")
+
+	RootCmd.AddCommand(scanCmd)
+}
+
+type OSVEvent struct {
+	Introduced   string `json:"introduced,omitempty"`
+	Fixed        string `json:"fixed,omitempty"`
+	LastAffected string `json:"last_affected,omitempty"`
+}
+
+type OSVRange struct {
+	Type   string     `json:"type"`
+	Events []OSVEvent `json:"events"`
+}
+
+type OSVSeverity struct {
+	Type  string `json:"type"`
+	Score string `json:"score"`
+}
+
+type OSVAffected struct {
+	Package struct {
+		Ecosystem string `json:"ecosystem"`
+		Name      string `json:"name"`
+		PURL      string `json:"purl,omitempty"`
+	} `json:"package"`
+	Ranges            []OSVRange             `json:"ranges,omitempty"`
+	Versions          []string               `json:"versions,omitempty"`
+	Severity          []OSVSeverity          `json:"severity,omitempty"`
+	EcosystemSpecific map[string]interface{} `json:"ecosystem_specific,omitempty"`
+	DatabaseSpecific  map[string]interface{} `json:"database_specific,omitempty"`
+}
+
+type OSV struct {
+	ID               string                 `json:"id"`
+	Aliases          []string               `json:"aliases,omitempty"`
+	Summary          string                 `json:"summary,omitempty"`
+	Severity         []OSVSeverity          `json:"severity,omitempty"`
+	Affected         []OSVAffected          `json:"affected"`
+	DatabaseSpecific map[string]interface{} `json:"database_specific,omitempty"`
+}
+
+// loadAdvisories reads an OSV dump as an array, a {"vulns": [...]} object
+// or a stream of records.
+func loadAdvisories(path string) ([]*OSV, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	data = bytes.TrimSpace(data)
+
+	if bytes.HasPrefix(data, []byte("[")) {
+		vulns := make([]*OSV, 0)
+		if err := json.Unmarshal(data, &vulns); err != nil {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+		return vulns, nil
+	}
+
+	vulns := make([]*OSV, 0)
+	dec := json.NewDecoder(bytes.NewReader(data))
+
+	for dec.More() {
+		var rec struct {
+			OSV
+			Vulns []*OSV `json:"vulns"`
+		}
+
+		if err := dec.Decode(&rec); err != nil {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+
+		if rec.Vulns != nil {
+			vulns = append(vulns, rec.Vulns...)
+		} else if rec.ID != "" {
+			osv := rec.OSV
+			vulns = append(vulns, &osv)
+		}
+	}
+
+	return vulns, nil
+}
+
+// ecosystems maps package types to OSV ecosystem names.
+var ecosystems = map[string]string{
+	"deb":    "Debian",
+	"apk":    "Alpine",
+	"golang": "Go",
+	"pypi":   "PyPI",
+	"npm":    "npm",
+}
+
+// ecosystemMatches checks an OSV ecosystem such as "Debian:12",
+// "Alpine:v3.19" or "Ubuntu:Pro:22.04:LTS" against the package type and
+// distribution release. The release is the first part after the name
+// that is a version; an ecosystem without one matches every release.
+func ecosystemMatches(ecosystem string, p *Package) bool {
+	parts := strings.Split(ecosystem, ":")
+	name, release := parts[0], ""
+	for _, r := range parts[1:] {
+		r = strings.TrimPrefix(r, "v")
+		if r != "" && isDigit(r[0]) {
+			release = r
+			break
+		}
+	}
+
+	want := ecosystems[p.Type]
+	if p.Type == "deb" && strings.HasPrefix(p.Distro, "ubuntu-") {
+		want = "Ubuntu"
+	}
+
+	if name != want {
+		return false
+	}
+
+	if release == "" || p.Distro == "" {
+		return true
+	}
+
+	distro := p.Distro[strings.Index(p.Distro, "-")+1:]
+
+	return distro == release || strings.HasPrefix(distro, release+".")
+}
+
+// packageName returns the name advisories of ecosystem typ give p under:
+// distributions publish them for source packages.
+func packageName(typ string, p *Package) string {
+	if p.Source != "" {
+		return p.Source
+	}
+	return normalizeName(typ, p.Name)
+}
+
+func normalizeName(typ, name string) string {
+	if typ == "pypi" {
+		return strings.ToLower(strings.NewReplacer("_", "-", ".", "-").Replace(name))
+	}
+	return name
+}
+
+// affects applies the OSV range semantics to version.
+func (a *OSVAffected) affects(version string) bool {
+	for _, v := range a.Versions {
+		if v == version {
+			return true
+		}
+	}
+
+	eco := a.Package.Ecosystem
+	if i := strings.Index(eco, ":"); i >= 0 {
+		eco = eco[:i]
+	}
+
+	for _, r := range a.Ranges {
+		if r.Type == "GIT" {
+			continue
+		}
+
+		affected := false
+		for _, e := range r.Events {
+			switch {
+			case e.Introduced != "":
+				if e.Introduced == "0" || compareVersions(eco, version, e.Introduced) >= 0 {
+					affected = true
+				}
+			case e.Fixed != "":
+				if compareVersions(eco, version, e.Fixed) >= 0 {
+					affected = false
+				}
+			case e.LastAffected != "":
+				if compareVersions(eco, version, e.LastAffected) > 0 {
+					affected = false
+				}
+			}
+		}
+
+		if affected {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (a *OSVAffected) fixed() string {
+	for _, r := range a.Ranges {
+		for _, e := range r.Events {
+			if e.Fixed != "" {
+				return e.Fixed
+			}
+		}
+	}
+	return ""
+}
+
+var severities = []string{"UNKNOWN", "LOW", "MEDIUM", "HIGH", "CRITICAL"}
+
+func severityRank(s string) int {
+	s = strings.ToUpper(s)
+	if s == "MODERATE" {
+		s = "MEDIUM"
+	}
+	for i, name := range severities {
+		if name == s {
+			return i
+		}
+	}
+	return 0
+}
+
+func cvssRank(score float64) int {
+	switch {
+	case score >= 9:
+		return 4
+	case score >= 7:
+		return 3
+	case score >= 4:
+		return 2
+	case score > 0:
+		return 1
+	}
+	return 0
+}
+
+// cvss3Score computes the base score of a CVSS v3 vector.
+func cvss3Score(vector string) (float64, bool) {
+	metrics := make(map[string]string)
+	for _, part := range strings.Split(vector, "/") {
+		kv := strings.SplitN(part, ":", 2)
+		if len(kv) == 2 {
+			metrics[kv[0]] = kv[1]
+		}
+	}
+
+	if !strings.HasPrefix(metrics["CVSS"], "3") {
+		return 0, false
+	}
+
+	weights := map[string]map[string]float64{
+		"AV": {"N": 0.85, "A": 0.62, "L": 0.55, "P": 0.2},
+		"AC": {"L": 0.77, "H": 0.44},
+		"UI": {"N": 0.85, "R": 0.62},
+		"C":  {"H": 0.56, "L": 0.22, "N": 0},
+		"I":  {"H": 0.56, "L": 0.22, "N": 0},
+		"A":  {"H": 0.56, "L": 0.22, "N": 0},
+	}
+
+	v := make(map[string]float64)
+	for m, w := range weights {
+		x, ok := w[metrics[m]]
+		if !ok {
+			return 0, false
+		}
+		v[m] = x
+	}
+
+	changed := metrics["S"] == "C"
+
+	pr := map[string]float64{"N": 0.85, "L": 0.62, "H": 0.27}
+	if changed {
+		pr = map[string]float64{"N": 0.85, "L": 0.68, "H": 0.5}
+	}
+	prv, ok := pr[metrics["PR"]]
+	if !ok {
+		return 0, false
+	}
+
+	iss := 1 - (1-v["C"])*(1-v["I"])*(1-v["A"])
+
+	var impact float64
+	if changed {
+		impact = 7.52*(iss-0.029) - 3.25*math.Pow(iss-0.02, 15)
+	} else {
+		impact = 6.42 * iss
+	}
+
+	exploitability := 8.22 * v["AV"] * v["AC"] * prv * v["UI"]
+
+	if impact <= 0 {
+		return 0, true
+	}
+
+	roundup := func(x float64) float64 {
+		i := math.Round(x * 100000)
+		if math.Mod(i, 10000) == 0 {
+			return i / 100000
+		}
+		return (math.Floor(i/10000) + 1) / 10
+	}
+
+	if changed {
+		return roundup(math.Min(1.08*(impact+exploitability), 10)), true
+	}
+	return roundup(math.Min(impact+exploitability, 10)), true
+}
+
+// severity picks the most specific severity an advisory gives.
+func (v *OSV) severity(a *OSVAffected) int {
+	for _, m := range []map[string]interface{}{a.EcosystemSpecific, a.DatabaseSpecific, v.DatabaseSpecific} {
+		if s, ok := m["severity"].(string); ok {
+			if r := severityRank(s); r > 0 {
+				return r
+			}
+		}
+	}
+
+	for _, sevs := range [][]OSVSeverity{a.Severity, v.Severity} {
+		for _, s := range sevs {
+			if score, ok := cvss3Score(s.Score); ok {
+				return cvssRank(score)
+			}
+		}
+	}
+
+	return 0
+}
+
+func (v *OSV) cve() string {
+	if strings.HasPrefix(v.ID, "CVE-") {
+		return v.ID
+	}
+	for _, a := range v.Aliases {
+		if strings.HasPrefix(a, "CVE-") {
+			return a
+		}
+	}
+	return ""
+}
+
+type Finding struct {
+	pkg      *Package
+	vuln     *OSV
+	severity int
+	fixed    string
+}
+
+// match returns the advisories affecting the packages.
+func match(pkgs []*Package, vulns []*OSV) []*Finding {
+	type key struct{ typ, name string }
+
+	index := make(map[key][]*Package)
+	for _, p := range pkgs {
+		k := key{p.Type, packageName(p.Type, p)}
+		index[k] = append(index[k], p)
+	}
+
+	findings := make([]*Finding, 0)
+	seen := make(map[string]bool)
+
+	for _, v := range vulns {
+		for i := range v.Affected {
+			a := &v.Affected[i]
+
+			for typ := range ecosystems {
+				for _, p := range index[key{typ, normalizeName(typ, a.Package.Name)}] {
+					if !ecosystemMatches(a.Package.Ecosystem, p) || !a.affects(p.Version) {
+						continue
+					}
+
+					id := v.ID + " " + p.PURL + " " + p.Path
+					if seen[id] {
+						continue
+					}
+					seen[id] = true
+
+					findings = append(findings, &Finding{pkg: p, vuln: v, severity: v.severity(a), fixed: a.fixed()})
+				}
+			}
+		}
+	}
+
+	sort.Slice(findings, func(i, j int) bool {
+		a, b := findings[i], findings[j]
+		if a.severity != b.severity {
+			return a.severity > b.severity
+		}
+		if a.pkg.Name != b.pkg.Name {
+			return a.pkg.Name < b.pkg.Name
+		}
+		return a.vuln.ID < b.vuln.ID
+	})
+
+	return findings
+}
+
+func scan(cmd *cobra.Command, args []string) error {
+	if len(args) != 1 || scandb == "" {
+		return usageError(cmd)
+	}
+
+	failon := len(severities)
+	if scanfailon != "" {
+		failon = severityRank(scanfailon)
+		if failon == 0 {
+			return &exitError{code: ExitUsage, err: fmt.Errorf("unknown severity %q", scanfailon)}
+		}
+	}
+
+	vulns, err := loadAdvisories(scandb)
+	if err != nil {
+		return err
+	}
+
+	r, err := useReference(cmd, args[0])
+	if err != nil {
+		return err
+	}
+	name, ref := r.Name, r.Ref()
+
+	plat, err := platform(scanplatform)
+	if err != nil {
+		return err
+	}
+
+	client, err := connect(cmd)
+	if err != nil {
+		return err
+	}
+
+	m, _, err := client.ImageManifest(runctx, name, ref, plat)
+	if err != nil {
+		return fmt.Errorf("manifest: %w", err)
+	}
+
+	files, err := inventoryFiles(runctx, client, name, m.Layers)
+	if err != nil {
+		return err
+	}
+
+	findings := match(inventory(files), vulns)
+
+	n := len("PACKAGE")
+	for _, f := range findings {
+		if n < len(f.pkg.Name) {
+			n = len(f.pkg.Name)
+		}
+	}
+
+	fmt.Printf("%-*s %-20s %-20s %-16s %-8s %s\n", n, "PACKAGE", "VERSION", "ID", "CVE", "SEVERITY", "FIXED")
+
+	worst := 0
+	for _, f := range findings {
+		fmt.Printf("%-*s %-20s %-20s %-16s %-8s %s\n", n, f.pkg.Name, f.pkg.Version,
+			f.vuln.ID, f.vuln.cve(), severities[f.severity], f.fixed)
+
+		if f.severity > worst {
+			worst = f.severity
+		}
+	}
+
+	fmt.Printf("\n%d vulnerabilities\n", len(findings))
+
+	if worst >= failon {
+		return policyError("found %s severity vulnerabilities", severities[worst])
+	}
+
+	return nil
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/dbulkow/registry_cmd/registry/registrytest"
+)
+
+const advisories = `[
+{"id":"GHSA-1","aliases":["CVE-2021-23337"],"database_specific":{"severity":"HIGH"},
+ "affected":[{"package":{"ecosystem":"npm","name":"lodash"},
+  "ranges":[{"type":"SEMVER","events":[{"introduced":"0"},{"fixed":"4.17.21"}]}]}]},
+{"id":"ALPINE-1","affected":[{"package":{"ecosystem":"Alpine:v3.19","name":"musl"},
+  "ranges":[{"type":"ECOSYSTEM","events":[{"introduced":"0"},{"fixed":"1.2.4-r3"}]}]}]},
+{"id":"ALPINE-2","affected":[{"package":{"ecosystem":"Alpine:v3.18","name":"musl"},
+  "ranges":[{"type":"ECOSYSTEM","events":[{"introduced":"0"},{"fixed":"1.2.4-r9"}]}]}]},
+{"id":"PYSEC-1","database_specific":{"severity":"LOW"},
+ "affected":[{"package":{"ecosystem":"PyPI","name":"requests"},
+  "ranges":[{"type":"ECOSYSTEM","events":[{"introduced":"0"},{"fixed":"2.30.0"}]}]}]}
+]`
+
+func TestScan(t *testing.T) {
+	e := seeded(t)
+
+	db := filepath.Join(t.TempDir(), "osv.json")
+	if err := ioutil.WriteFile(db, []byte(advisories), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	out := e.ok("scan", "--db", db, "tools/mix:1")
+	contains(t, out, "GHSA-1", "CVE-2021-23337", "HIGH", "ALPINE-1", "1.2.4-r3")
+
+	// another release, and fixed before the installed version
+	lacks(t, out, "ALPINE-2", "PYSEC-1")
+
+	e.fails(ExitPolicy, "scan", "--db", db, "--fail-on", "high", "tools/mix:1")
+	e.ok("scan", "--db", db, "--fail-on", "critical", "tools/mix:1")
+	e.fails(ExitUsage, "scan", "--db", db, "--fail-on", "severe", "tools/mix:1")
+
+	e.fails(ExitUsage, "scan", "tools/mix:1")
+}
+
+func TestScanSource(t *testing.T) {
+	e := seeded(t)
+
+	e.reg.PushImage("app/ssl", "1", registrytest.Image{
+		Layers: [][]byte{registrytest.Layer(
+			registrytest.File{Name: "etc/os-release", Body: "ID=alpine\nVERSION_ID=3.19.1\n"},
+			registrytest.File{Name: "lib/apk/db/installed", Body: "P:libcrypto3\nV:3.1.4-r5\nA:x86_64\no:openssl\n\n"},
+		)},
+	})
+
+	// distributions name the source package, and letters follow a release
+	db := filepath.Join(t.TempDir(), "osv.json")
+	if err := ioutil.WriteFile(db, []byte(`[
+{"id":"ALPINE-SSL","affected":[{"package":{"ecosystem":"Alpine:v3.19","name":"openssl"},
+  "ranges":[{"type":"ECOSYSTEM","events":[{"introduced":"0"},{"fixed":"3.1.4a-r0"}]}]}]},
+{"id":"ALPINE-OLD","affected":[{"package":{"ecosystem":"Alpine:v3.19","name":"openssl"},
+  "ranges":[{"type":"ECOSYSTEM","events":[{"introduced":"0"},{"fixed":"3.1.4-r1"}]}]}]},
+{"id":"ALPINE-BIN","affected":[{"package":{"ecosystem":"Alpine:v3.19","name":"libcrypto3"},
+  "ranges":[{"type":"ECOSYSTEM","events":[{"introduced":"0"}]}]}]}
+]`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	out := e.ok("scan", "--db", db, "app/ssl:1")
+	contains(t, out, "ALPINE-SSL", "libcrypto3")
+	lacks(t, out, "ALPINE-OLD", "ALPINE-BIN")
+}
+
+func TestEcosystemMatches(t *testing.T) {
+	deb := &Package{Type: "deb", Distro: "debian-12"}
+	ubuntu := &Package{Type: "deb", Distro: "ubuntu-22.04"}
+	apk := &Package{Type: "apk", Distro: "alpine-3.19.1"}
+
+	tests := []struct {
+		ecosystem string
+		p         *Package
+		want      bool
+	}{
+		{"Debian", deb, true},
+		{"Debian:12", deb, true},
+		{"Debian:11", deb, false},
+		{"Ubuntu:22.04:LTS", ubuntu, true},
+		{"Ubuntu:Pro:22.04:LTS", ubuntu, true},
+		{"Ubuntu:Pro:20.04:LTS", ubuntu, false},
+		{"Ubuntu:22.04:LTS", deb, false},
+		{"Debian:12", ubuntu, false},
+		{"Alpine:v3.19", apk, true},
+		{"Alpine:v3.1", apk, false},
+		{"Alpine:v3.18", apk, false},
+		{"npm", apk, false},
+	}
+
+	for _, tt := range tests {
+		if got := ecosystemMatches(tt.ecosystem, tt.p); got != tt.want {
+			t.Errorf("ecosystemMatches(%q, %s) = %v, want %v", tt.ecosystem, tt.p.Distro, got, tt.want)
+		}
+	}
+}
+
+func TestCVSS3Score(t *testing.T) {
+	tests := []struct {
+		vector string
+		want   float64
+	}{
+		{"CVSS:3.1/AV:N/AC:L/PR:N/UI:N/S:U/C:H/I:H/A:H", 9.8},
+		{"CVSS:3.1/AV:N/AC:L/PR:N/UI:N/S:C/C:H/I:H/A:H", 10.0},
+		{"CVSS:3.0/AV:L/AC:L/PR:L/UI:N/S:U/C:H/I:H/A:H", 7.8},
+		{"CVSS:3.1/AV:N/AC:L/PR:N/UI:N/S:U/C:N/I:N/A:H", 7.5},
+		{"CVSS:3.1/AV:N/AC:L/PR:L/UI:N/S:C/C:L/I:L/A:N", 6.4},
+		{"CVSS:3.1/AV:N/AC:L/PR:N/UI:R/S:C/C:L/I:L/A:N", 6.1},
+		{"CVSS:3.1/AV:N/AC:H/PR:N/UI:N/S:U/C:H/I:N/A:N", 5.9},
+		{"CVSS:3.1/AV:P/AC:H/PR:H/UI:R/S:U/C:L/I:N/A:N", 1.6},
+		{"CVSS:3.1/AV:N/AC:L/PR:N/UI:N/S:U/C:N/I:N/A:N", 0},
+	}
+
+	for _, tt := range tests {
+		got, ok := cvss3Score(tt.vector)
+		if !ok || got != tt.want {
+			t.Errorf("cvss3Score(%q) = %v, %v, want %v", tt.vector, got, ok, tt.want)
+		}
+	}
+
+	for _, v := range []string{
+		"AV:N/AC:L/Au:N/C:P/I:P/A:P",
+		"CVSS:2.0/AV:N/AC:L/PR:N/UI:N/S:U/C:H/I:H/A:H",
+		"CVSS:3.1/AV:N/AC:L/UI:N/S:U/C:H/I:H/A:H",
+		"CVSS:3.1/AV:X/AC:L/PR:N/UI:N/S:U/C:H/I:H/A:H",
+	} {
+		if _, ok := cvss3Score(v); ok {
+			t.Errorf("cvss3Score(%q) succeeded", v)
+		}
+	}
+}
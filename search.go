@@ -0,0 +1,334 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/dbulkow/registry_cmd/registry"
+	"github.com/spf13/cobra"
+)
+
+var searchlabels, searchenvs, searchlayers []string
+var searchbase, searcharch, searchos string
+var searchafter, searchbefore string
+
+func init() {
+	searchCmd := &cobra.Command{
+		Use:   "search [glob]",
+		Short: "Find images by label, env, layer, base image or creation time",
+		Long: `Find images by label, env, layer, base image or creation time.
+
+Every tag of the repositories matching glob is checked, and all given
+predicates must match. Label and env values may contain * globs:
+
+  regcmd search --label org.opencontainers.image.source='*myrepo*'
+  regcmd search --layer sha256:... --arch arm64
+  regcmd search --created-after 2024-01-01 'team/*'
+
+Manifest lists match when any of their images do.
+`,
+		RunE: search,
+	}
+
+	flags := searchCmd.Flags()
+	flags.StringArrayVar(&searchlabels, "label", nil, "Label key=value glob, repeatable")
+	flags.StringArrayVar(&searchenvs, "env", nil, "Environment variable key=value glob, repeatable")
+	flags.StringArrayVar(&searchlayers, "layer", nil, "Layer or diff ID digest, repeatable")
+	flags.StringVar(&searchbase, "base", "", "Base image digest or name glob from the OCI base annotations")
+	flags.StringVar(&searcharch, "arch", "", "Architecture")
+	flags.StringVar(&searchos, "os", "", "Operating system")
+	flags.StringVar(&searchafter, "created-after", "", "Created after date (YYYY-MM-DD or RFC 3339)")
+	flags.StringVar(&searchbefore, "created-before", "", "Created before date (YYYY-MM-DD or RFC 3339)")
+
+	RootCmd.AddCommand(searchCmd)
+}
+
+func parseDate(s string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	return time.Parse("2006-01-02", s)
+}
+
+// keyGlob splits a key=glob predicate.
+func keyGlob(pred string) (string, string) {
+	kv := strings.SplitN(pred, "=", 2)
+	if len(kv) == 1 {
+		return kv[0], GLOB
+	}
+	return kv[0], kv[1]
+}
+
+type predicates struct {
+	labels  []string
+	envs    []string
+	layers  map[registry.Digest]bool
+	base    string
+	arch    string
+	os      string
+	after   time.Time
+	before  time.Time
+	configs map[registry.Digest]*registry.ImageConfig
+}
+
+func (p *predicates) needConfig() bool {
+	return len(p.labels) > 0 || len(p.envs) > 0 || len(p.layers) > 0 || p.base != "" ||
+		p.arch != "" || p.os != "" || !p.after.IsZero() || !p.before.IsZero()
+}
+
+func (p *predicates) config(ctx context.Context, client *registry.Client, image string, digest registry.Digest) (*registry.ImageConfig, error) {
+	if c, ok := p.configs[digest]; ok {
+		return c, nil
+	}
+
+	c, err := client.ImageConfig(ctx, image, digest)
+	if err != nil {
+		return nil, err
+	}
+
+	p.configs[digest] = c
+
+	return c, nil
+}
+
+// matches evaluates the predicates against one image manifest.
+func (p *predicates) matches(ctx context.Context, client *registry.Client, image string, m *registry.Manifest) (bool, error) {
+	if !p.needConfig() {
+		return true, nil
+	}
+
+	c, err := p.config(ctx, client, image, m.Config.Digest)
+	if err != nil {
+		return false, err
+	}
+
+	if p.arch != "" && c.Architecture != p.arch {
+		return false, nil
+	}
+	if p.os != "" && c.OS != p.os {
+		return false, nil
+	}
+
+	for _, pred := range p.labels {
+		k, glob := keyGlob(pred)
+		v, ok := c.Config.Labels[k]
+		if !ok || !Glob(glob, v) {
+			return false, nil
+		}
+	}
+
+	env := make(map[string]string)
+	for _, e := range c.Config.Env {
+		k, v := keyGlob(e)
+		env[k] = v
+	}
+
+	for _, pred := range p.envs {
+		k, glob := keyGlob(pred)
+		v, ok := env[k]
+		if !ok || !Glob(glob, v) {
+			return false, nil
+		}
+	}
+
+	if len(p.layers) > 0 {
+		found := 0
+		for _, l := range m.Layers {
+			if p.layers[l.Digest] {
+				found++
+			}
+		}
+		for _, d := range c.RootFS.DiffIDs {
+			if p.layers[d] {
+				found++
+			}
+		}
+		if found == 0 {
+			return false, nil
+		}
+	}
+
+	if p.base != "" {
+		name, digest := "", ""
+		for _, a := range []map[string]string{m.Annotations, c.Config.Labels} {
+			if name == "" {
+				name = a["org.opencontainers.image.base.name"]
+			}
+			if digest == "" {
+				digest = a["org.opencontainers.image.base.digest"]
+			}
+		}
+
+		if digest != p.base && (name == "" || !Glob(p.base, name)) {
+			return false, nil
+		}
+	}
+
+	if !p.after.IsZero() || !p.before.IsZero() {
+		created, err := time.Parse(time.RFC3339Nano, c.Created)
+		if err != nil {
+			return false, nil
+		}
+		if !p.after.IsZero() && !created.After(p.after) {
+			return false, nil
+		}
+		if !p.before.IsZero() && !created.Before(p.before) {
+			return false, nil
+		}
+	}
+
+	return true, nil
+}
+
+func search(cmd *cobra.Command, args []string) error {
+	var filter string
+	if len(args) > 0 {
+		var err error
+		if filter, err = useRepository(cmd, args[0]); err != nil {
+			return err
+		}
+	}
+
+	p := &predicates{
+		labels:  searchlabels,
+		envs:    searchenvs,
+		layers:  make(map[registry.Digest]bool),
+		base:    searchbase,
+		arch:    searcharch,
+		os:      searchos,
+		configs: make(map[registry.Digest]*registry.ImageConfig),
+	}
+
+	for _, l := range searchlayers {
+		p.layers[registry.Digest(l)] = true
+	}
+
+	var err error
+	if searchafter != "" {
+		if p.after, err = parseDate(searchafter); err != nil {
+			return &exitError{code: ExitUsage, err: fmt.Errorf("created-after: %w", err)}
+		}
+	}
+	if searchbefore != "" {
+		if p.before, err = parseDate(searchbefore); err != nil {
+			return &exitError{code: ExitUsage, err: fmt.Errorf("created-before: %w", err)}
+		}
+	}
+
+	client, err := connect(cmd)
+	if err != nil {
+		return err
+	}
+
+	images, err := client.Catalog(runctx)
+	if err != nil {
+		return err
+	}
+
+	var failures tally
+
+	for _, name := range images {
+		if filter != "" && !Glob(filter, name) {
+			continue
+		}
+
+		tags, err := client.Tags(runctx, name)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			failures.add(err)
+			continue
+		}
+
+		for _, tag := range tags {
+			if err := interrupted(); err != nil {
+				return err
+			}
+
+			platforms, err := searchTag(runctx, client, name, tag, p)
+			failures.add(err)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "%s:%s: %v\n", name, tag, err)
+				continue
+			}
+
+			if len(platforms) == 0 {
+				continue
+			}
+
+			fmt.Printf("%s:%s", name, tag)
+			if platforms[0] != "" {
+				fmt.Printf(" (%s)", strings.Join(platforms, ", "))
+			}
+			fmt.Println()
+		}
+	}
+
+	return failures.err("images")
+}
+
+// searchTag returns the platforms of the tag that match, with a single
+// empty entry for a matching image that is not a manifest list.
+func searchTag(ctx context.Context, client *registry.Client, image, tag string, p *predicates) ([]string, error) {
+	raw, err := client.RawManifest(ctx, image, tag)
+	if err != nil {
+		return nil, err
+	}
+
+	var probe struct {
+		SchemaVersion int                   `json:"schemaVersion"`
+		Manifests     []registry.Descriptor `json:"manifests"`
+	}
+
+	if err := json.Unmarshal(raw.Data, &probe); err != nil {
+		return nil, err
+	}
+
+	if probe.SchemaVersion != 2 {
+		return nil, nil
+	}
+
+	if probe.Manifests == nil {
+		m := &registry.Manifest{}
+		if err := json.Unmarshal(raw.Data, m); err != nil {
+			return nil, err
+		}
+
+		// artifacts such as signatures have no image config to search
+		if m.Config.MediaType == registry.MediaTypeOCIEmpty || m.ArtifactType != "" {
+			return nil, nil
+		}
+
+		ok, err := p.matches(ctx, client, image, m)
+		if err != nil || !ok {
+			return nil, err
+		}
+		return []string{""}, nil
+	}
+
+	platforms := make([]string, 0)
+	for _, d := range probe.Manifests {
+		m, _, err := client.ImageManifest(ctx, image, string(d.Digest), nil)
+		if err != nil {
+			return nil, err
+		}
+
+		ok, err := p.matches(ctx, client, image, m)
+		if err != nil {
+			return nil, err
+		}
+
+		if ok {
+			name := "unknown"
+			if d.Platform != nil {
+				name = d.Platform.String()
+			}
+			platforms = append(platforms, name)
+		}
+	}
+
+	return platforms, nil
+}
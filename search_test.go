@@ -0,0 +1,44 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/dbulkow/registry_cmd/registry"
+)
+
+func TestSearch(t *testing.T) {
+	e := seeded(t)
+
+	out := e.ok("search", "--label", "team=web")
+	contains(t, out, "app/web:1.0", "app/web:2.0")
+	lacks(t, out, "app/api", "tools/mix")
+
+	out = e.ok("search", "--env", "V=2")
+	contains(t, out, "app/web:2.0")
+	lacks(t, out, "app/web:1.0")
+
+	out = e.ok("search", "--label", "team=*", "app/*")
+	contains(t, out, "app/web:1.0", "app/api:latest")
+
+	out = e.ok("search", "--created-after", "2024-02-15")
+	contains(t, out, "app/web:2.0", "app/api:latest")
+	lacks(t, out, "app/web:1.0", "tools/mix")
+
+	contains(t, e.fails(ExitUsage, "search", "--created-after", "yesterday").stderr, "created-after")
+	contains(t, e.fails(ExitUsage, "search", "--created-before", "2024-13-01").stderr, "created-before")
+
+	out = e.ok("search", "--arch", "arm64")
+	contains(t, out, "multi/arch:1 (linux/arm64)")
+	lacks(t, out, "app/web")
+}
+
+func TestSearchLayer(t *testing.T) {
+	e := seeded(t)
+
+	out := e.ok("search", "--layer", string(registry.FromBytes(web1Layer)))
+	contains(t, out, "app/web:1.0")
+	lacks(t, out, "app/web:2.0")
+
+	out = e.ok("search", "--layer", string(registry.FromBytes(baseLayer)))
+	contains(t, out, "app/web:1.0", "app/web:2.0", "app/api:latest", "tools/mix:1")
+}
@@ -0,0 +1,343 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	neturl "net/url"
+	"os"
+	"strings"
+
+	"github.com/dbulkow/registry_cmd/registry"
+	"github.com/spf13/cobra"
+	"golang.org/x/crypto/nacl/secretbox"
+	"golang.org/x/crypto/scrypt"
+)
+
+const (
+	MediaTypeSimpleSigning = "application/vnd.dev.cosign.simplesigning.v1+json"
+	MediaTypeCosignSig     = "application/vnd.dev.cosign.artifact.sig.v1+json"
+
+	cosignSigAnnotation = "dev.cosignproject.cosign/signature"
+)
+
+var signkey string
+var signreferrer bool
+
+func init() {
+	signCmd := &cobra.Command{
+		Use:   "sign <image:tag>",
+		Short: "Sign an image with a local key",
+		Long: `Sign an image with a local ECDSA P-256 key and push the signature.
+
+The signature is stored cosign style under the sha256-<digest>.sig tag, or
+with --referrer as an OCI 1.1 referrer of the signed manifest.
+
+Keys are PEM encoded SEC 1 or PKCS #8, or encrypted keys as written by
+cosign generate-key-pair. Encrypted keys read their password from
+COSIGN_PASSWORD, or prompt for it when unset.
+`,
+		Annotations: mutating,
+		RunE:        sign,
+	}
+
+	signCmd.Flags().StringVarP(&signkey, "key", "k", "", "Private key file")
+	signCmd.Flags().BoolVar(&signreferrer, "referrer", false, "Push signature as an OCI 1.1 referrer")
+
+	RootCmd.AddCommand(signCmd)
+}
+
+func sign(cmd *cobra.Command, args []string) error {
+	if len(args) != 1 || signkey == "" {
+		return usageError(cmd)
+	}
+
+	r, err := useReference(cmd, args[0])
+	if err != nil {
+		return err
+	}
+	image, tag := r.Name, r.Ref()
+
+	key, err := loadKey(signkey)
+	if err != nil {
+		return err
+	}
+
+	client, err := connect(cmd)
+	if err != nil {
+		return err
+	}
+
+	m, err := client.RawManifest(runctx, image, tag)
+	if err != nil {
+		return fmt.Errorf("manifest: %w", err)
+	}
+
+	payload, err := simpleSigning(client.URL(), image, m.Digest)
+	if err != nil {
+		return err
+	}
+
+	hash := sha256.Sum256(payload)
+
+	sig, err := ecdsa.SignASN1(rand.Reader, key, hash[:])
+	if err != nil {
+		return fmt.Errorf("sign: %w", err)
+	}
+
+	b64sig := base64.StdEncoding.EncodeToString(sig)
+
+	var ref string
+	if signreferrer {
+		var d registry.Digest
+		d, err = client.PushReferrer(runctx, image, m, MediaTypeCosignSig, MediaTypeSimpleSigning, payload,
+			map[string]string{cosignSigAnnotation: b64sig})
+		ref = string(d)
+	} else {
+		ref, err = pushSignature(runctx, client, image, m.Digest, payload, b64sig)
+	}
+	if err != nil {
+		return fmt.Errorf("push signature: %w", err)
+	}
+
+	fmt.Printf("signed %s %s\n", r, ref)
+
+	return nil
+}
+
+// simpleSigning builds the payload cosign signs for a container image.
+func simpleSigning(url, image string, digest registry.Digest) ([]byte, error) {
+	u, err := neturl.Parse(url)
+	if err != nil {
+		return nil, err
+	}
+
+	type Identity struct {
+		DockerReference string `json:"docker-reference"`
+	}
+	type Image struct {
+		DockerManifestDigest string `json:"docker-manifest-digest"`
+	}
+	type Critical struct {
+		Identity Identity `json:"identity"`
+		Image    Image    `json:"image"`
+		Type     string   `json:"type"`
+	}
+
+	payload := struct {
+		Critical Critical    `json:"critical"`
+		Optional interface{} `json:"optional"`
+	}{
+		Critical: Critical{
+			Identity: Identity{DockerReference: u.Host + "/" + image},
+			Image:    Image{DockerManifestDigest: string(digest)},
+			Type:     "cosign container image signature",
+		},
+	}
+
+	return json.Marshal(payload)
+}
+
+// cosignKey is the encrypted envelope of a cosign private key: a PKCS #8
+// key sealed with nacl/secretbox under a key derived with scrypt.
+type cosignKey struct {
+	KDF struct {
+		Name   string `json:"name"`
+		Params struct {
+			N int `json:"N"`
+			R int `json:"r"`
+			P int `json:"p"`
+		} `json:"params"`
+		Salt []byte `json:"salt"`
+	} `json:"kdf"`
+	Cipher struct {
+		Name  string `json:"name"`
+		Nonce []byte `json:"nonce"`
+	} `json:"cipher"`
+	Ciphertext []byte `json:"ciphertext"`
+}
+
+// decryptCosignKey opens the envelope in data with pass and returns the
+// PKCS #8 key inside.
+func decryptCosignKey(data, pass []byte) ([]byte, error) {
+	var k cosignKey
+	if err := json.Unmarshal(data, &k); err != nil {
+		return nil, fmt.Errorf("cosign key: %v", err)
+	}
+
+	if k.KDF.Name != "scrypt" || k.Cipher.Name != "nacl/secretbox" {
+		return nil, fmt.Errorf("cosign key: unsupported kdf %q or cipher %q", k.KDF.Name, k.Cipher.Name)
+	}
+
+	var nonce [24]byte
+	if len(k.Cipher.Nonce) != len(nonce) {
+		return nil, fmt.Errorf("cosign key: %d byte nonce", len(k.Cipher.Nonce))
+	}
+	copy(nonce[:], k.Cipher.Nonce)
+
+	secret, err := scrypt.Key(pass, k.KDF.Salt, k.KDF.Params.N, k.KDF.Params.R, k.KDF.Params.P, 32)
+	if err != nil {
+		return nil, fmt.Errorf("cosign key: %v", err)
+	}
+
+	var box [32]byte
+	copy(box[:], secret)
+
+	der, ok := secretbox.Open(nil, k.Ciphertext, &nonce, &box)
+	if !ok {
+		return nil, errors.New("decrypt key: wrong password or corrupt key")
+	}
+
+	return der, nil
+}
+
+func loadKey(path string) (*ecdsa.PrivateKey, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM data in key file")
+	}
+
+	if _, ok := block.Headers["DEK-Info"]; ok {
+		return nil, errors.New("legacy PEM encrypted keys are not supported, use a cosign or PKCS #8 key")
+	}
+
+	var key interface{}
+
+	switch block.Type {
+	case "EC PRIVATE KEY":
+		key, err = x509.ParseECPrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "ENCRYPTED COSIGN PRIVATE KEY", "ENCRYPTED SIGSTORE PRIVATE KEY":
+		var pass, der []byte
+		if pass, err = password(); err != nil {
+			return nil, err
+		}
+		if der, err = decryptCosignKey(block.Bytes, pass); err != nil {
+			return nil, err
+		}
+
+		key, err = x509.ParsePKCS8PrivateKey(der)
+	default:
+		return nil, fmt.Errorf("unsupported key type %q", block.Type)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("parse key: %v", err)
+	}
+
+	ec, ok := key.(*ecdsa.PrivateKey)
+	if !ok || ec.Curve != elliptic.P256() {
+		return nil, errors.New("key is not ECDSA P-256")
+	}
+
+	return ec, nil
+}
+
+func password() ([]byte, error) {
+	if pass, ok := os.LookupEnv("COSIGN_PASSWORD"); ok {
+		return []byte(pass), nil
+	}
+
+	fmt.Fprint(os.Stderr, "Enter password for private key: ")
+
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil {
+		return nil, fmt.Errorf("read password: %v", err)
+	}
+
+	return []byte(strings.TrimRight(line, "\r\n")), nil
+}
+
+// pushSignature adds a signature layer to the cosign .sig image of digest,
+// creating the image if it does not exist yet.
+func pushSignature(ctx context.Context, client *registry.Client, image string, digest registry.Digest, payload []byte, sig string) (string, error) {
+	tag := digest.Tag(".sig")
+
+	sigman := &registry.Manifest{
+		SchemaVersion: 2,
+		MediaType:     registry.MediaTypeOCIManifest,
+	}
+
+	old, err := client.RawManifest(ctx, image, tag)
+	switch {
+	case err == nil:
+		if err := json.Unmarshal(old.Data, sigman); err != nil {
+			return "", fmt.Errorf("existing signatures: %v", err)
+		}
+	case !errors.Is(err, registry.ErrNotFound):
+		return "", err
+	}
+
+	pdigest, err := client.PushBlob(ctx, image, payload)
+	if err != nil {
+		return "", err
+	}
+
+	for _, l := range sigman.Layers {
+		if l.Digest == pdigest && l.Annotations[cosignSigAnnotation] == sig {
+			return tag, nil
+		}
+	}
+
+	sigman.Layers = append(sigman.Layers, registry.Descriptor{
+		MediaType:   MediaTypeSimpleSigning,
+		Size:        int64(len(payload)),
+		Digest:      pdigest,
+		Annotations: map[string]string{cosignSigAnnotation: sig},
+	})
+
+	diffids := make([]registry.Digest, 0, len(sigman.Layers))
+	for _, l := range sigman.Layers {
+		diffids = append(diffids, l.Digest)
+	}
+
+	config, err := json.Marshal(map[string]interface{}{
+		"architecture": "",
+		"os":           "",
+		"config":       struct{}{},
+		"rootfs": map[string]interface{}{
+			"type":     "layers",
+			"diff_ids": diffids,
+		},
+	})
+	if err != nil {
+		return "", err
+	}
+
+	cdigest, err := client.PushBlob(ctx, image, config)
+	if err != nil {
+		return "", err
+	}
+
+	sigman.Config = registry.Descriptor{
+		MediaType: registry.MediaTypeOCIConfig,
+		Size:      int64(len(config)),
+		Digest:    cdigest,
+	}
+
+	data, err := json.Marshal(sigman)
+	if err != nil {
+		return "", err
+	}
+
+	if _, err := client.PushManifest(ctx, image, tag, registry.MediaTypeOCIManifest, data); err != nil {
+		return "", err
+	}
+
+	return tag, nil
+}
@@ -0,0 +1,280 @@
+package main
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+var unpackplatform string
+
+func init() {
+	unpackCmd := &cobra.Command{
+		Use:   "unpack <image:tag> <dir>",
+		Short: "Extract the root filesystem of an image",
+		Long: `Extract the flattened root filesystem of an image into a directory.
+
+Layers are applied in order with whiteouts and opaque directories honored.
+Symbolic links are resolved inside the directory, so no entry can be
+written outside of it. Device nodes are skipped and ownership is not
+restored.
+`,
+		RunE: unpack,
+	}
+
+	unpackCmd.Flags().StringVar(&unpackplatform, "platform", "", "Platform to select from manifest lists (os/arch[/variant])")
+
+	RootCmd.AddCommand(unpackCmd)
+}
+
+// securePath maps name to a path below root, resolving symbolic links in
+// the directories leading to it as if root were the filesystem root. The
+// last element is not followed unless follow is set.
+func securePath(root, name string, follow bool) (string, error) {
+	parts := strings.Split(strings.TrimPrefix(cleanPath(name), "/"), "/")
+	cur := "/"
+
+	for hops := 0; len(parts) > 0; {
+		part := parts[0]
+		parts = parts[1:]
+
+		if part == "" {
+			continue
+		}
+
+		next := path.Join(cur, part)
+
+		if len(parts) == 0 && !follow {
+			cur = next
+			break
+		}
+
+		fi, err := os.Lstat(filepath.Join(root, filepath.FromSlash(next)))
+		if err != nil || fi.Mode()&os.ModeSymlink == 0 {
+			cur = next
+			continue
+		}
+
+		if hops++; hops > 255 {
+			return "", fmt.Errorf("%s: too many levels of symbolic links", name)
+		}
+
+		link, err := os.Readlink(filepath.Join(root, filepath.FromSlash(next)))
+		if err != nil {
+			return "", err
+		}
+
+		if path.IsAbs(link) {
+			cur = "/"
+		}
+
+		parts = append(strings.Split(link, "/"), parts...)
+	}
+
+	return filepath.Join(root, filepath.FromSlash(path.Clean(cur))), nil
+}
+
+// clearLower removes what lower layers left below dir, keeping the paths
+// in fresh, which the current layer wrote.
+func clearLower(dir string, fresh map[string]bool) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	for _, e := range entries {
+		p := filepath.Join(dir, e.Name())
+
+		if !fresh[p] {
+			if err := os.RemoveAll(p); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if e.IsDir() {
+			if err := clearLower(p, fresh); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// applyEntry writes one tar entry of a layer below root. Directories are
+// left writable and their modes recorded in dirs, to be set once every
+// layer is applied. fresh holds the paths the layer has written so far,
+// and their parents, as whiteouts only hide lower layers.
+func applyEntry(root string, hdr *tar.Header, r io.Reader, dirs map[string]*tar.Header, fresh map[string]bool) error {
+	if p, opaque, ok := whiteout(hdr.Name); ok {
+		target, err := securePath(root, p, opaque)
+		if err != nil {
+			return err
+		}
+
+		if opaque {
+			return clearLower(target, fresh)
+		}
+
+		if fresh[target] {
+			return nil
+		}
+
+		return os.RemoveAll(target)
+	}
+
+	if cleanPath(hdr.Name) == "/" {
+		return nil
+	}
+
+	target, err := securePath(root, hdr.Name, false)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return err
+	}
+
+	for p := target; p != root && !fresh[p]; p = filepath.Dir(p) {
+		fresh[p] = true
+	}
+
+	if fi, err := os.Lstat(target); err == nil {
+		if !(fi.IsDir() && hdr.Typeflag == tar.TypeDir) {
+			if err := os.RemoveAll(target); err != nil {
+				return err
+			}
+		}
+	}
+
+	mode := hdr.FileInfo().Mode().Perm()
+
+	switch hdr.Typeflag {
+	case tar.TypeDir:
+		if err := os.MkdirAll(target, 0755); err != nil {
+			return err
+		}
+		dirs[target] = hdr
+		return nil
+
+	case tar.TypeReg:
+		f, err := os.OpenFile(target, os.O_CREATE|os.O_EXCL|os.O_WRONLY, mode)
+		if err != nil {
+			return err
+		}
+
+		if _, err := io.Copy(f, r); err != nil {
+			f.Close()
+			return err
+		}
+
+		if err := f.Close(); err != nil {
+			return err
+		}
+
+	case tar.TypeSymlink:
+		return os.Symlink(hdr.Linkname, target)
+
+	case tar.TypeLink:
+		src, err := securePath(root, hdr.Linkname, false)
+		if err != nil {
+			return err
+		}
+
+		return os.Link(src, target)
+
+	default:
+		return nil
+	}
+
+	return os.Chtimes(target, hdr.ModTime, hdr.ModTime)
+}
+
+func unpack(cmd *cobra.Command, args []string) error {
+	if len(args) != 2 {
+		return usageError(cmd)
+	}
+
+	r, err := useReference(cmd, args[0])
+	if err != nil {
+		return err
+	}
+	name, ref := r.Name, r.Ref()
+
+	root, err := filepath.Abs(args[1])
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(root, 0755); err != nil {
+		return err
+	}
+
+	plat, err := platform(unpackplatform)
+	if err != nil {
+		return err
+	}
+
+	client, err := connect(cmd)
+	if err != nil {
+		return err
+	}
+
+	m, digest, err := client.ImageManifest(runctx, name, ref, plat)
+	if err != nil {
+		return fmt.Errorf("manifest: %w", err)
+	}
+
+	dirs := make(map[string]*tar.Header)
+
+	for _, l := range m.Layers {
+		fresh := make(map[string]bool)
+
+		err := walkLayer(runctx, client, name, l.Digest, func(hdr *tar.Header, r io.Reader) error {
+			if err := applyEntry(root, hdr, r, dirs, fresh); err != nil {
+				return fmt.Errorf("layer %s: %s: %v", l.Digest, hdr.Name, err)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	// deepest first, so restricting a parent cannot block its children
+	paths := make([]string, 0, len(dirs))
+	for p := range dirs {
+		paths = append(paths, p)
+	}
+	sort.Sort(sort.Reverse(sort.StringSlice(paths)))
+
+	for _, p := range paths {
+		hdr := dirs[p]
+
+		fi, err := os.Lstat(p)
+		if err != nil || !fi.IsDir() {
+			continue
+		}
+
+		if err := os.Chtimes(p, hdr.ModTime, hdr.ModTime); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+		}
+
+		if err := os.Chmod(p, hdr.FileInfo().Mode().Perm()); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+		}
+	}
+
+	fmt.Printf("unpacked %s %s to %s\n", args[0], digest, root)
+
+	return nil
+}
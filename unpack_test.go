@@ -0,0 +1,163 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/dbulkow/registry_cmd/registry/registrytest"
+)
+
+func TestUnpack(t *testing.T) {
+	e := seeded(t)
+
+	dir := t.TempDir()
+	e.ok("unpack", "app/web:2.0", dir)
+
+	data, err := ioutil.ReadFile(filepath.Join(dir, "srv/index.html"))
+	if err != nil || string(data) != "v2\n" {
+		t.Errorf("srv/index.html = %q, %v", data, err)
+	}
+
+	if _, err := os.Lstat(filepath.Join(dir, "etc/motd")); !os.IsNotExist(err) {
+		t.Errorf("whited out etc/motd unpacked: %v", err)
+	}
+
+	dir = t.TempDir()
+	e.ok("unpack", "app/api:latest", dir)
+
+	if link, err := os.Readlink(filepath.Join(dir, "app/current")); err != nil || link != "api" {
+		t.Errorf("app/current -> %q, %v", link, err)
+	}
+	if fi, err := os.Stat(filepath.Join(dir, "app/api")); err != nil || fi.Mode()&0111 == 0 {
+		t.Errorf("app/api not executable: %v", err)
+	}
+}
+
+func TestUnpackEscape(t *testing.T) {
+	e := newEnv(t)
+
+	e.reg.PushImage("evil", "1", registrytest.Image{
+		Layers: [][]byte{registrytest.Layer(
+			registrytest.File{Name: "../escaped", Body: "x"},
+		)},
+	})
+
+	parent := t.TempDir()
+	dir := filepath.Join(parent, "root")
+
+	e.run("unpack", "evil:1", dir)
+
+	if _, err := os.Stat(filepath.Join(parent, "escaped")); !os.IsNotExist(err) {
+		t.Errorf("entry escaped the root: %v", err)
+	}
+}
+
+func TestUnpackOpaque(t *testing.T) {
+	e := seeded(t)
+	pushOpaque(e.reg)
+
+	dir := t.TempDir()
+	e.ok("unpack", "app/opaque:1", dir)
+
+	for name, want := range map[string]string{"etc/os-release": "ID=alpine\nVERSION_ID=3.20.0\n", "etc/hostname": "opaque\n"} {
+		if data, err := ioutil.ReadFile(filepath.Join(dir, name)); err != nil || string(data) != want {
+			t.Errorf("%s = %q, %v", name, data, err)
+		}
+	}
+
+	for _, name := range []string{"etc/motd", "etc/.wh..wh..opq"} {
+		if _, err := os.Lstat(filepath.Join(dir, name)); !os.IsNotExist(err) {
+			t.Errorf("%s unpacked: %v", name, err)
+		}
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, "lib/apk/db/installed")); err != nil {
+		t.Errorf("outside the opaque directory: %v", err)
+	}
+}
+
+func TestUnpackSymlinkEscape(t *testing.T) {
+	e := newEnv(t)
+
+	parent := t.TempDir()
+	dir := filepath.Join(parent, "root")
+
+	secret := filepath.Join(parent, "secret")
+	if err := ioutil.WriteFile(secret, []byte("secret\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	e.reg.PushImage("evil", "1", registrytest.Image{
+		Layers: [][]byte{
+			registrytest.Layer(
+				registrytest.File{Name: "abs", Type: '2', Linkname: parent},
+				registrytest.File{Name: "rel", Type: '2', Linkname: "../../.."},
+				registrytest.File{Name: "sub/", Type: '5'},
+				registrytest.File{Name: "sub/up", Type: '2', Linkname: "../../"},
+			),
+			registrytest.Layer(
+				registrytest.File{Name: "abs/pwned-abs", Body: "x"},
+				registrytest.File{Name: "rel/pwned-rel", Body: "x"},
+				registrytest.File{Name: "sub/up/pwned-up", Body: "x"},
+				registrytest.File{Name: "abs/secret", Body: "overwritten\n"},
+				registrytest.File{Name: "hard", Type: '1', Linkname: "abs/secret"},
+			),
+		},
+	})
+
+	e.run("unpack", "evil:1", dir)
+
+	// absolute links resolve as if the root were /
+	for name, kept := range map[string]string{
+		"pwned-abs": filepath.Join(dir, parent, "pwned-abs"),
+		"pwned-rel": filepath.Join(dir, "pwned-rel"),
+		"pwned-up":  filepath.Join(dir, "pwned-up"),
+	} {
+		if _, err := os.Stat(filepath.Join(parent, name)); !os.IsNotExist(err) {
+			t.Errorf("%s escaped the root: %v", name, err)
+		}
+		if _, err := os.Stat(kept); err != nil {
+			t.Errorf("%s not kept in the root: %v", name, err)
+		}
+	}
+
+	if data, err := ioutil.ReadFile(secret); err != nil || string(data) != "secret\n" {
+		t.Errorf("file outside the root = %q, %v", data, err)
+	}
+
+	outside, _ := os.Stat(secret)
+	if fi, err := os.Stat(filepath.Join(dir, "hard")); err == nil && os.SameFile(fi, outside) {
+		t.Error("hard link to a file outside the root")
+	}
+}
+
+func TestUnpackHardlinkEscape(t *testing.T) {
+	e := newEnv(t)
+
+	parent := t.TempDir()
+	dir := filepath.Join(parent, "root")
+
+	secret := filepath.Join(parent, "secret")
+	if err := ioutil.WriteFile(secret, []byte("secret\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	e.reg.PushImage("evil", "1", registrytest.Image{
+		Layers: [][]byte{registrytest.Layer(
+			registrytest.File{Name: "hard", Type: '1', Linkname: "../secret"},
+			registrytest.File{Name: "abs", Type: '1', Linkname: secret},
+		)},
+	})
+
+	// the links point at files the root does not have
+	e.fails(ExitFailure, "unpack", "evil:1", dir)
+
+	outside, _ := os.Stat(secret)
+	for _, name := range []string{"hard", "abs"} {
+		if fi, err := os.Stat(filepath.Join(dir, name)); err == nil && os.SameFile(fi, outside) {
+			t.Errorf("%s is a hard link to a file outside the root", name)
+		}
+	}
+}
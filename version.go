@@ -0,0 +1,309 @@
+package main
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// compareVersions orders two package versions the way the ecosystem of
+// the package manager does, returning -1, 0 or 1.
+func compareVersions(ecosystem, a, b string) int {
+	switch ecosystem {
+	case "Debian", "Ubuntu":
+		return dpkgCompare(a, b)
+	case "Go", "npm":
+		return semverCompare(a, b)
+	case "Alpine":
+		return apkCompare(a, b)
+	case "PyPI":
+		return naturalCompare(pep440(a), pep440(b))
+	}
+
+	return naturalCompare(a, b)
+}
+
+func sign3(n int) int {
+	switch {
+	case n < 0:
+		return -1
+	case n > 0:
+		return 1
+	}
+	return 0
+}
+
+// semverCompare compares semantic versions, tolerating the v and go
+// prefixes used by Go modules and toolchains.
+func semverCompare(a, b string) int {
+	split := func(v string) ([]string, string) {
+		v = strings.TrimPrefix(strings.TrimPrefix(v, "go"), "v")
+		if i := strings.Index(v, "+"); i >= 0 {
+			v = v[:i]
+		}
+		pre := ""
+		if i := strings.Index(v, "-"); i >= 0 {
+			v, pre = v[:i], v[i+1:]
+		}
+		return strings.Split(v, "."), pre
+	}
+
+	ac, apre := split(a)
+	bc, bpre := split(b)
+
+	for i := 0; i < len(ac) || i < len(bc); i++ {
+		var x, y int
+		if i < len(ac) {
+			x, _ = strconv.Atoi(ac[i])
+		}
+		if i < len(bc) {
+			y, _ = strconv.Atoi(bc[i])
+		}
+		if x != y {
+			return sign3(x - y)
+		}
+	}
+
+	switch {
+	case apre == bpre:
+		return 0
+	case apre == "":
+		return 1
+	case bpre == "":
+		return -1
+	}
+
+	ap, bp := strings.Split(apre, "."), strings.Split(bpre, ".")
+	for i := 0; i < len(ap) && i < len(bp); i++ {
+		x, xerr := strconv.Atoi(ap[i])
+		y, yerr := strconv.Atoi(bp[i])
+
+		switch {
+		case xerr == nil && yerr == nil:
+			if x != y {
+				return sign3(x - y)
+			}
+		case xerr == nil:
+			return -1
+		case yerr == nil:
+			return 1
+		default:
+			if c := strings.Compare(ap[i], bp[i]); c != 0 {
+				return c
+			}
+		}
+	}
+
+	return sign3(len(ap) - len(bp))
+}
+
+// dpkgCompare implements the Debian [epoch:]upstream[-revision] ordering.
+func dpkgCompare(a, b string) int {
+	split := func(v string) (int, string, string) {
+		epoch := 0
+		if i := strings.Index(v, ":"); i >= 0 {
+			epoch, _ = strconv.Atoi(v[:i])
+			v = v[i+1:]
+		}
+		rev := ""
+		if i := strings.LastIndex(v, "-"); i >= 0 {
+			v, rev = v[:i], v[i+1:]
+		}
+		return epoch, v, rev
+	}
+
+	ae, au, ar := split(a)
+	be, bu, br := split(b)
+
+	if ae != be {
+		return sign3(ae - be)
+	}
+	if c := dpkgPart(au, bu); c != 0 {
+		return c
+	}
+	return dpkgPart(ar, br)
+}
+
+func dpkgOrder(c byte) int {
+	switch {
+	case c == '~':
+		return -1
+	case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z':
+		return int(c)
+	case c == 0:
+		return 0
+	}
+	return int(c) + 256
+}
+
+func isDigit(c byte) bool { return c >= '0' && c <= '9' }
+
+func dpkgPart(a, b string) int {
+	for len(a) > 0 || len(b) > 0 {
+		for (len(a) > 0 && !isDigit(a[0])) || (len(b) > 0 && !isDigit(b[0])) {
+			var x, y byte
+			if len(a) > 0 && !isDigit(a[0]) {
+				x = a[0]
+			}
+			if len(b) > 0 && !isDigit(b[0]) {
+				y = b[0]
+			}
+			if d := dpkgOrder(x) - dpkgOrder(y); d != 0 {
+				return sign3(d)
+			}
+			if x != 0 {
+				a = a[1:]
+			}
+			if y != 0 {
+				b = b[1:]
+			}
+		}
+
+		var x, y string
+		x, a = leadingDigits(a)
+		y, b = leadingDigits(b)
+
+		if c := compareNumbers(x, y); c != 0 {
+			return c
+		}
+	}
+
+	return 0
+}
+
+func leadingDigits(s string) (string, string) {
+	i := 0
+	for i < len(s) && isDigit(s[i]) {
+		i++
+	}
+	return s[:i], s[i:]
+}
+
+func compareNumbers(x, y string) int {
+	x = strings.TrimLeft(x, "0")
+	y = strings.TrimLeft(y, "0")
+	if len(x) != len(y) {
+		return sign3(len(x) - len(y))
+	}
+	return strings.Compare(x, y)
+}
+
+// apkCompare compares Alpine package versions: the upstream version first,
+// then the -r package release.
+func apkCompare(a, b string) int {
+	split := func(v string) (string, string) {
+		if i := strings.LastIndex(v, "-r"); i >= 0 {
+			return v[:i], v[i+2:]
+		}
+		return v, "0"
+	}
+
+	av, ar := split(a)
+	bv, br := split(b)
+
+	if c := naturalCompare(av, bv); c != 0 {
+		return c
+	}
+
+	return compareNumbers(ar, br)
+}
+
+// prerelease suffixes sort before the release they precede. Single
+// letters are not among them: Alpine and OpenSSL use those for releases
+// after, as in 1.1.1w.
+var prerelease = map[string]bool{
+	"alpha": true, "beta": true, "pre": true, "rc": true, "dev": true,
+}
+
+// pep440abbrev matches the a, b and c abbreviations of Python pre-releases.
+var pep440abbrev = regexp.MustCompile(`([0-9])[._-]?([abc])([0-9]*)(?:$|[._+-])`)
+
+// pep440 spells out the pre-release abbreviations of Python version v.
+func pep440(v string) string {
+	m := pep440abbrev.FindStringSubmatchIndex(strings.ToLower(v))
+	if m == nil {
+		return v
+	}
+
+	word := map[string]string{"a": "alpha", "b": "beta", "c": "rc"}[strings.ToLower(v[m[4]:m[5]])]
+
+	return v[:m[3]] + word + v[m[6]:]
+}
+
+// naturalCompare orders versions by their numeric and alphabetic runs,
+// with the pre-release words of Alpine and Python sorting before a
+// release and other letters after it. It is used where no stricter ordering is implemented.
+func naturalCompare(a, b string) int {
+	tokens := func(v string) []string {
+		out := make([]string, 0)
+		for len(v) > 0 {
+			switch {
+			case isDigit(v[0]):
+				var d string
+				d, v = leadingDigits(v)
+				out = append(out, d)
+			case isLetter(v[0]):
+				i := 0
+				for i < len(v) && isLetter(v[i]) {
+					i++
+				}
+				out = append(out, strings.ToLower(v[:i]))
+				v = v[i:]
+			default:
+				v = v[1:]
+			}
+		}
+		return out
+	}
+
+	at, bt := tokens(a), tokens(b)
+
+	for i := 0; i < len(at) || i < len(bt); i++ {
+		switch {
+		case i >= len(at):
+			if prerelease[bt[i]] {
+				return 1
+			}
+			return -1
+		case i >= len(bt):
+			if prerelease[at[i]] {
+				return -1
+			}
+			return 1
+		}
+
+		x, y := at[i], bt[i]
+		xnum, ynum := isDigit(x[0]), isDigit(y[0])
+
+		switch {
+		case xnum && ynum:
+			if c := compareNumbers(x, y); c != 0 {
+				return c
+			}
+		case xnum:
+			if prerelease[y] {
+				return 1
+			}
+			return -1
+		case ynum:
+			if prerelease[x] {
+				return -1
+			}
+			return 1
+		case x != y:
+			if prerelease[x] != prerelease[y] {
+				if prerelease[x] {
+					return -1
+				}
+				return 1
+			}
+			return strings.Compare(x, y)
+		}
+	}
+
+	return 0
+}
+
+func isLetter(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
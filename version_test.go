@@ -0,0 +1,143 @@
+package main
+
+import "testing"
+
+type versionTest struct {
+	a, b string
+	want int
+}
+
+// checkOrder checks each pair both ways round.
+func checkOrder(t *testing.T, name string, compare func(a, b string) int, tests []versionTest) {
+	t.Helper()
+
+	for _, tt := range tests {
+		if got := compare(tt.a, tt.b); got != tt.want {
+			t.Errorf("%s(%q, %q) = %d, want %d", name, tt.a, tt.b, got, tt.want)
+		}
+		if got := compare(tt.b, tt.a); got != -tt.want {
+			t.Errorf("%s(%q, %q) = %d, want %d", name, tt.b, tt.a, got, -tt.want)
+		}
+	}
+}
+
+func TestDpkgCompare(t *testing.T) {
+	checkOrder(t, "dpkgCompare", dpkgCompare, []versionTest{
+		{"1.0", "1.0", 0},
+		{"1.0", "1.1", -1},
+		{"1.10", "1.9", 1},
+		{"1.01", "1.1", 0},
+		{"0:1.0", "1.0", 0},
+
+		// epochs outrank everything else
+		{"1:1.0", "2.0", 1},
+		{"2:0.1", "1:9.9", 1},
+
+		// tilde sorts before anything, even the end of the version
+		{"1.0~rc1", "1.0", -1},
+		{"1.0~rc1", "1.0~rc2", -1},
+		{"1.0~~", "1.0~", -1},
+		{"1.0~beta", "1.0~alpha", 1},
+
+		// letters before other characters
+		{"1.0a", "1.0+", -1},
+		{"1.0", "1.0a", -1},
+		{"1.0+b1", "1.0", 1},
+
+		// revisions, split at the last hyphen
+		{"1.0-1", "1.0-2", -1},
+		{"1.0-10", "1.0-9", 1},
+		{"1.0-1", "1.0", 1},
+		{"2.36-9+deb12u4", "2.36-9+deb12u10", -1},
+		{"1.2-3-4", "1.2-3-5", -1},
+		{"1.2-3-4", "1.2-4", 1},
+		{"1.1.1n-0+deb11u5", "1.1.1w-0+deb11u1", -1},
+	})
+}
+
+func TestSemverCompare(t *testing.T) {
+	checkOrder(t, "semverCompare", semverCompare, []versionTest{
+		{"1.2.3", "1.2.3", 0},
+		{"v1.2.3", "1.2.3", 0},
+		{"go1.21.5", "1.21.5", 0},
+		{"1.2.3", "1.2.4", -1},
+		{"1.10.0", "1.9.0", 1},
+		{"1.2", "1.2.0", 0},
+		{"1.2.3+build.7", "1.2.3", 0},
+
+		// pre-releases order before their release, by field
+		{"1.0.0-alpha", "1.0.0", -1},
+		{"1.0.0-alpha", "1.0.0-alpha.1", -1},
+		{"1.0.0-alpha.1", "1.0.0-alpha.beta", -1},
+		{"1.0.0-alpha.beta", "1.0.0-beta", -1},
+		{"1.0.0-beta.2", "1.0.0-beta.11", -1},
+		{"1.0.0-beta.11", "1.0.0-rc.1", -1},
+		{"1.0.0-rc.1", "1.0.0", -1},
+		{"v0.0.0-20220525230936-793ad666bf5e", "v0.1.0", -1},
+	})
+}
+
+func TestNaturalCompare(t *testing.T) {
+	checkOrder(t, "naturalCompare", naturalCompare, []versionTest{
+		{"1.2.4-r2", "1.2.4-r2", 0},
+		{"1.2.4-r2", "1.2.4-r10", -1},
+		{"1.2.4-r10", "1.2.5-r0", -1},
+		{"3.1.4", "3.1.4-r0", -1},
+		{"1.10", "1.9", 1},
+
+		// pre-release words sort before the release
+		{"2.0.0rc1", "2.0.0", -1},
+		{"2.0.0alpha1", "2.0.0beta1", -1},
+		{"2.0.0beta1", "2.0.0rc1", -1},
+		{"1.0_alpha", "1.0", -1},
+		{"1.0.dev1", "1.0", -1},
+		{"1.0.post1", "1.0", 1},
+		{"1.0RC1", "1.0rc1", 0},
+
+		// single letters follow the release, as OpenSSL's do
+		{"1.1.1w", "1.1.1", 1},
+		{"1.1.1w-r0", "1.1.1v-r0", 1},
+		{"1.1.1a", "1.1.2", -1},
+	})
+}
+
+func TestPEP440(t *testing.T) {
+	for v, want := range map[string]string{
+		"2.0.0a1":        "2.0.0alpha1",
+		"2.0.0b1":        "2.0.0beta1",
+		"2.0.0c1":        "2.0.0rc1",
+		"2.0.0.b2.post1": "2.0.0beta2.post1",
+		"2.0.0rc1":       "2.0.0rc1",
+		"2.0.0":          "2.0.0",
+	} {
+		if got := pep440(v); got != want {
+			t.Errorf("pep440(%q) = %q, want %q", v, got, want)
+		}
+	}
+}
+
+func TestCompareVersions(t *testing.T) {
+	tests := []struct {
+		ecosystem, a, b string
+		want            int
+	}{
+		{"Debian", "1.0~rc1", "1.0", -1},
+		{"Ubuntu", "1:1.0", "2.0", 1},
+		{"Go", "v1.0.0-rc.1", "v1.0.0", -1},
+		{"npm", "4.17.20", "4.17.21", -1},
+		{"Alpine", "1.2.4-r2", "1.2.4-r10", -1},
+		{"PyPI", "2.31.0", "2.31.0rc1", 1},
+		{"PyPI", "2.0.0a1", "2.0.0b1", -1},
+		{"PyPI", "2.0.0b1", "2.0.0rc1", -1},
+		{"PyPI", "2.0.0c1", "2.0.0", -1},
+		{"Alpine", "1.1.1w-r0", "1.1.1-r0", 1},
+		{"Alpine", "3.1.4-r5", "3.1.4a-r0", -1},
+		{"Alpine", "3.1.4_rc1-r5", "3.1.4-r0", -1},
+	}
+
+	for _, tt := range tests {
+		if got := compareVersions(tt.ecosystem, tt.a, tt.b); got != tt.want {
+			t.Errorf("compareVersions(%s, %q, %q) = %d, want %d", tt.ecosystem, tt.a, tt.b, got, tt.want)
+		}
+	}
+}